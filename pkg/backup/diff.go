@@ -0,0 +1,312 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+const uciConfigDir = "etc/config/" // 备份中uci配置文件所在目录
+
+// 文件变化类型
+const (
+	FileAdded    = "added"
+	FileRemoved  = "removed"
+	FileModified = "modified"
+)
+
+// FileDiff 两份备份之间一个文件的变化
+type FileDiff struct {
+	Path    string               // 文件在备份中的路径, 如 etc/config/network
+	Status  string               // 变化类型, 取值为 File* 常量
+	Changes []*openwrt.UCIChange // uci配置文件的选项级变化, 其他文件为空
+}
+
+// ReadArchive 读取tar.gz备份中的所有普通文件, 路径去掉开头的 ./ 及 /
+func ReadArchive(r io.Reader) (files map[string][]byte, err error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = gz.Close()
+	}()
+	files = map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		var hdr *tar.Header
+		if hdr, err = tr.Next(); err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		buf := &bytes.Buffer{}
+		if _, err = io.Copy(buf, tr); err != nil {
+			return
+		}
+		files[strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")] = buf.Bytes()
+	}
+}
+
+// Diff 比较两份备份, 按路径排序返回有变化的文件; uci配置文件同时给出选项级变化
+func Diff(old, cur io.Reader) (diffs []*FileDiff, err error) {
+	before, err := ReadArchive(old)
+	if err != nil {
+		return
+	}
+	after, err := ReadArchive(cur)
+	if err != nil {
+		return
+	}
+
+	paths := map[string]bool{}
+	for p := range before {
+		paths[p] = true
+	}
+	for p := range after {
+		paths[p] = true
+	}
+	for p := range paths {
+		a, inBefore := before[p]
+		b, inAfter := after[p]
+		diff := &FileDiff{Path: p}
+		switch {
+		case !inBefore:
+			diff.Status = FileAdded
+		case !inAfter:
+			diff.Status = FileRemoved
+		case !bytes.Equal(a, b):
+			diff.Status = FileModified
+		default:
+			continue
+		}
+		if strings.HasPrefix(p, uciConfigDir) {
+			if diff.Changes, err = DiffUCI(path.Base(p), a, b); err != nil {
+				return nil, fmt.Errorf("%s: %w", p, err)
+			}
+			// 只有格式或注释变化
+			if diff.Status == FileModified && len(diff.Changes) == 0 {
+				continue
+			}
+		}
+		diffs = append(diffs, diff)
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+	return
+}
+
+// uciFileSection uci配置文件中的配置节
+type uciFileSection struct {
+	key     string // 有名称时为名称, 匿名时为 @类型[序号]
+	typ     string
+	options map[string]string
+	lists   map[string][]string
+}
+
+// DiffUCI 比较同一uci配置的两个版本, 返回从old变为cur所需的修改; 匿名配置节以 @类型[序号] 匹配
+func DiffUCI(config string, old, cur []byte) (changes []*openwrt.UCIChange, err error) {
+	before, err := parseUCI(old)
+	if err != nil {
+		return
+	}
+	after, err := parseUCI(cur)
+	if err != nil {
+		return
+	}
+	beforeByKey := map[string]*uciFileSection{}
+	for _, s := range before {
+		beforeByKey[s.key] = s
+	}
+	afterByKey := map[string]bool{}
+
+	for _, s := range after {
+		afterByKey[s.key] = true
+		prev, ok := beforeByKey[s.key]
+		if !ok || prev.typ != s.typ {
+			if ok {
+				changes = append(changes, &openwrt.UCIChange{Config: config, Op: "remove", Section: s.key})
+			}
+			prev = &uciFileSection{options: map[string]string{}, lists: map[string][]string{}}
+			changes = append(changes, &openwrt.UCIChange{Config: config, Op: "add", Section: s.key, Value: s.typ})
+		}
+		changes = append(changes, diffUCISection(config, prev, s)...)
+	}
+	for _, s := range before {
+		if !afterByKey[s.key] {
+			changes = append(changes, &openwrt.UCIChange{Config: config, Op: "remove", Section: s.key})
+		}
+	}
+	return
+}
+
+// diffUCISection 比较同一配置节的选项, 按选项名称排序
+func diffUCISection(config string, old, cur *uciFileSection) (changes []*openwrt.UCIChange) {
+	names := map[string]bool{}
+	for name := range old.options {
+		names[name] = true
+	}
+	for name := range cur.options {
+		names[name] = true
+	}
+	for name := range old.lists {
+		names[name] = true
+	}
+	for name := range cur.lists {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		change := func(op, value string) {
+			changes = append(changes, &openwrt.UCIChange{Config: config, Op: op, Section: cur.key, Option: name, Value: value})
+		}
+		a, aOK := old.options[name]
+		b, bOK := cur.options[name]
+		switch {
+		case bOK && (!aOK || a != b):
+			change("set", b)
+		case aOK && !bOK:
+			// 选项被删除或改为列表
+			change("remove", "")
+		}
+
+		aItems, bItems := old.lists[name], cur.lists[name]
+		switch {
+		case bItems == nil:
+			if aItems != nil && !bOK {
+				change("remove", "")
+			}
+			continue
+		case aOK:
+			aItems = nil
+		}
+		count := map[string]int{}
+		for _, item := range aItems {
+			count[item]++
+		}
+		for _, item := range bItems {
+			count[item]--
+		}
+		for _, item := range aItems {
+			if count[item] > 0 {
+				count[item]--
+				change("list-del", item)
+			}
+		}
+		for _, item := range bItems {
+			if count[item] < 0 {
+				count[item]++
+				change("list-add", item)
+			}
+		}
+	}
+	return
+}
+
+// parseUCI 解析uci配置文件文本
+func parseUCI(data []byte) (sections []*uciFileSection, err error) {
+	var cur *uciFileSection
+	anonymous := map[string]int{}
+	for n, line := range strings.Split(string(data), "\n") {
+		var fields []string
+		if fields, err = splitUCILine(line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "package":
+		case "config":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: section type missing", n+1)
+			}
+			cur = &uciFileSection{typ: fields[1], options: map[string]string{}, lists: map[string][]string{}}
+			if len(fields) > 2 && fields[2] != "" {
+				cur.key = fields[2]
+			} else {
+				cur.key = fmt.Sprintf("@%s[%d]", cur.typ, anonymous[cur.typ])
+			}
+			anonymous[cur.typ]++
+			sections = append(sections, cur)
+		case "option", "list":
+			if cur == nil || len(fields) < 3 {
+				return nil, fmt.Errorf("line %d: invalid %s", n+1, fields[0])
+			}
+			if fields[0] == "option" {
+				cur.options[fields[1]] = fields[2]
+			} else {
+				cur.lists[fields[1]] = append(cur.lists[fields[1]], fields[2])
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown keyword %q", n+1, fields[0])
+		}
+	}
+	return
+}
+
+// splitUCILine 按uci的引号规则切分一行, 相邻的引号及转义片段拼接为同一字段
+func splitUCILine(line string) (fields []string, err error) {
+	var field strings.Builder
+	inField := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '#' && !inField:
+			return
+		case c == ' ' || c == '\t' || c == '\r':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			field.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			inField = true
+		case c == '"':
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				field.WriteByte(line[i])
+			}
+			if i >= len(line) {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			inField = true
+		case c == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+			inField = true
+		default:
+			field.WriteByte(c)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return
+}
@@ -0,0 +1,129 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func newArchive(t *testing.T, files map[string]string) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestDiff(t *testing.T) {
+	old := newArchive(t, map[string]string{
+		"etc/config/network": `
+config interface 'lan'
+	option proto 'static'
+	option ipaddr '192.168.1.1'
+	list dns '223.5.5.5'
+
+config interface 'wan'
+	option proto 'dhcp'
+`,
+		"etc/config/system": "config system\n\toption hostname 'OpenWrt'\n",
+		"etc/passwd":        "root:x:0:0:root:/root:/bin/ash\n",
+		"etc/dropbear/key":  "old",
+	})
+	cur := newArchive(t, map[string]string{
+		"./etc/config/network": `
+# 注释不影响比较
+config interface 'lan'
+	option proto static
+	option ipaddr "192.168.2.1"
+	list dns '223.5.5.5'
+	list dns '119.29.29.29'
+
+config interface 'guest'
+	option proto 'static'
+`,
+		"etc/config/system":   "config system\n\toption hostname 'OpenWrt' # 行尾注释\n",
+		"etc/passwd":          "root:x:0:0:root:/root:/bin/ash\n",
+		"etc/config/firewall": "config rule\n\toption name 'it'\\''s'\n",
+	})
+
+	diffs, err := Diff(old, cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 3 {
+		t.Fatalf("got %d diffs, want 3", len(diffs))
+	}
+	if diffs[0].Path != "etc/config/firewall" || diffs[0].Status != FileAdded || len(diffs[0].Changes) != 2 || diffs[0].Changes[1].Value != "it's" {
+		t.Errorf("got firewall %+v", diffs[0])
+	}
+	if diffs[1].Path != "etc/config/network" || diffs[1].Status != FileModified {
+		t.Errorf("got network %+v", diffs[1])
+	}
+	var got []string
+	for _, c := range diffs[1].Changes {
+		got = append(got, c.String())
+	}
+	want := []string{
+		"network.lan.dns+='119.29.29.29'",
+		"network.lan.ipaddr='192.168.2.1'",
+		"network.guest='interface'",
+		"network.guest.proto='static'",
+		"-network.wan",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got changes %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("change %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+	if diffs[2].Path != "etc/dropbear/key" || diffs[2].Status != FileRemoved || diffs[2].Changes != nil {
+		t.Errorf("got key %+v", diffs[2])
+	}
+}
+
+func TestDiffUCI_ListAndAnonymous(t *testing.T) {
+	old := "config rule\n\toption name 'a'\n\tlist proto 'tcp'\n\tlist proto 'udp'\nconfig rule\n\toption name 'b'\n\toption src 'wan'\n"
+	cur := "config rule\n\toption name 'a'\n\tlist proto 'tcp'\nconfig rule\n\toption name 'b'\n\tlist src 'wan'\n\tlist src 'lan'\n"
+	changes, err := DiffUCI("firewall", []byte(old), []byte(cur))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	want := []string{
+		"firewall.@rule[0].proto-='udp'",
+		"-firewall.@rule[1].src",
+		"firewall.@rule[1].src+='wan'",
+		"firewall.@rule[1].src+='lan'",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got changes %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("change %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	if _, err = DiffUCI("firewall", []byte("config rule\n\toption name 'a\n"), nil); err == nil {
+		t.Error("expected unterminated quote error")
+	}
+}
@@ -0,0 +1,226 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	archiveExt    = ".tar.gz"         // 备份文件扩展名
+	archiveLayout = "20060102-150405" // 备份文件名中的时间格式, 使用UTC
+)
+
+// Router 备份依赖的路由器接口, *openwrt.Router 实现了该接口
+type Router interface {
+	BackupContext(ctx context.Context, w io.Writer) (int64, error)
+}
+
+// Retention 备份保留策略, 两项都设置时同时生效; 每台路由器至少保留最新的一份备份
+type Retention struct {
+	Keep   int           // 最多保留的份数, 为空时不限制
+	MaxAge time.Duration // 最长保留时间, 为空时不限制
+}
+
+// Archive 本地保存的一份备份
+type Archive struct {
+	Router string    // 路由器名称
+	Time   time.Time // 备份时间
+	Path   string    // 文件路径
+	Size   int64     // 文件大小
+
+	seq int // 同一秒内的备份序号, 从1开始, 文件名中大于1的序号以 _N 的形式附加在时间后面
+}
+
+// Store 本地备份仓库, 按 目录/路由器名称/时间.tar.gz 保存, 同一秒内的多份备份为 时间_2.tar.gz 等
+type Store struct {
+	dir       string
+	retention Retention
+	now       func() time.Time
+}
+
+// NewStore 创建本地备份仓库, 目录不存在时自动创建
+func NewStore(dir string, retention Retention) (s *Store, err error) {
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return
+	}
+	s = &Store{dir: dir, retention: retention, now: time.Now}
+	return
+}
+
+// Take 从路由器下载备份并保存, 随后按保留策略清理旧备份
+func (s *Store) Take(ctx context.Context, name string, router Router) (archive *Archive, err error) {
+	return s.save(name, s.now(), func(w io.Writer) error {
+		_, err := router.BackupContext(ctx, w)
+		return err
+	})
+}
+
+// Save 保存一份备份, 随后按保留策略清理旧备份
+func (s *Store) Save(name string, at time.Time, src io.Reader) (archive *Archive, err error) {
+	return s.save(name, at, func(w io.Writer) error {
+		_, err := io.Copy(w, src)
+		return err
+	})
+}
+
+// save 先写入临时文件再以硬链接的方式改名, 避免中断时留下不完整的备份; 同一秒内已有备份时增加序号而不覆盖
+func (s *Store) save(name string, at time.Time, write func(w io.Writer) error) (archive *Archive, err error) {
+	if err = checkRouterName(name); err != nil {
+		return
+	}
+	dir := filepath.Join(s.dir, name)
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(dir, ".backup-*")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if err = write(tmp); err != nil {
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+
+	at = at.UTC().Truncate(time.Second)
+	archive = &Archive{Router: name, Time: at}
+	for archive.seq = 1; ; archive.seq++ {
+		archive.Path = filepath.Join(dir, archiveName(at, archive.seq))
+		if err = os.Link(tmp.Name(), archive.Path); !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return
+	}
+	_ = os.Remove(tmp.Name())
+	info, err := os.Stat(archive.Path)
+	if err != nil {
+		return
+	}
+	archive.Size = info.Size()
+	_, err = s.Prune(name)
+	return
+}
+
+// List 按时间顺序列出路由器的所有备份
+func (s *Store) List(name string) (archives []*Archive, err error) {
+	if err = checkRouterName(name); err != nil {
+		return
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(s.dir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), archiveExt) {
+			continue
+		}
+		at, seq, ok := parseArchiveName(entry.Name())
+		if !ok {
+			continue
+		}
+		archives = append(archives, &Archive{
+			Router: name,
+			Time:   at,
+			Path:   filepath.Join(s.dir, name, entry.Name()),
+			Size:   entry.Size(),
+			seq:    seq,
+		})
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		if !archives[i].Time.Equal(archives[j].Time) {
+			return archives[i].Time.Before(archives[j].Time)
+		}
+		return archives[i].seq < archives[j].seq
+	})
+	return
+}
+
+// archiveName 备份文件名, 同一秒内的第一份备份不带序号
+func archiveName(at time.Time, seq int) string {
+	if seq <= 1 {
+		return at.Format(archiveLayout) + archiveExt
+	}
+	return at.Format(archiveLayout) + "_" + strconv.Itoa(seq) + archiveExt
+}
+
+// parseArchiveName 解析备份文件名中的时间及序号
+func parseArchiveName(filename string) (at time.Time, seq int, ok bool) {
+	if !strings.HasSuffix(filename, archiveExt) {
+		return
+	}
+	stamp, suffix, found := strings.Cut(strings.TrimSuffix(filename, archiveExt), "_")
+	seq = 1
+	if found {
+		var err error
+		if seq, err = strconv.Atoi(suffix); err != nil || seq < 2 {
+			return
+		}
+	}
+	at, err := time.Parse(archiveLayout, stamp)
+	return at, seq, err == nil
+}
+
+// Latest 路由器最新的备份, 没有备份时返回空
+func (s *Store) Latest(name string) (archive *Archive, err error) {
+	archives, err := s.List(name)
+	if err != nil || len(archives) == 0 {
+		return
+	}
+	archive = archives[len(archives)-1]
+	return
+}
+
+// Open 打开备份文件, 调用方负责关闭
+func (s *Store) Open(archive *Archive) (io.ReadCloser, error) {
+	return os.Open(archive.Path)
+}
+
+// Prune 按保留策略删除路由器的旧备份, 返回被删除的备份
+func (s *Store) Prune(name string) (removed []*Archive, err error) {
+	archives, err := s.List(name)
+	if err != nil || len(archives) <= 1 {
+		return
+	}
+	now := s.now()
+	// 最新的一份始终保留
+	for i, archive := range archives[:len(archives)-1] {
+		expired := s.retention.MaxAge > 0 && now.Sub(archive.Time) > s.retention.MaxAge
+		excess := s.retention.Keep > 0 && len(archives)-i > s.retention.Keep
+		if !expired && !excess {
+			continue
+		}
+		if err = os.Remove(archive.Path); err != nil {
+			return
+		}
+		removed = append(removed, archive)
+	}
+	return
+}
+
+// checkRouterName 路由器名称用作目录名, 不能包含路径分隔符
+func checkRouterName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid router name %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeRouter struct {
+	data string
+}
+
+func (f *fakeRouter) BackupContext(ctx context.Context, w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, f.data)
+	return int64(n), err
+}
+
+func TestStore_Retention(t *testing.T) {
+	s, err := NewStore(t.TempDir(), Retention{Keep: 3, MaxAge: 48 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return base.Add(72 * time.Hour) }
+
+	// 最早的一份超过保留时间, 其余超过份数
+	for _, hours := range []int{0, 30, 40, 50, 60} {
+		if _, err = s.Save("home", base.Add(time.Duration(hours)*time.Hour), strings.NewReader("archive")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archives, err := s.List("home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 3 || !archives[0].Time.Equal(base.Add(40*time.Hour)) || archives[2].Size != 7 {
+		t.Fatalf("got archives %+v", archives)
+	}
+
+	// 最新的一份即使过期也保留
+	s.now = func() time.Time { return base.Add(1000 * time.Hour) }
+	if removed, err := s.Prune("home"); err != nil || len(removed) != 2 {
+		t.Errorf("got removed %+v err %v", removed, err)
+	}
+
+	latest, err := s.Latest("home")
+	if err != nil || latest == nil || !latest.Time.Equal(base.Add(60*time.Hour)) {
+		t.Fatalf("got latest %+v err %v", latest, err)
+	}
+	if latest, err = s.Latest("office"); err != nil || latest != nil {
+		t.Errorf("got latest %+v err %v", latest, err)
+	}
+	if _, err = s.Save("../etc", base, strings.NewReader("archive")); err == nil {
+		t.Error("expected invalid router name error")
+	}
+}
+
+func TestStore_Take(t *testing.T) {
+	s, err := NewStore(t.TempDir(), Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := s.Take(context.Background(), "home", &fakeRouter{data: "archive"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := s.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if data, _ := ioutil.ReadAll(f); string(data) != "archive" {
+		t.Errorf("got %q", data)
+	}
+}
+
+func TestStore_SameSecond(t *testing.T) {
+	s, err := NewStore(t.TempDir(), Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	for _, data := range []string{"first", "second", "third"} {
+		if _, err = s.Save("home", at.Add(100*time.Millisecond), strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archives, err := s.List("home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 3 || filepath.Base(archives[1].Path) != "20240501-080000_2.tar.gz" {
+		t.Fatalf("got %d archives", len(archives))
+	}
+	latest, err := s.Latest("home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(latest.Path); string(data) != "third" {
+		t.Errorf("latest archive %s contains %q", latest.Path, data)
+	}
+}
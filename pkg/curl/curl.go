@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"os/exec"
+	"strconv"
+	"strings"
 	"time"
 )
 
-const format = `{"http_code":"%{http_code}","time_connect":"%{time_connect}s","time_start_transfer":"%{time_starttransfer}s","time_total":"%{time_total}s","time_name_lookup":"%{time_namelookup}s"}`
+const format = `{"http_code":"%{http_code}","time_connect":"%{time_connect}s","time_start_transfer":"%{time_starttransfer}s","time_total":"%{time_total}s","time_name_lookup":"%{time_namelookup}s","speed_download":"%{speed_download}"}`
 
 type AccessLatencyInfo struct {
 	HttpCode          string        `json:"http_code"`           // HTTP状态码
@@ -16,6 +18,7 @@ type AccessLatencyInfo struct {
 	TimeConnect       time.Duration `json:"time_connect"`        // 客户端和服务端建立TCP连接的时间
 	TimeStartTransfer time.Duration `json:"time_start_transfer"` // 从客服端发送请求到服务端收到并响应首个字节的时间
 	TimeTotal         time.Duration `json:"time_total"`          // 整个请求到响应总耗时
+	SpeedDownload     float64       `json:"speed_download"`      // 平均下载速度, 字节每秒
 }
 
 func (c *AccessLatencyInfo) unmarshalJSON(data []byte) (err error) {
@@ -50,6 +53,12 @@ func (c *AccessLatencyInfo) unmarshalJSON(data []byte) (err error) {
 					return err
 				}
 			}
+		case "speed_download":
+			if result, ok := val.(string); ok {
+				if c.SpeedDownload, err = strconv.ParseFloat(result, 64); err != nil {
+					return err
+				}
+			}
 		case "http_code":
 			if result, ok := val.(string); ok {
 				c.HttpCode = result
@@ -60,8 +69,8 @@ func (c *AccessLatencyInfo) unmarshalJSON(data []byte) (err error) {
 }
 
 func (c *AccessLatencyInfo) Display() {
-	fmt.Printf("请求状态: %s\t域名解析: %dms\t建立连接: %dms\t首个字节: %dms\t整体耗时: %dms\n",
-		c.HttpCode, c.TimeNameLookup.Milliseconds(), c.TimeConnect.Milliseconds(), c.TimeStartTransfer.Milliseconds(), c.TimeTotal.Milliseconds())
+	fmt.Printf("请求状态: %s\t域名解析: %dms\t建立连接: %dms\t首个字节: %dms\t整体耗时: %dms\t下载速度: %.1fKB/s\n",
+		c.HttpCode, c.TimeNameLookup.Milliseconds(), c.TimeConnect.Milliseconds(), c.TimeStartTransfer.Milliseconds(), c.TimeTotal.Milliseconds(), c.SpeedDownload/1024)
 }
 
 func Tracing(ctx context.Context, url string) (ali *AccessLatencyInfo, err error) {
@@ -87,3 +96,21 @@ func Tracing(ctx context.Context, url string) (ali *AccessLatencyInfo, err error
 
 	return
 }
+
+// Succeeded 请求是否成功, 跟随重定向后的状态码为2xx或3xx
+func (c *AccessLatencyInfo) Succeeded() bool {
+	code, err := strconv.Atoi(c.HttpCode)
+	return err == nil && code >= 200 && code < 400
+}
+
+// Fetch 请求url并返回去掉首尾空白的响应内容, 状态码不是2xx时返回错误
+func Fetch(ctx context.Context, url string) (body string, err error) {
+	// -f 状态码错误时以非零退出, -sS 不输出进度但保留错误信息
+	cmd := exec.CommandContext(ctx, "curl", "-fsS", "-L", url)
+	out, err := cmd.Output()
+	if err != nil {
+		return
+	}
+	body = strings.TrimSpace(string(out))
+	return
+}
@@ -11,6 +11,15 @@ import (
 
 var orm *gorm.DB
 
+// models 需要自动迁移的数据模型
+var models = []interface{}{
+	&Router{},
+	&ProxyNode{},
+	&LatencySample{},
+	&SystemSample{},
+	&ThroughputSample{},
+}
+
 func init() {
 	var err error
 	var db *sql.DB
@@ -32,6 +41,12 @@ func init() {
 		return
 	}
 
+	// sqlite不支持并发写入, 所有操作共用一个连接
+	db.SetMaxOpenConns(1)
+	if err = orm.AutoMigrate(models...); err != nil {
+		return
+	}
+
 	return
 
 }
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"errors"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"gorm.io/gorm"
+	"math"
+	"time"
+)
+
+// Router 路由器
+type Router struct {
+	ID         uint       `gorm:"primaryKey"`
+	Addr       string     `gorm:"uniqueIndex"` // 路由器地址
+	Name       string     // 路由器名称
+	LastSyncAt *time.Time // 最近一次同步节点列表的时间
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// ProxyNode 代理节点, 以vssr节点编号和主机地址端口唯一确定
+type ProxyNode struct {
+	ID        uint       `gorm:"primaryKey"`
+	RouterID  uint       `gorm:"uniqueIndex:idx_proxy_node_key"`
+	NodeId    string     `gorm:"uniqueIndex:idx_proxy_node_key"` // vssr节点编号
+	Host      string     `gorm:"uniqueIndex:idx_proxy_node_key"` // 代理主机地址
+	Port      string     `gorm:"uniqueIndex:idx_proxy_node_key"` // 代理服务端口
+	Name      string     // 代理名称
+	FirstSeen time.Time  // 首次出现的时间
+	LastSeen  time.Time  // 最近一次出现的时间
+	RemovedAt *time.Time `gorm:"index"` // 从订阅中消失的时间, 重新出现时清空
+}
+
+// LatencySample 节点延迟采样
+type LatencySample struct {
+	ID          uint      `gorm:"primaryKey"`
+	ProxyNodeID uint      `gorm:"index:idx_latency_sample_node_time"`
+	Time        time.Time `gorm:"index:idx_latency_sample_node_time"` // 采样时间
+	Latency     int       // 延迟时间
+	Offline     bool      // 下线状态
+}
+
+// SaveRouter 按地址保存路由器, 已存在时更新名称
+func SaveRouter(addr, name string) (router *Router, err error) {
+	router = &Router{}
+	err = orm.Where(Router{Addr: addr}).Assign(Router{Name: name}).FirstOrCreate(router).Error
+	return
+}
+
+// SyncProxyNodes 同步路由器的节点列表, 返回本次同步中从订阅消失的节点
+func SyncProxyNodes(routerID uint, pns []*openwrt.ProxyNodeInfo, at time.Time) (disappeared []*ProxyNode, err error) {
+	err = orm.Transaction(func(tx *gorm.DB) (err error) {
+		seen := make(map[uint]bool, len(pns))
+		for _, pn := range pns {
+			var node *ProxyNode
+			if node, err = saveProxyNode(tx, routerID, pn, at); err != nil {
+				return
+			}
+			seen[node.ID] = true
+		}
+
+		// 标记消失的节点
+		var nodes []*ProxyNode
+		if err = tx.Where("router_id = ? AND removed_at IS NULL", routerID).Find(&nodes).Error; err != nil {
+			return
+		}
+		for _, node := range nodes {
+			if seen[node.ID] {
+				continue
+			}
+			node.RemovedAt = &at
+			if err = tx.Model(node).Update("removed_at", at).Error; err != nil {
+				return
+			}
+			disappeared = append(disappeared, node)
+		}
+		return tx.Model(&Router{ID: routerID}).Update("last_sync_at", at).Error
+	})
+	return
+}
+
+// DisappearedProxyNodes 列出最近一次同步时从订阅中消失的节点
+func DisappearedProxyNodes(routerID uint) (nodes []*ProxyNode, err error) {
+	router := &Router{}
+	if err = orm.First(router, routerID).Error; err != nil {
+		return
+	}
+	if router.LastSyncAt == nil {
+		return
+	}
+	err = orm.Where("router_id = ? AND removed_at = ?", routerID, *router.LastSyncAt).Find(&nodes).Error
+	return
+}
+
+// saveProxyNode 保存节点并刷新最近出现时间
+func saveProxyNode(tx *gorm.DB, routerID uint, pn *openwrt.ProxyNodeInfo, at time.Time) (node *ProxyNode, err error) {
+	node = &ProxyNode{}
+	err = tx.Where(proxyNodeKey(routerID, pn)).Attrs(ProxyNode{FirstSeen: at}).FirstOrCreate(node).Error
+	if err != nil {
+		return
+	}
+	node.Name = pn.Name
+	node.LastSeen = at
+	node.RemovedAt = nil
+	err = tx.Model(node).Updates(map[string]interface{}{
+		"name":       node.Name,
+		"last_seen":  node.LastSeen,
+		"removed_at": nil,
+	}).Error
+	return
+}
+
+// proxyNodeKey 节点唯一键查询条件, 使用map以便匹配空值
+func proxyNodeKey(routerID uint, pn *openwrt.ProxyNodeInfo) map[string]interface{} {
+	return map[string]interface{}{
+		"router_id": routerID,
+		"node_id":   pn.Id,
+		"host":      pn.Host,
+		"port":      pn.Port,
+	}
+}
+
+// LatencyRecorder 将测速结果写入数据库, 可设置为 openwrt.RouterConfig 的 LatencyRecorder
+type LatencyRecorder struct {
+	RouterID uint
+}
+
+// RecordLatency 记录一次节点测速结果, 节点不存在时自动创建
+func (l *LatencyRecorder) RecordLatency(pn *openwrt.ProxyNodeInfo, at time.Time) (err error) {
+	return orm.Transaction(func(tx *gorm.DB) (err error) {
+		node := &ProxyNode{}
+		if err = tx.Where(proxyNodeKey(l.RouterID, pn)).Attrs(ProxyNode{Name: pn.Name, FirstSeen: at, LastSeen: at}).FirstOrCreate(node).Error; err != nil {
+			return
+		}
+		return tx.Create(&LatencySample{
+			ProxyNodeID: node.ID,
+			Time:        at,
+			Latency:     pn.Latency,
+			Offline:     pn.Offline,
+		}).Error
+	})
+}
+
+// NodeAvailability 统计节点在时间窗口内的可用率百分比, 没有采样时返回错误
+func NodeAvailability(nodeID uint, since, until time.Time) (percent float64, err error) {
+	var total, online int64
+	window := "proxy_node_id = ? AND time >= ? AND time < ?"
+	if err = orm.Model(&LatencySample{}).Where(window, nodeID, since, until).Count(&total).Error; err != nil {
+		return
+	}
+	if total == 0 {
+		err = errors.New("no latency sample in time window")
+		return
+	}
+	err = orm.Model(&LatencySample{}).Where(window, nodeID, since, until).Where("offline = ?", false).Count(&online).Error
+	if err != nil {
+		return
+	}
+	percent = float64(online) * 100 / float64(total)
+	return
+}
+
+// NodeLatencyPercentiles 统计节点在时间窗口内在线采样的p50及p95延迟, 没有在线采样时返回错误
+func NodeLatencyPercentiles(nodeID uint, since, until time.Time) (p50, p95 int, err error) {
+	var latencies []int
+	err = orm.Model(&LatencySample{}).
+		Where("proxy_node_id = ? AND time >= ? AND time < ? AND offline = ?", nodeID, since, until, false).
+		Order("latency").
+		Pluck("latency", &latencies).Error
+	if err != nil {
+		return
+	}
+	if len(latencies) == 0 {
+		err = errors.New("no online latency sample in time window")
+		return
+	}
+	p50 = percentile(latencies, 50)
+	p95 = percentile(latencies, 95)
+	return
+}
+
+// percentile 按最近秩法计算有序数据的百分位数
+func percentile(sorted []int, p float64) int {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
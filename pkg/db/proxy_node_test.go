@@ -0,0 +1,68 @@
+package db
+
+import (
+	"fmt"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"testing"
+	"time"
+)
+
+func TestProxyNodeHistory(t *testing.T) {
+	router, err := SaveRouter(fmt.Sprintf("test-%d", time.Now().UnixNano()), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 首次同步两个节点, 第二次同步时一个节点消失
+	base := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	hk := &openwrt.ProxyNodeInfo{Name: "香港01", Id: "cfg01", Host: "hk.example.com", Port: "443"}
+	jp := &openwrt.ProxyNodeInfo{Name: "日本01", Id: "cfg02", Host: "jp.example.com", Port: "443"}
+	if _, err = SyncProxyNodes(router.ID, []*openwrt.ProxyNodeInfo{hk, jp}, base); err != nil {
+		t.Fatal(err)
+	}
+	disappeared, err := SyncProxyNodes(router.ID, []*openwrt.ProxyNodeInfo{hk}, base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(disappeared) != 1 || disappeared[0].NodeId != "cfg02" {
+		t.Fatalf("got disappeared %+v", disappeared)
+	}
+	nodes, err := DisappearedProxyNodes(router.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].Host != "jp.example.com" {
+		t.Fatalf("got disappeared %+v", nodes)
+	}
+
+	// 记录20次采样, 延迟为10..190, 每5次有1次离线
+	recorder := &LatencyRecorder{RouterID: router.ID}
+	for i := 0; i < 20; i++ {
+		hk.Latency = (i + 1) * 10
+		hk.Offline = i%5 == 4
+		if err = recorder.RecordLatency(hk, base.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node := &ProxyNode{}
+	if err = GetInstance().Where(proxyNodeKey(router.ID, hk)).First(node).Error; err != nil {
+		t.Fatal(err)
+	}
+	percent, err := NodeAvailability(node.ID, base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if percent != 80 {
+		t.Errorf("got availability %v, want 80", percent)
+	}
+	p50, p95, err := NodeLatencyPercentiles(node.ID, base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p50 != 90 || p95 != 190 {
+		t.Errorf("got p50=%d p95=%d, want 90 and 190", p50, p95)
+	}
+	if _, _, err = NodeLatencyPercentiles(node.ID, base.Add(2*time.Hour), base.Add(3*time.Hour)); err == nil {
+		t.Error("expected error for empty time window")
+	}
+}
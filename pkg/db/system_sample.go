@@ -0,0 +1,52 @@
+package db
+
+import (
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"time"
+)
+
+// SystemSample 路由器系统状态采样, 容量单位为字节
+type SystemSample struct {
+	ID           uint      `gorm:"primaryKey"`
+	RouterID     uint      `gorm:"index:idx_system_sample_router_time"`
+	Time         time.Time `gorm:"index:idx_system_sample_router_time"` // 采样时间
+	Uptime       int64     // 运行秒数
+	Load1        float64   // 1分钟平均负载
+	Load5        float64   // 5分钟平均负载
+	Load15       float64   // 15分钟平均负载
+	MemTotal     uint64    // 内存总量
+	MemAvailable uint64    // 可用内存
+	SwapTotal    uint64    // 交换空间总量
+	SwapFree     uint64    // 空闲交换空间
+	OverlayTotal uint64    // 可写分区总量
+	OverlayUsed  uint64    // 可写分区已用
+}
+
+// SystemRecorder 将路由器系统状态写入数据库
+type SystemRecorder struct {
+	RouterID uint
+}
+
+// RecordSystemInfo 记录一次系统状态采样
+func (s *SystemRecorder) RecordSystemInfo(info *openwrt.SystemInfo, at time.Time) (err error) {
+	return orm.Create(&SystemSample{
+		RouterID:     s.RouterID,
+		Time:         at,
+		Uptime:       int64(info.Uptime / time.Second),
+		Load1:        info.Load[0],
+		Load5:        info.Load[1],
+		Load15:       info.Load[2],
+		MemTotal:     info.MemTotal,
+		MemAvailable: info.MemAvailable,
+		SwapTotal:    info.SwapTotal,
+		SwapFree:     info.SwapFree,
+		OverlayTotal: info.OverlayTotal,
+		OverlayUsed:  info.OverlayUsed,
+	}).Error
+}
+
+// SystemSamples 按时间顺序列出路由器在时间窗口内的系统状态采样
+func SystemSamples(routerID uint, since, until time.Time) (samples []*SystemSample, err error) {
+	err = orm.Where("router_id = ? AND time >= ? AND time < ?", routerID, since, until).Order("time").Find(&samples).Error
+	return
+}
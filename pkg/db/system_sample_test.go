@@ -0,0 +1,32 @@
+package db
+
+import (
+	"fmt"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"testing"
+	"time"
+)
+
+func TestSystemSamples(t *testing.T) {
+	router, err := SaveRouter(fmt.Sprintf("test-%d", time.Now().UnixNano()), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	recorder := &SystemRecorder{RouterID: router.ID}
+	for i := 0; i < 3; i++ {
+		info := &openwrt.SystemInfo{Uptime: time.Duration(i) * time.Minute, Load: [3]float64{float64(i), 0, 0}, MemTotal: 1000, MemAvailable: 500}
+		if err = recorder.RecordSystemInfo(info, base.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	samples, err := SystemSamples(router.ID, base.Add(time.Minute), base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 || samples[0].Uptime != 60 || samples[1].Load1 != 2 || samples[1].MemAvailable != 500 {
+		t.Errorf("got samples %+v", samples)
+	}
+}
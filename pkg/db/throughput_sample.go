@@ -0,0 +1,57 @@
+package db
+
+import (
+	"github.com/huge-kumo/net-utils/pkg/monitor"
+	"time"
+)
+
+// ThroughputSample 接口吞吐量采样
+type ThroughputSample struct {
+	ID        uint      `gorm:"primaryKey"`
+	RouterID  uint      `gorm:"index:idx_throughput_sample_router_iface_time"`
+	Interface string    `gorm:"index:idx_throughput_sample_router_iface_time"` // 接口名称
+	Time      time.Time `gorm:"index:idx_throughput_sample_router_iface_time"` // 采样时间
+	Interval  int64     // 计算速率使用的毫秒数
+	RxBytes   uint64    // 周期内接收字节数
+	TxBytes   uint64    // 周期内发送字节数
+	RxRate    float64   // 接收速率, 字节每秒
+	TxRate    float64   // 发送速率, 字节每秒
+	Reset     bool      // 周期内接口重启或计数器清零
+}
+
+// ThroughputRecorder 将接口吞吐量写入数据库
+type ThroughputRecorder struct {
+	RouterID uint
+}
+
+// RecordThroughput 记录一次采样中所有接口的吞吐量
+func (s *ThroughputRecorder) RecordThroughput(items []*monitor.Throughput) (err error) {
+	samples := make([]*ThroughputSample, 0, len(items))
+	for _, item := range items {
+		samples = append(samples, &ThroughputSample{
+			RouterID:  s.RouterID,
+			Interface: item.Interface,
+			Time:      item.Time,
+			Interval:  item.Interval.Milliseconds(),
+			RxBytes:   item.RxBytes,
+			TxBytes:   item.TxBytes,
+			RxRate:    item.RxRate,
+			TxRate:    item.TxRate,
+			Reset:     item.Reset,
+		})
+	}
+	if len(samples) == 0 {
+		return
+	}
+	return orm.Create(&samples).Error
+}
+
+// ThroughputSamples 按时间顺序列出接口在时间窗口内的吞吐量采样, iface为空时列出所有接口
+func ThroughputSamples(routerID uint, iface string, since, until time.Time) (samples []*ThroughputSample, err error) {
+	query := orm.Where("router_id = ? AND time >= ? AND time < ?", routerID, since, until)
+	if iface != "" {
+		query = query.Where("interface = ?", iface)
+	}
+	err = query.Order("time").Find(&samples).Error
+	return
+}
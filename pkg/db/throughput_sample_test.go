@@ -0,0 +1,38 @@
+package db
+
+import (
+	"fmt"
+	"github.com/huge-kumo/net-utils/pkg/monitor"
+	"testing"
+	"time"
+)
+
+func TestThroughputSamples(t *testing.T) {
+	router, err := SaveRouter(fmt.Sprintf("test-%d", time.Now().UnixNano()), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	recorder := &ThroughputRecorder{RouterID: router.ID}
+	for i := 0; i < 3; i++ {
+		at := base.Add(time.Duration(i) * time.Minute)
+		if err = recorder.RecordThroughput([]*monitor.Throughput{
+			{Interface: "wan", Time: at, Interval: time.Minute, RxBytes: uint64(i) * 60, RxRate: float64(i)},
+			{Interface: "lan", Time: at, Interval: time.Minute, TxBytes: uint64(i) * 60, TxRate: float64(i)},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	samples, err := ThroughputSamples(router.ID, "wan", base.Add(time.Minute), base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 || samples[0].RxBytes != 60 || samples[1].RxRate != 2 || samples[1].Interval != 60000 {
+		t.Errorf("got samples %+v", samples)
+	}
+	if samples, err = ThroughputSamples(router.ID, "", base, base.Add(time.Hour)); err != nil || len(samples) != 6 {
+		t.Errorf("got %d samples, err %v", len(samples), err)
+	}
+}
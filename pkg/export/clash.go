@@ -0,0 +1,176 @@
+package export
+
+import (
+	"github.com/huge-kumo/net-utils/pkg/subscription"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+// clashProxy Clash/Mihomo的代理配置, 字段按常见配置的顺序输出
+type clashProxy struct {
+	Name           string            `yaml:"name"`
+	Type           string            `yaml:"type"`
+	Server         string            `yaml:"server"`
+	Port           int               `yaml:"port"`
+	Cipher         string            `yaml:"cipher,omitempty"`
+	Password       string            `yaml:"password,omitempty"`
+	UUID           string            `yaml:"uuid,omitempty"`
+	AlterId        *int              `yaml:"alterId,omitempty"`
+	Flow           string            `yaml:"flow,omitempty"`
+	Protocol       string            `yaml:"protocol,omitempty"`
+	ProtocolParam  string            `yaml:"protocol-param,omitempty"`
+	Obfs           string            `yaml:"obfs,omitempty"`
+	ObfsParam      string            `yaml:"obfs-param,omitempty"`
+	Plugin         string            `yaml:"plugin,omitempty"`
+	PluginOpts     map[string]string `yaml:"plugin-opts,omitempty"`
+	UDP            bool              `yaml:"udp,omitempty"`
+	TLS            bool              `yaml:"tls,omitempty"`
+	ServerName     string            `yaml:"servername,omitempty"`
+	SNI            string            `yaml:"sni,omitempty"`
+	SkipCertVerify bool              `yaml:"skip-cert-verify,omitempty"`
+	Network        string            `yaml:"network,omitempty"`
+	WSOpts         *clashWSOpts      `yaml:"ws-opts,omitempty"`
+	H2Opts         *clashH2Opts      `yaml:"h2-opts,omitempty"`
+	GRPCOpts       *clashGRPCOpts    `yaml:"grpc-opts,omitempty"`
+}
+
+type clashWSOpts struct {
+	Path    string            `yaml:"path,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+type clashH2Opts struct {
+	Host []string `yaml:"host,omitempty"`
+	Path string   `yaml:"path,omitempty"`
+}
+
+type clashGRPCOpts struct {
+	ServiceName string `yaml:"grpc-service-name,omitempty"`
+}
+
+type clashGroup struct {
+	Name     string   `yaml:"name"`
+	Type     string   `yaml:"type"`
+	Proxies  []string `yaml:"proxies"`
+	URL      string   `yaml:"url,omitempty"`
+	Interval int      `yaml:"interval,omitempty"`
+}
+
+type clashConfig struct {
+	Proxies     []*clashProxy `yaml:"proxies"`
+	ProxyGroups []*clashGroup `yaml:"proxy-groups"`
+	Rules       []string      `yaml:"rules,omitempty"`
+}
+
+// Clash 导出Clash/Mihomo配置中的 proxies, proxy-groups 及 rules, 模板为空时使用默认模板;
+// 返回因Clash不支持而未导出的节点
+func Clash(nodes []*subscription.Node, t *Template) (data []byte, skipped []*Skipped, err error) {
+	if t, err = resolve(t); err != nil {
+		return
+	}
+	exported, skipped := prepare(t, nodes, clashUnsupported, nil)
+	conf := &clashConfig{Proxies: []*clashProxy{}, Rules: t.Rules}
+	for _, n := range exported {
+		conf.Proxies = append(conf.Proxies, newClashProxy(n))
+	}
+	for _, g := range t.layout(exported) {
+		cg := &clashGroup{Name: g.Name, Type: g.Type, Proxies: g.members}
+		if g.Type != GroupSelect {
+			cg.URL, cg.Interval = t.TestURL, t.TestInterval
+		}
+		conf.ProxyGroups = append(conf.ProxyGroups, cg)
+	}
+	data, err = yaml.Marshal(conf)
+	return
+}
+
+// clashUnsupported Clash不支持的节点返回原因
+func clashUnsupported(n *subscription.Node) string {
+	switch n.Protocol {
+	case subscription.ProtocolSS:
+		switch n.Plugin {
+		case "", "obfs-local", "simple-obfs", "v2ray-plugin":
+		default:
+			return "unsupported ss plugin " + n.Plugin
+		}
+	case subscription.ProtocolSSR, subscription.ProtocolVMess, subscription.ProtocolTrojan:
+	case subscription.ProtocolVLESS:
+		if n.Security == "reality" {
+			return "reality parameters are not kept in share link nodes"
+		}
+	default:
+		return "unsupported protocol " + n.Protocol
+	}
+	switch n.Network {
+	case "", "tcp", "ws", "h2", "grpc":
+	default:
+		return "unsupported network " + n.Network
+	}
+	return ""
+}
+
+// newClashProxy 转换为Clash代理配置
+func newClashProxy(n *exportNode) (p *clashProxy) {
+	p = &clashProxy{Name: n.name, Type: n.Protocol, Server: n.Host, Port: n.Port, UDP: true}
+	switch n.Protocol {
+	case subscription.ProtocolSS:
+		p.Cipher, p.Password = n.Cipher, n.Password
+		switch n.Plugin {
+		case "obfs-local", "simple-obfs":
+			p.Plugin = "obfs"
+			p.PluginOpts = map[string]string{"mode": n.Obfs}
+			if n.ObfsParam != "" {
+				p.PluginOpts["host"] = n.ObfsParam
+			}
+		case "v2ray-plugin":
+			p.Plugin = "v2ray-plugin"
+			p.PluginOpts = map[string]string{}
+			for key, val := range pluginOpts(n.PluginOpts) {
+				switch key {
+				case "mode", "host", "path":
+					p.PluginOpts[key] = val
+				case "tls":
+					p.PluginOpts[key] = "true"
+				}
+			}
+		}
+	case subscription.ProtocolSSR:
+		p.Cipher, p.Password = n.Cipher, n.Password
+		p.Protocol, p.ProtocolParam = n.SSRProtocol, n.SSRProtocolParam
+		p.Obfs, p.ObfsParam = n.Obfs, n.ObfsParam
+	case subscription.ProtocolVMess:
+		alterId := n.AlterId
+		p.UUID, p.AlterId, p.Cipher = n.UUID, &alterId, n.Cipher
+		p.TLS, p.ServerName = n.Security == "tls", n.SNI
+	case subscription.ProtocolVLESS:
+		p.UUID, p.Flow = n.UUID, n.Flow
+		p.TLS, p.ServerName = n.Security == "tls", n.SNI
+	case subscription.ProtocolTrojan:
+		p.Password, p.SNI = n.Password, n.SNI
+	}
+	if n.Security != "" {
+		p.SkipCertVerify = n.AllowInsecure
+	}
+	if !p.TLS {
+		p.ServerName = ""
+	}
+
+	switch n.Network {
+	case "ws":
+		p.Network = n.Network
+		p.WSOpts = &clashWSOpts{Path: n.Path}
+		if n.HostHeader != "" {
+			p.WSOpts.Headers = map[string]string{"Host": n.HostHeader}
+		}
+	case "h2":
+		p.Network = n.Network
+		p.H2Opts = &clashH2Opts{Path: n.Path}
+		if n.HostHeader != "" {
+			p.H2Opts.Host = strings.Split(n.HostHeader, ",")
+		}
+	case "grpc":
+		p.Network = n.Network
+		p.GRPCOpts = &clashGRPCOpts{ServiceName: n.Path}
+	}
+	return
+}
@@ -0,0 +1,373 @@
+package export
+
+import (
+	"fmt"
+	"github.com/huge-kumo/net-utils/pkg/nodequery"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"github.com/huge-kumo/net-utils/pkg/subscription"
+	"gopkg.in/yaml.v3"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultTestURL      = "http://www.gstatic.com/generate_204" // 默认url-test/fallback组的测试地址
+	DefaultTestInterval = 300                                   // 默认url-test/fallback组的测试间隔, 秒
+)
+
+// 代理组类型, 使用Clash的名称, 导出到其他格式时转换
+const (
+	GroupSelect   = "select"
+	GroupURLTest  = "url-test"
+	GroupFallback = "fallback"
+)
+
+// 内置代理, 可以在代理组及规则中引用
+const (
+	ProxyDirect = "DIRECT"
+	ProxyReject = "REJECT"
+)
+
+// routerFields 只有路由器上的节点才有的查询字段, 导出的节点没有编号, 延迟及在线状态, 不能用于模板的筛选表达式
+var routerFields = map[string]bool{"id": true, "latency": true, "offline": true, "online": true}
+
+// Group 模板中的代理组
+type Group struct {
+	Name    string   `yaml:"name"`              // 组名称
+	Type    string   `yaml:"type"`              // 组类型, 取值为 Group* 常量
+	Groups  []string `yaml:"groups,omitempty"`  // 引用的其他组, 排在节点之前
+	Proxies []string `yaml:"proxies,omitempty"` // 引用的内置代理, 如 DIRECT
+	Filter  string   `yaml:"filter,omitempty"`  // 加入的节点, nodequery表达式, 不能使用 id/latency/online/offline 字段; 为空时加入所有节点, 为 none 时不加入节点
+}
+
+// Template 导出模板, 控制代理组布局及规则
+type Template struct {
+	Groups       []*Group `yaml:"groups"`                  // 代理组, 按顺序输出
+	Rules        []string `yaml:"rules,omitempty"`         // Clash格式的规则, 如 GEOIP,CN,DIRECT 及 MATCH,Proxy; sing-box不输出规则
+	TestURL      string   `yaml:"test-url,omitempty"`      // url-test/fallback组的测试地址
+	TestInterval int      `yaml:"test-interval,omitempty"` // url-test/fallback组的测试间隔, 秒
+
+	filters map[string]*nodequery.Query
+}
+
+// DefaultTemplate 默认模板: 手动选择组包含自动测速组, 故障转移组及所有节点, 国内直连其余走代理
+func DefaultTemplate() *Template {
+	t := &Template{
+		Groups: []*Group{
+			{Name: "Proxy", Type: GroupSelect, Groups: []string{"Auto", "Fallback"}, Proxies: []string{ProxyDirect}},
+			{Name: "Auto", Type: GroupURLTest},
+			{Name: "Fallback", Type: GroupFallback},
+		},
+		Rules: []string{"GEOIP,LAN,DIRECT", "GEOIP,CN,DIRECT", "MATCH,Proxy"},
+	}
+	if err := t.validate(); err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// ParseTemplate 解析YAML格式的导出模板并校验
+func ParseTemplate(data []byte) (t *Template, err error) {
+	t = &Template{}
+	if err = yaml.Unmarshal(data, t); err != nil {
+		return
+	}
+	if err = t.validate(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// validate 填充默认值, 校验组名称, 类型及引用并解析节点筛选表达式
+func (t *Template) validate() (err error) {
+	if t.TestURL == "" {
+		t.TestURL = DefaultTestURL
+	}
+	if t.TestInterval <= 0 {
+		t.TestInterval = DefaultTestInterval
+	}
+	if len(t.Groups) == 0 {
+		return fmt.Errorf("template has no proxy group")
+	}
+	names := map[string]bool{}
+	for _, g := range t.Groups {
+		if g.Name == "" || g.Name == ProxyDirect || g.Name == ProxyReject || names[g.Name] {
+			return fmt.Errorf("invalid or duplicate group name %q", g.Name)
+		}
+		names[g.Name] = true
+		switch g.Type {
+		case GroupSelect, GroupURLTest, GroupFallback:
+		default:
+			return fmt.Errorf("group %s: unsupported type %q", g.Name, g.Type)
+		}
+	}
+	t.filters = map[string]*nodequery.Query{}
+	for _, g := range t.Groups {
+		for _, ref := range g.Groups {
+			if !names[ref] || ref == g.Name {
+				return fmt.Errorf("group %s: invalid group reference %q", g.Name, ref)
+			}
+		}
+		for _, proxy := range g.Proxies {
+			if proxy != ProxyDirect && proxy != ProxyReject {
+				return fmt.Errorf("group %s: unknown builtin proxy %q", g.Name, proxy)
+			}
+		}
+		if g.Filter != "" && g.Filter != "none" {
+			if t.filters[g.Name], err = nodequery.Parse(g.Filter); err != nil {
+				return fmt.Errorf("group %s: %w", g.Name, err)
+			}
+			for _, name := range t.filters[g.Name].Fields() {
+				if routerFields[name] {
+					return fmt.Errorf("group %s: filter field %q is not available for exported nodes", g.Name, name)
+				}
+			}
+		}
+	}
+	return t.checkCycles()
+}
+
+// checkCycles 代理组之间的引用不能成环, 否则Clash等客户端拒绝加载配置
+func (t *Template) checkCycles() error {
+	refs := map[string][]string{}
+	for _, g := range t.Groups {
+		refs[g.Name] = g.Groups
+	}
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			for i, p := range path {
+				if p == name {
+					return fmt.Errorf("group reference cycle %s", strings.Join(append(path[i:], name), " -> "))
+				}
+			}
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, ref := range refs[name] {
+			if err := visit(ref); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+	for _, g := range t.Groups {
+		if err := visit(g.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Skipped 无法转换或目标格式不支持而未导出的节点
+type Skipped struct {
+	Node   *subscription.Node
+	Reason string
+}
+
+// exportNode 待导出的节点及其在配置中的唯一名称
+type exportNode struct {
+	*subscription.Node
+	name string
+}
+
+// group 展开后的代理组, members依次为引用的组, 内置代理及节点名称
+type group struct {
+	*Group
+	members []string
+}
+
+// layout 按模板展开代理组, 没有任何成员的组加入 DIRECT 以保证配置有效
+func (t *Template) layout(nodes []*exportNode) (groups []*group) {
+	for _, g := range t.Groups {
+		members := append(append([]string{}, g.Groups...), g.Proxies...)
+		if g.Filter != "none" {
+			query := t.filters[g.Name]
+			for _, n := range nodes {
+				if query == nil || query.Match(n.ProxyNodeInfo()) {
+					members = append(members, n.name)
+				}
+			}
+		}
+		if len(members) == 0 {
+			members = []string{ProxyDirect}
+		}
+		groups = append(groups, &group{g, members})
+	}
+	return
+}
+
+// resolve 模板为空时使用默认模板, 否则在第一次使用时校验
+func resolve(t *Template) (*Template, error) {
+	if t == nil {
+		return DefaultTemplate(), nil
+	}
+	if t.filters == nil {
+		if err := t.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// prepare 过滤unsupported返回原因的节点并分配唯一名称, 名称不与代理组及内置代理重复;
+// clean 清理目标格式不允许的字符, reserved 为目标格式的其他保留名称, 重名时在后面加序号
+func prepare(t *Template, nodes []*subscription.Node, unsupported func(*subscription.Node) string, clean func(string) string, reserved ...string) (exported []*exportNode, skipped []*Skipped) {
+	used := map[string]bool{ProxyDirect: true, ProxyReject: true}
+	for _, name := range reserved {
+		used[name] = true
+	}
+	for _, g := range t.Groups {
+		used[g.Name] = true
+	}
+	for _, n := range nodes {
+		if reason := unsupported(n); reason != "" {
+			skipped = append(skipped, &Skipped{n, reason})
+			continue
+		}
+		base := n.Remarks
+		if base == "" {
+			base = n.Host + ":" + strconv.Itoa(n.Port)
+		}
+		if clean != nil {
+			base = clean(base)
+		}
+		name := base
+		for i := 2; used[name]; i++ {
+			name = base + " " + strconv.Itoa(i)
+		}
+		used[name] = true
+		exported = append(exported, &exportNode{n, name})
+	}
+	return
+}
+
+// FromVssrNodes 把路由器上的vssr节点转换为订阅节点, v2ray/xray节点按 v2ray_protocol 转换为vmess或vless,
+// 并带上传输方式及TLS参数; 无法转换的节点(如kcp/quic传输)连同原因返回
+func FromVssrNodes(vssrNodes []*openwrt.VssrNode) (nodes []*subscription.Node, skipped []*Skipped) {
+	for _, v := range vssrNodes {
+		n := &subscription.Node{
+			Remarks:  v.Alias,
+			Group:    v.Group,
+			Host:     v.Host,
+			Password: v.Password,
+			Cipher:   v.Cipher,
+		}
+		if reason := fromVssrNode(n, v); reason != "" {
+			skipped = append(skipped, &Skipped{n, reason})
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return
+}
+
+// fromVssrNode 按节点类型填充订阅节点, 无法转换时返回原因
+func fromVssrNode(n *subscription.Node, v *openwrt.VssrNode) (reason string) {
+	var err error
+	if n.Port, err = strconv.Atoi(v.Port); err != nil {
+		return "invalid port " + v.Port
+	}
+	switch v.Type {
+	case openwrt.NodeTypeSSR:
+		n.Protocol = subscription.ProtocolSSR
+		n.SSRProtocol, n.SSRProtocolParam = v.Protocol, v.ProtocolParam
+		n.Obfs, n.ObfsParam = v.Obfs, v.ObfsParam
+	case openwrt.NodeTypeSS:
+		n.Protocol = subscription.ProtocolSS
+		n.Plugin, n.PluginOpts = v.Plugin, v.PluginOpts
+		for _, opt := range strings.Split(v.PluginOpts, ";") {
+			key, val, _ := strings.Cut(opt, "=")
+			switch key {
+			case "obfs":
+				n.Obfs = val
+			case "obfs-host":
+				n.ObfsParam = val
+			}
+		}
+	case openwrt.NodeTypeV2Ray, openwrt.NodeTypeXray:
+		n.UUID, n.Password = v.Password, ""
+		switch v.V2RayProtocol {
+		case "", "vmess":
+			n.Protocol = subscription.ProtocolVMess
+			if n.Cipher == "" {
+				n.Cipher = "auto"
+			}
+			if v.AlterId != "" {
+				if n.AlterId, err = strconv.Atoi(v.AlterId); err != nil {
+					return "invalid alter id " + v.AlterId
+				}
+			}
+		case "vless":
+			n.Protocol = subscription.ProtocolVLESS
+			n.Cipher = ""
+		default:
+			return "unsupported v2ray protocol " + v.V2RayProtocol
+		}
+		switch v.Transport {
+		case "", "tcp":
+			n.Network = "tcp"
+		case "ws":
+			n.Network, n.Path, n.HostHeader = "ws", v.WSPath, v.WSHost
+		case "h2":
+			n.Network, n.Path, n.HostHeader = "h2", v.H2Path, v.H2Host
+		default:
+			return "unsupported transport " + v.Transport
+		}
+		if v.TLS {
+			n.Security, n.SNI = "tls", v.TLSHost
+		}
+	case openwrt.NodeTypeTrojan:
+		n.Protocol = subscription.ProtocolTrojan
+		n.Security, n.SNI = "tls", v.TLSHost
+	default:
+		return "unsupported node type " + v.Type
+	}
+	return
+}
+
+// FromRouter 把 ListAllProxyNodeInfo 返回的节点(如 nodequery 的筛选结果)按编号对应到vssr节点后转换,
+// ProxyNodeInfo 不包含密码等参数, 因此需要 ListVssrNodes 的结果; 无法转换的节点连同原因返回
+func FromRouter(pns []*openwrt.ProxyNodeInfo, vssrNodes []*openwrt.VssrNode) (nodes []*subscription.Node, skipped []*Skipped, err error) {
+	byId := map[string]*openwrt.VssrNode{}
+	for _, v := range vssrNodes {
+		byId[v.Id] = v
+	}
+	selected := make([]*openwrt.VssrNode, 0, len(pns))
+	for _, pn := range pns {
+		v, ok := byId[pn.Id]
+		if !ok {
+			return nil, nil, fmt.Errorf("proxy node %s (%s) not found in vssr nodes", pn.Id, pn.Name)
+		}
+		selected = append(selected, v)
+	}
+	nodes, skipped = FromVssrNodes(selected)
+	return
+}
+
+// pluginOpts 解析 key=value;flag 形式的插件参数, 没有值的选项为 "true"
+func pluginOpts(s string) map[string]string {
+	opts := map[string]string{}
+	for _, opt := range strings.Split(s, ";") {
+		if opt = strings.TrimSpace(opt); opt == "" {
+			continue
+		}
+		key, val, found := strings.Cut(opt, "=")
+		if !found {
+			val = "true"
+		}
+		opts[key] = val
+	}
+	return opts
+}
@@ -0,0 +1,218 @@
+package export
+
+import (
+	"encoding/json"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"github.com/huge-kumo/net-utils/pkg/subscription"
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testNodes() []*subscription.Node {
+	return []*subscription.Node{
+		{Protocol: subscription.ProtocolSS, Remarks: "香港 01", Host: "hk.example.com", Port: 8388, Cipher: "aes-128-gcm", Password: "p,w",
+			Plugin: "obfs-local", PluginOpts: "obfs=http;obfs-host=cdn.example.com", Obfs: "http", ObfsParam: "cdn.example.com"},
+		{Protocol: subscription.ProtocolVMess, Remarks: "日本 01", Host: "jp.example.com", Port: 443, UUID: "uuid-1", Cipher: "auto",
+			Network: "ws", Path: "/ray", HostHeader: "jp.example.com", Security: "tls", SNI: "jp.example.com"},
+		{Protocol: subscription.ProtocolTrojan, Remarks: "香港 01", Host: "hk2.example.com", Port: 443, Password: "secret", Security: "tls", SNI: "hk2.example.com"},
+		{Protocol: subscription.ProtocolSSR, Remarks: "美国 01", Host: "us.example.com", Port: 8080, Cipher: "aes-256-cfb", Password: "pw",
+			SSRProtocol: "auth_aes128_md5", Obfs: "tls1.2_ticket_auth"},
+		{Protocol: subscription.ProtocolVLESS, Remarks: "reality", Host: "r.example.com", Port: 443, UUID: "uuid-2", Security: "reality"},
+	}
+}
+
+func TestClash(t *testing.T) {
+	data, skipped, err := Clash(testNodes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 1 || skipped[0].Node.Remarks != "reality" {
+		t.Fatalf("skipped %v", skipped)
+	}
+	conf := &struct {
+		Proxies []map[string]interface{} `yaml:"proxies"`
+		Groups  []struct {
+			Name     string   `yaml:"name"`
+			Type     string   `yaml:"type"`
+			Proxies  []string `yaml:"proxies"`
+			URL      string   `yaml:"url"`
+			Interval int      `yaml:"interval"`
+		} `yaml:"proxy-groups"`
+		Rules []string `yaml:"rules"`
+	}{}
+	if err = yaml.Unmarshal(data, conf); err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Proxies) != 4 {
+		t.Fatalf("proxies %v", conf.Proxies)
+	}
+	ss := conf.Proxies[0]
+	if ss["type"] != "ss" || ss["password"] != "p,w" || ss["plugin"] != "obfs" ||
+		!reflect.DeepEqual(ss["plugin-opts"], map[string]interface{}{"mode": "http", "host": "cdn.example.com"}) {
+		t.Errorf("ss proxy %v", ss)
+	}
+	vmess := conf.Proxies[1]
+	if vmess["network"] != "ws" || vmess["tls"] != true || vmess["servername"] != "jp.example.com" || vmess["alterId"] != 0 ||
+		!reflect.DeepEqual(vmess["ws-opts"], map[string]interface{}{"path": "/ray", "headers": map[string]interface{}{"Host": "jp.example.com"}}) {
+		t.Errorf("vmess proxy %v", vmess)
+	}
+	if conf.Proxies[2]["name"] != "香港 01 2" || conf.Proxies[2]["sni"] != "hk2.example.com" {
+		t.Errorf("trojan proxy %v", conf.Proxies[2])
+	}
+	if conf.Proxies[3]["protocol"] != "auth_aes128_md5" || conf.Proxies[3]["obfs"] != "tls1.2_ticket_auth" {
+		t.Errorf("ssr proxy %v", conf.Proxies[3])
+	}
+
+	all := []string{"香港 01", "日本 01", "香港 01 2", "美国 01"}
+	if g := conf.Groups[0]; g.Name != "Proxy" || g.Type != GroupSelect || g.URL != "" ||
+		!reflect.DeepEqual(g.Proxies, append([]string{"Auto", "Fallback", ProxyDirect}, all...)) {
+		t.Errorf("select group %+v", g)
+	}
+	if g := conf.Groups[1]; g.Type != GroupURLTest || g.URL != DefaultTestURL || g.Interval != DefaultTestInterval || !reflect.DeepEqual(g.Proxies, all) {
+		t.Errorf("url-test group %+v", g)
+	}
+	if !reflect.DeepEqual(conf.Rules, DefaultTemplate().Rules) {
+		t.Errorf("rules %v", conf.Rules)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	tmpl, err := ParseTemplate([]byte(`
+groups:
+  - name: Proxy
+    type: select
+    groups: [HK, Others]
+    filter: none
+  - name: HK
+    type: fallback
+    filter: region = HK
+  - name: Others
+    type: url-test
+    filter: region != HK
+  - name: Ads
+    type: select
+    proxies: [REJECT, DIRECT]
+    filter: none
+  - name: Empty
+    type: url-test
+    filter: region = SG
+rules:
+  - DOMAIN-SUFFIX,ad.com,Ads
+  - MATCH,Proxy
+test-interval: 600
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _, err := Surge(testNodes(), tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[Proxy]
+香港 01 = ss, hk.example.com, 8388, encrypt-method=aes-128-gcm, password="p,w", obfs=http, obfs-host=cdn.example.com, udp-relay=true
+日本 01 = vmess, jp.example.com, 443, username=uuid-1, vmess-aead=true, tls=true, sni=jp.example.com, ws=true, ws-path=/ray, ws-headers=Host:jp.example.com
+香港 01 2 = trojan, hk2.example.com, 443, password=secret, sni=hk2.example.com
+
+[Proxy Group]
+Proxy = select, HK, Others
+HK = fallback, 香港 01, 香港 01 2, url=http://www.gstatic.com/generate_204, interval=600
+Others = url-test, 日本 01, url=http://www.gstatic.com/generate_204, interval=600
+Ads = select, REJECT, DIRECT
+Empty = url-test, DIRECT, url=http://www.gstatic.com/generate_204, interval=600
+
+[Rule]
+DOMAIN-SUFFIX,ad.com,Ads
+FINAL,Proxy
+`
+	if string(data) != want {
+		t.Errorf("surge config:\n%s\nwant:\n%s", data, want)
+	}
+
+	for _, bad := range []string{
+		"groups: []",
+		"groups: [{name: A, type: load-balance}]",
+		"groups: [{name: A, type: select}, {name: A, type: select}]",
+		"groups: [{name: A, type: select, groups: [B]}]",
+		"groups: [{name: A, type: select, proxies: [PROXY]}]",
+		"groups: [{name: A, type: select, filter: 'region ='}]",
+		"groups: [{name: A, type: url-test, filter: 'region = HK and latency < 200'}]",
+		"groups: [{name: A, type: select, filter: online}]",
+		"groups: [{name: A, type: select, groups: [B]}, {name: B, type: select, groups: [C]}, {name: C, type: select, groups: [A]}]",
+	} {
+		if _, err = ParseTemplate([]byte(bad)); err == nil {
+			t.Errorf("template %q should be invalid", bad)
+		}
+	}
+	if _, err = ParseTemplate([]byte("groups: [{name: A, type: select, groups: [B]}, {name: B, type: select, groups: [A]}]")); err == nil || !strings.Contains(err.Error(), "A -> B -> A") {
+		t.Errorf("got %v, want cycle A -> B -> A", err)
+	}
+}
+
+func TestSingBox(t *testing.T) {
+	data, skipped, err := SingBox(testNodes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 2 {
+		t.Fatalf("skipped %v", skipped)
+	}
+	conf := &struct {
+		Outbounds []map[string]interface{} `json:"outbounds"`
+	}{}
+	if err = json.Unmarshal(data, conf); err != nil {
+		t.Fatal(err)
+	}
+	var tags []string
+	for _, out := range conf.Outbounds {
+		tags = append(tags, out["type"].(string)+":"+out["tag"].(string))
+	}
+	want := []string{"selector:Proxy", "urltest:Auto", "urltest:Fallback", "shadowsocks:香港 01", "vmess:日本 01", "trojan:香港 01 2", "direct:direct", "block:block"}
+	if !reflect.DeepEqual(tags, want) {
+		t.Fatalf("outbounds %v", tags)
+	}
+	if got := conf.Outbounds[0]["outbounds"]; !reflect.DeepEqual(got, []interface{}{"Auto", "Fallback", "direct", "香港 01", "日本 01", "香港 01 2"}) {
+		t.Errorf("selector outbounds %v", got)
+	}
+	if conf.Outbounds[1]["interval"] != "300s" {
+		t.Errorf("urltest %v", conf.Outbounds[1])
+	}
+	vmess := conf.Outbounds[4]
+	if !reflect.DeepEqual(vmess["tls"], map[string]interface{}{"enabled": true, "server_name": "jp.example.com"}) ||
+		!reflect.DeepEqual(vmess["transport"], map[string]interface{}{"type": "ws", "path": "/ray", "headers": map[string]interface{}{"Host": "jp.example.com"}}) {
+		t.Errorf("vmess outbound %v", vmess)
+	}
+	if ss := conf.Outbounds[3]; ss["plugin"] != "obfs-local" || ss["plugin_opts"] != "obfs=http;obfs-host=cdn.example.com" {
+		t.Errorf("ss outbound %v", ss)
+	}
+}
+
+func TestFromRouter(t *testing.T) {
+	vssrNodes := []*openwrt.VssrNode{
+		{Id: "cfg1", Type: openwrt.NodeTypeV2Ray, Alias: "日本 01", Host: "jp.example.com", Port: "443", Password: "uuid-1",
+			AlterId: "0", Transport: "ws", WSPath: "/ray", WSHost: "cdn.example.com", TLS: true, TLSHost: "jp.example.com"},
+		{Id: "cfg2", Type: openwrt.NodeTypeSS, Alias: "香港 01", Host: "hk.example.com", Port: "8388", Password: "pw", Cipher: "aes-128-gcm",
+			Plugin: "obfs-local", PluginOpts: "obfs=tls;obfs-host=cdn.example.com"},
+		{Id: "cfg4", Type: openwrt.NodeTypeXray, Alias: "美国 01", Host: "us.example.com", Port: "443", Password: "uuid-4", V2RayProtocol: "vless", Transport: "h2", H2Path: "/h2", TLS: true},
+		{Id: "cfg5", Type: openwrt.NodeTypeV2Ray, Alias: "kcp", Host: "kcp.example.com", Port: "443", Password: "uuid-5", Transport: "kcp"},
+	}
+	nodes, skipped, err := FromRouter([]*openwrt.ProxyNodeInfo{{Id: "cfg2"}, {Id: "cfg1"}, {Id: "cfg4"}, {Id: "cfg5"}}, vssrNodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 1 || skipped[0].Node.Remarks != "kcp" || skipped[0].Reason != "unsupported transport kcp" {
+		t.Fatalf("skipped %+v", skipped)
+	}
+	if len(nodes) != 3 || nodes[0].Protocol != subscription.ProtocolSS || nodes[0].Obfs != "tls" || nodes[0].ObfsParam != "cdn.example.com" ||
+		nodes[1].Protocol != subscription.ProtocolVMess || nodes[1].UUID != "uuid-1" || nodes[1].Cipher != "auto" || nodes[1].Port != 443 ||
+		nodes[1].Network != "ws" || nodes[1].Path != "/ray" || nodes[1].HostHeader != "cdn.example.com" || nodes[1].Security != "tls" || nodes[1].SNI != "jp.example.com" {
+		t.Fatalf("nodes %+v %+v", nodes[0], nodes[1])
+	}
+	if vless := nodes[2]; vless.Protocol != subscription.ProtocolVLESS || vless.UUID != "uuid-4" || vless.Cipher != "" || vless.Network != "h2" || vless.Path != "/h2" || vless.Security != "tls" {
+		t.Fatalf("vless node %+v", vless)
+	}
+	if _, _, err = FromRouter([]*openwrt.ProxyNodeInfo{{Id: "cfg3"}}, vssrNodes); err == nil || !strings.Contains(err.Error(), "cfg3") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
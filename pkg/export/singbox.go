@@ -0,0 +1,159 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/huge-kumo/net-utils/pkg/subscription"
+	"strings"
+)
+
+// sing-box内置出站的tag
+const (
+	singBoxDirect = "direct"
+	singBoxBlock  = "block"
+)
+
+// singBoxOutbound sing-box的出站配置, 节点及代理组共用
+type singBoxOutbound struct {
+	Type       string            `json:"type"`
+	Tag        string            `json:"tag"`
+	Server     string            `json:"server,omitempty"`
+	ServerPort int               `json:"server_port,omitempty"`
+	Method     string            `json:"method,omitempty"`
+	Password   string            `json:"password,omitempty"`
+	Plugin     string            `json:"plugin,omitempty"`
+	PluginOpts string            `json:"plugin_opts,omitempty"`
+	UUID       string            `json:"uuid,omitempty"`
+	Security   string            `json:"security,omitempty"`
+	AlterId    int               `json:"alter_id,omitempty"`
+	Flow       string            `json:"flow,omitempty"`
+	TLS        *singBoxTLS       `json:"tls,omitempty"`
+	Transport  *singBoxTransport `json:"transport,omitempty"`
+	Outbounds  []string          `json:"outbounds,omitempty"`
+	URL        string            `json:"url,omitempty"`
+	Interval   string            `json:"interval,omitempty"`
+}
+
+type singBoxTLS struct {
+	Enabled    bool   `json:"enabled"`
+	ServerName string `json:"server_name,omitempty"`
+	Insecure   bool   `json:"insecure,omitempty"`
+}
+
+type singBoxTransport struct {
+	Type        string            `json:"type"`
+	Path        string            `json:"path,omitempty"`
+	Host        []string          `json:"host,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ServiceName string            `json:"service_name,omitempty"`
+}
+
+// SingBox 导出sing-box配置中的 outbounds, 代理组转换为selector/urltest(fallback同样使用urltest),
+// 模板中的规则不导出; 返回因sing-box不支持而未导出的节点
+func SingBox(nodes []*subscription.Node, t *Template) (data []byte, skipped []*Skipped, err error) {
+	if t, err = resolve(t); err != nil {
+		return
+	}
+	for _, g := range t.Groups {
+		if g.Name == singBoxDirect || g.Name == singBoxBlock {
+			return nil, nil, fmt.Errorf("group name %q is reserved by sing-box", g.Name)
+		}
+	}
+	exported, skipped := prepare(t, nodes, singBoxUnsupported, nil, singBoxDirect, singBoxBlock)
+	var outbounds []*singBoxOutbound
+	for _, g := range t.layout(exported) {
+		out := &singBoxOutbound{Type: "selector", Tag: g.Name}
+		for _, member := range g.members {
+			switch member {
+			case ProxyDirect:
+				member = singBoxDirect
+			case ProxyReject:
+				member = singBoxBlock
+			}
+			out.Outbounds = append(out.Outbounds, member)
+		}
+		if g.Type != GroupSelect {
+			out.Type = "urltest"
+			out.URL, out.Interval = t.TestURL, fmt.Sprintf("%ds", t.TestInterval)
+		}
+		outbounds = append(outbounds, out)
+	}
+	for _, n := range exported {
+		outbounds = append(outbounds, newSingBoxOutbound(n))
+	}
+	outbounds = append(outbounds,
+		&singBoxOutbound{Type: "direct", Tag: singBoxDirect},
+		&singBoxOutbound{Type: "block", Tag: singBoxBlock},
+	)
+	data, err = json.MarshalIndent(map[string]interface{}{"outbounds": outbounds}, "", "  ")
+	return
+}
+
+// singBoxUnsupported sing-box不支持的节点返回原因
+func singBoxUnsupported(n *subscription.Node) string {
+	switch n.Protocol {
+	case subscription.ProtocolSS:
+		switch n.Plugin {
+		case "", "obfs-local", "simple-obfs", "v2ray-plugin":
+		default:
+			return "unsupported ss plugin " + n.Plugin
+		}
+	case subscription.ProtocolVMess, subscription.ProtocolTrojan:
+	case subscription.ProtocolVLESS:
+		if n.Security == "reality" {
+			return "reality parameters are not kept in share link nodes"
+		}
+	default:
+		return "unsupported protocol " + n.Protocol
+	}
+	switch n.Network {
+	case "", "tcp", "ws", "h2", "grpc":
+	default:
+		return "unsupported network " + n.Network
+	}
+	return ""
+}
+
+// newSingBoxOutbound 转换为sing-box出站配置
+func newSingBoxOutbound(n *exportNode) (out *singBoxOutbound) {
+	out = &singBoxOutbound{Tag: n.name, Server: n.Host, ServerPort: n.Port}
+	switch n.Protocol {
+	case subscription.ProtocolSS:
+		out.Type = "shadowsocks"
+		out.Method, out.Password = n.Cipher, n.Password
+		if n.Plugin != "" {
+			out.Plugin, out.PluginOpts = n.Plugin, n.PluginOpts
+			if n.Plugin == "simple-obfs" {
+				out.Plugin = "obfs-local"
+			}
+		}
+	case subscription.ProtocolVMess:
+		out.Type = "vmess"
+		out.UUID, out.Security, out.AlterId = n.UUID, n.Cipher, n.AlterId
+	case subscription.ProtocolVLESS:
+		out.Type = "vless"
+		out.UUID, out.Flow = n.UUID, n.Flow
+	case subscription.ProtocolTrojan:
+		out.Type = "trojan"
+		out.Password = n.Password
+	}
+	if n.Security == "tls" {
+		out.TLS = &singBoxTLS{Enabled: true, ServerName: n.SNI, Insecure: n.AllowInsecure}
+	}
+
+	switch n.Network {
+	case "ws":
+		out.Transport = &singBoxTransport{Type: "ws", Path: n.Path}
+		if n.HostHeader != "" {
+			out.Transport.Headers = map[string]string{"Host": n.HostHeader}
+		}
+	case "h2":
+		out.Transport = &singBoxTransport{Type: "http", Path: n.Path}
+		if n.HostHeader != "" {
+			out.Transport.Host = strings.Split(n.HostHeader, ",")
+		}
+	case "grpc":
+		out.Transport = &singBoxTransport{Type: "grpc", ServiceName: n.Path}
+	}
+	return
+}
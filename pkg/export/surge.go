@@ -0,0 +1,125 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/huge-kumo/net-utils/pkg/subscription"
+	"strconv"
+	"strings"
+)
+
+// Surge 导出Surge配置中的 [Proxy], [Proxy Group] 及 [Rule] 段, 规则中的 MATCH 转换为 FINAL;
+// 返回因Surge不支持而未导出的节点
+func Surge(nodes []*subscription.Node, t *Template) (data []byte, skipped []*Skipped, err error) {
+	if t, err = resolve(t); err != nil {
+		return
+	}
+	exported, skipped := prepare(t, nodes, surgeUnsupported, surgeName)
+	buf := &bytes.Buffer{}
+	buf.WriteString("[Proxy]\n")
+	for _, n := range exported {
+		buf.WriteString(surgeProxy(n) + "\n")
+	}
+
+	buf.WriteString("\n[Proxy Group]\n")
+	for _, g := range t.layout(exported) {
+		fields := append([]string{g.Type}, g.members...)
+		if g.Type != GroupSelect {
+			fields = append(fields, "url="+t.TestURL, "interval="+strconv.Itoa(t.TestInterval))
+		}
+		fmt.Fprintf(buf, "%s = %s\n", g.Name, strings.Join(fields, ", "))
+	}
+
+	if len(t.Rules) != 0 {
+		buf.WriteString("\n[Rule]\n")
+		for _, rule := range t.Rules {
+			if strings.HasPrefix(rule, "MATCH,") {
+				rule = "FINAL," + strings.TrimPrefix(rule, "MATCH,")
+			}
+			buf.WriteString(rule + "\n")
+		}
+	}
+	data = buf.Bytes()
+	return
+}
+
+// surgeUnsupported Surge不支持的节点返回原因
+func surgeUnsupported(n *subscription.Node) string {
+	switch n.Protocol {
+	case subscription.ProtocolSS:
+		switch n.Plugin {
+		case "", "obfs-local", "simple-obfs":
+		default:
+			return "unsupported ss plugin " + n.Plugin
+		}
+	case subscription.ProtocolVMess, subscription.ProtocolTrojan:
+	default:
+		return "unsupported protocol " + n.Protocol
+	}
+	switch n.Network {
+	case "", "tcp", "ws":
+	default:
+		return "unsupported network " + n.Network
+	}
+	return ""
+}
+
+// surgeName 代理名称不能包含逗号及等号
+func surgeName(name string) string {
+	return strings.TrimSpace(strings.NewReplacer(",", " ", "=", " ").Replace(name))
+}
+
+// surgeValue 包含逗号或引号的参数值加引号
+func surgeValue(s string) string {
+	if strings.ContainsAny(s, `,"`) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// surgeProxy 转换为Surge代理行, 如 name = ss, host, port, encrypt-method=..., password=...
+func surgeProxy(n *exportNode) string {
+	fields := []string{"", n.Host, strconv.Itoa(n.Port)}
+	param := func(key, val string) {
+		if val != "" {
+			fields = append(fields, key+"="+surgeValue(val))
+		}
+	}
+	switch n.Protocol {
+	case subscription.ProtocolSS:
+		fields[0] = "ss"
+		param("encrypt-method", n.Cipher)
+		param("password", n.Password)
+		if n.Plugin != "" {
+			param("obfs", n.Obfs)
+			param("obfs-host", n.ObfsParam)
+		}
+		param("udp-relay", "true")
+	case subscription.ProtocolVMess:
+		fields[0] = "vmess"
+		param("username", n.UUID)
+		if n.AlterId == 0 {
+			param("vmess-aead", "true")
+		}
+	case subscription.ProtocolTrojan:
+		fields[0] = "trojan"
+		param("password", n.Password)
+	}
+	if n.Security == "tls" {
+		if n.Protocol != subscription.ProtocolTrojan {
+			param("tls", "true")
+		}
+		param("sni", n.SNI)
+		if n.AllowInsecure {
+			param("skip-cert-verify", "true")
+		}
+	}
+	if n.Network == "ws" {
+		param("ws", "true")
+		param("ws-path", n.Path)
+		if n.HostHeader != "" {
+			param("ws-headers", "Host:"+n.HostHeader)
+		}
+	}
+	return n.name + " = " + strings.Join(fields, ", ")
+}
@@ -0,0 +1,287 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/huge-kumo/net-utils/pkg/log"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultInterval      = 30 * time.Second // 默认探测间隔
+	DefaultCooldown      = 10 * time.Minute // 默认因速度切换的最短间隔
+	DefaultThreshold     = 50               // 默认候选节点需要快多少毫秒才视为更优
+	DefaultRounds        = 3                // 默认候选节点需要连续更优的轮数
+	DefaultOfflineRounds = 2                // 默认当前节点需要连续离线的轮数
+)
+
+// Reason 切换原因
+type Reason string
+
+const (
+	ReasonInitial  Reason = "initial"  // 当前节点未知或已不在节点列表中
+	ReasonFiltered Reason = "filtered" // 当前节点被 Config.Filter 排除
+	ReasonOffline  Reason = "offline"  // 当前节点离线
+	ReasonFaster   Reason = "faster"   // 候选节点持续比当前节点快
+)
+
+var ErrNoOnlineNode = errors.New("no online proxy node") // 没有可用的候选节点
+
+// Router 控制器依赖的路由器接口, *openwrt.Router 实现了该接口
+type Router interface {
+	ListAllProxyNodeInfoContext(ctx context.Context) ([]*openwrt.ProxyNodeInfo, error)
+	TestAllProxyNodeLatency(ctx context.Context, nodes []*openwrt.ProxyNodeInfo, opts *openwrt.LatencyOptions) (*openwrt.LatencyReport, error)
+	ApplyProxyNodeToGlobalContext(ctx context.Context, p *openwrt.ProxyNodeInfo) error
+}
+
+// globalNodeReader 能够读取当前全局节点的路由器, 控制器据此发现手动切换
+type globalNodeReader interface {
+	GetGlobalProxyNodeContext(ctx context.Context) (*openwrt.ProxyNodeInfo, error)
+}
+
+// Config 控制器配置, 为空的字段使用默认值
+type Config struct {
+	Interval      time.Duration                     // 探测间隔
+	Cooldown      time.Duration                     // 因速度切换的最短间隔, 当前节点离线时不受限制
+	Threshold     int                               // 候选节点需要比当前节点快多少毫秒才视为更优
+	Rounds        int                               // 候选节点需要连续更优的轮数
+	OfflineRounds int                               // 当前节点需要连续离线的轮数
+	Filter        func(*openwrt.ProxyNodeInfo) bool // 候选节点过滤, 返回false的节点不参与测速
+	Latency       *openwrt.LatencyOptions           // 每轮测速参数
+	OnSwitch      func(*SwitchRecord)               // 切换成功后回调
+}
+
+// SwitchRecord 切换记录
+type SwitchRecord struct {
+	Time   time.Time              // 切换时间
+	From   *openwrt.ProxyNodeInfo // 切换前的节点, 未知时为空
+	To     *openwrt.ProxyNodeInfo // 切换后的节点
+	Reason Reason                 // 切换原因
+	Detail string                 // 切换原因说明
+}
+
+func (s *SwitchRecord) String() string {
+	from := "<nil>"
+	if s.From != nil {
+		from = s.From.Name
+	}
+	return fmt.Sprintf("%s %s -> %s (%s: %s)", s.Time.Format(time.RFC3339), from, s.To.Name, s.Reason, s.Detail)
+}
+
+// Controller 定期探测节点并自动切换全局代理节点
+type Controller struct {
+	router Router
+	conf   Config
+	now    func() time.Time
+
+	probeMu sync.Mutex // 串行化探测, 探测期间不持有mu, 不阻塞 Current 等查询
+
+	mu            sync.Mutex
+	current       *openwrt.ProxyNodeInfo
+	lastSwitch    time.Time
+	offlineRounds int
+	fasterRounds  int
+	filteredFrom  string // 因被过滤而切换离开的节点编号, 之后手动切换回该节点时不再切换
+	records       []*SwitchRecord
+}
+
+// NewController 创建切换控制器
+func NewController(router Router, conf *Config) (c *Controller) {
+	c = &Controller{
+		router: router,
+		now:    time.Now,
+	}
+	if conf != nil {
+		c.conf = *conf
+	}
+	if c.conf.Interval <= 0 {
+		c.conf.Interval = DefaultInterval
+	}
+	if c.conf.Cooldown <= 0 {
+		c.conf.Cooldown = DefaultCooldown
+	}
+	if c.conf.Threshold <= 0 {
+		c.conf.Threshold = DefaultThreshold
+	}
+	if c.conf.Rounds <= 0 {
+		c.conf.Rounds = DefaultRounds
+	}
+	if c.conf.OfflineRounds <= 0 {
+		c.conf.OfflineRounds = DefaultOfflineRounds
+	}
+	return
+}
+
+// SetCurrent 指定路由器当前使用的全局节点, 未指定时首轮探测会切换到最快的节点;
+// 路由器支持读取全局节点时以路由器的实际值为准
+func (c *Controller) SetCurrent(pn *openwrt.ProxyNodeInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = pn
+	c.offlineRounds = 0
+	c.fasterRounds = 0
+}
+
+// Current 控制器记录的当前全局节点
+func (c *Controller) Current() *openwrt.ProxyNodeInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current
+}
+
+// Records 所有切换记录
+func (c *Controller) Records() []*SwitchRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*SwitchRecord(nil), c.records...)
+}
+
+// Run 按探测间隔持续运行直到ctx取消, 单轮探测失败只记录日志
+func (c *Controller) Run(ctx context.Context) (err error) {
+	ticker := time.NewTicker(c.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err = c.Probe(ctx); err != nil && ctx.Err() == nil {
+			log.GetInstance().Warnf("[节点切换] 探测失败 %s", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Probe 执行一轮探测, 需要切换时应用新节点并返回切换记录; 测速及切换等请求期间不持有状态锁
+func (c *Controller) Probe(ctx context.Context) (record *SwitchRecord, err error) {
+	c.probeMu.Lock()
+	defer c.probeMu.Unlock()
+
+	var actual *openwrt.ProxyNodeInfo
+	if reader, ok := c.router.(globalNodeReader); ok {
+		if actual, err = reader.GetGlobalProxyNodeContext(ctx); err != nil {
+			return
+		}
+	}
+
+	// 探测候选节点
+	nodes, err := c.router.ListAllProxyNodeInfoContext(ctx)
+	if err != nil {
+		return
+	}
+	var candidates []*openwrt.ProxyNodeInfo
+	for _, pn := range nodes {
+		if c.conf.Filter == nil || c.conf.Filter(pn) {
+			candidates = append(candidates, pn)
+		}
+	}
+	report, err := c.router.TestAllProxyNodeLatency(ctx, candidates, c.conf.Latency)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	record, err = c.decide(actual, nodes, candidates, report)
+	c.mu.Unlock()
+	if record == nil || err != nil {
+		return
+	}
+
+	// 应用最快的节点
+	if err = c.router.ApplyProxyNodeToGlobalContext(ctx, record.To); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	record.Time = c.now()
+	c.current = record.To
+	c.lastSwitch = record.Time
+	c.offlineRounds = 0
+	c.fasterRounds = 0
+	c.records = append(c.records, record)
+	if record.Reason == ReasonFiltered {
+		c.filteredFrom = record.From.Id
+	}
+	c.mu.Unlock()
+	log.GetInstance().Infof("[节点切换] %s", record.String())
+	if c.conf.OnSwitch != nil {
+		c.conf.OnSwitch(record)
+	}
+	return
+}
+
+// decide 根据测速结果更新计数并判断是否需要切换, 不需要切换时返回nil; 调用方需持有mu.
+// actual 为路由器实际使用的节点, 与记录的节点不同时视为手动切换并重新计数;
+// 当前节点被过滤时只切换一次, 之后手动切换回该节点时保持不变
+func (c *Controller) decide(actual *openwrt.ProxyNodeInfo, nodes, candidates []*openwrt.ProxyNodeInfo, report *openwrt.LatencyReport) (record *SwitchRecord, err error) {
+	if actual != nil && (c.current == nil || c.current.Id != actual.Id) {
+		if c.current != nil {
+			log.GetInstance().Infof("[节点切换] 检测到手动切换 %s -> %s", c.current.Name, actual.Name)
+		}
+		c.current = actual
+		c.offlineRounds = 0
+		c.fasterRounds = 0
+	}
+
+	current := findNode(candidates, c.current)
+	if current != nil {
+		c.current = current
+	}
+	record = &SwitchRecord{From: c.current}
+	switch filtered := findNode(nodes, c.current); {
+	case current == nil && filtered != nil:
+		if filtered.Id == c.filteredFrom {
+			return nil, nil
+		}
+		c.current, record.From = filtered, filtered
+		record.Reason = ReasonFiltered
+		record.Detail = "current node excluded by filter"
+	case current == nil:
+		record.Reason = ReasonInitial
+		record.Detail = "current node unknown or removed"
+	case current.Offline:
+		c.fasterRounds = 0
+		if c.offlineRounds++; c.offlineRounds < c.conf.OfflineRounds {
+			return nil, nil
+		}
+		record.Reason = ReasonOffline
+		record.Detail = fmt.Sprintf("current node offline for %d rounds", c.offlineRounds)
+	default:
+		c.offlineRounds = 0
+		if len(report.Online) == 0 || report.Online[0].Latency+c.conf.Threshold > current.Latency {
+			c.fasterRounds = 0
+			return nil, nil
+		}
+		if c.fasterRounds++; c.fasterRounds < c.conf.Rounds || c.now().Sub(c.lastSwitch) < c.conf.Cooldown {
+			return nil, nil
+		}
+		record.Reason = ReasonFaster
+		record.Detail = fmt.Sprintf("candidate %dms vs current %dms for %d rounds", report.Online[0].Latency, current.Latency, c.fasterRounds)
+	}
+	if len(report.Online) == 0 {
+		return nil, ErrNoOnlineNode
+	}
+	record.To = report.Online[0].Node
+	return
+}
+
+// findNode 在节点列表中查找目标节点, 编号变化时按主机地址和端口匹配
+func findNode(nodes []*openwrt.ProxyNodeInfo, target *openwrt.ProxyNodeInfo) *openwrt.ProxyNodeInfo {
+	if target == nil {
+		return nil
+	}
+	for _, pn := range nodes {
+		if pn.Id == target.Id {
+			return pn
+		}
+	}
+	for _, pn := range nodes {
+		if pn.Host == target.Host && pn.Port == target.Port {
+			return pn
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package failover
+
+import (
+	"context"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fakeRouter 按轮次返回预设延迟的路由器, 延迟为负数表示离线
+type fakeRouter struct {
+	latencies map[string]int
+	applied   []string
+}
+
+func (f *fakeRouter) ListAllProxyNodeInfoContext(ctx context.Context) (pns []*openwrt.ProxyNodeInfo, err error) {
+	for id := range f.latencies {
+		pns = append(pns, &openwrt.ProxyNodeInfo{Id: id, Name: id, Host: id + ".example.com", Port: "443"})
+	}
+	sort.Slice(pns, func(i, j int) bool { return pns[i].Id < pns[j].Id })
+	return
+}
+
+func (f *fakeRouter) TestAllProxyNodeLatency(ctx context.Context, nodes []*openwrt.ProxyNodeInfo, opts *openwrt.LatencyOptions) (report *openwrt.LatencyReport, err error) {
+	report = &openwrt.LatencyReport{}
+	for _, pn := range nodes {
+		res := &openwrt.LatencyResult{Node: pn, Latency: f.latencies[pn.Id]}
+		pn.Latency = res.Latency
+		if res.Latency < 0 {
+			res.Offline, pn.Offline = true, true
+			report.Offline = append(report.Offline, res)
+			continue
+		}
+		report.Online = append(report.Online, res)
+	}
+	sort.SliceStable(report.Online, func(i, j int) bool { return report.Online[i].Latency < report.Online[j].Latency })
+	return
+}
+
+func (f *fakeRouter) ApplyProxyNodeToGlobalContext(ctx context.Context, p *openwrt.ProxyNodeInfo) error {
+	f.applied = append(f.applied, p.Id)
+	return nil
+}
+
+func TestController_Probe(t *testing.T) {
+	router := &fakeRouter{latencies: map[string]int{"a": 100, "b": 200, "c": -1}}
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewController(router, &Config{Threshold: 50, Rounds: 2, OfflineRounds: 2, Cooldown: time.Hour})
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	probe := func(want Reason) {
+		t.Helper()
+		record, err := c.Probe(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want == "" && record != nil {
+			t.Fatalf("unexpected switch %s", record)
+		}
+		if want != "" && (record == nil || record.Reason != want) {
+			t.Fatalf("got %v, want switch for %s", record, want)
+		}
+		now = now.Add(time.Minute)
+	}
+
+	// 首轮切换到最快节点
+	probe(ReasonInitial)
+	if c.Current().Id != "a" {
+		t.Fatalf("got current %s, want a", c.Current().Id)
+	}
+
+	// 当前节点连续离线两轮才切换, 且不受冷却时间限制
+	router.latencies["a"] = -1
+	probe("")
+	probe(ReasonOffline)
+	if c.Current().Id != "b" {
+		t.Fatalf("got current %s, want b", c.Current().Id)
+	}
+
+	// 候选节点持续更快但仍在冷却时间内
+	router.latencies["a"] = 20
+	probe("")
+	probe("")
+	now = now.Add(time.Hour)
+	probe(ReasonFaster)
+
+	// 差距小于阈值时不切换
+	router.latencies["b"] = 10
+	probe("")
+	probe("")
+
+	if got := len(c.Records()); got != 3 {
+		t.Errorf("got %d records, want 3", got)
+	}
+	if len(router.applied) != 3 || router.applied[2] != "a" {
+		t.Errorf("got applied %v", router.applied)
+	}
+}
+
+// globalRouter 能够读取当前全局节点的路由器
+type globalRouter struct {
+	fakeRouter
+	global string
+}
+
+func (g *globalRouter) ApplyProxyNodeToGlobalContext(ctx context.Context, p *openwrt.ProxyNodeInfo) error {
+	g.global = p.Id
+	return g.fakeRouter.ApplyProxyNodeToGlobalContext(ctx, p)
+}
+
+func (g *globalRouter) GetGlobalProxyNodeContext(ctx context.Context) (*openwrt.ProxyNodeInfo, error) {
+	if g.global == "" {
+		return nil, nil
+	}
+	return &openwrt.ProxyNodeInfo{Id: g.global, Name: g.global}, nil
+}
+
+func TestController_ProbeManualSwitch(t *testing.T) {
+	router := &globalRouter{fakeRouter: fakeRouter{latencies: map[string]int{"a": 100, "b": 110}}, global: "b"}
+	c := NewController(router, &Config{Threshold: 50})
+	ctx := context.Background()
+
+	// 路由器已有全局节点时不做初始切换
+	if record, err := c.Probe(ctx); err != nil || record != nil {
+		t.Fatalf("got %v %v, want no switch", record, err)
+	}
+	if c.Current().Id != "b" {
+		t.Fatalf("got current %s, want b", c.Current().Id)
+	}
+
+	// 手动切换后以路由器为准
+	router.global = "a"
+	if record, err := c.Probe(ctx); err != nil || record != nil {
+		t.Fatalf("got %v %v, want no switch", record, err)
+	}
+	if c.Current().Id != "a" {
+		t.Fatalf("got current %s, want a", c.Current().Id)
+	}
+	if len(router.applied) != 0 {
+		t.Errorf("got applied %v", router.applied)
+	}
+}
+
+func TestController_ProbeFiltered(t *testing.T) {
+	router := &globalRouter{fakeRouter: fakeRouter{latencies: map[string]int{"a": 100, "b": 110, "c": 50}}, global: "c"}
+	c := NewController(router, &Config{Filter: func(pn *openwrt.ProxyNodeInfo) bool { return pn.Id != "c" }})
+	ctx := context.Background()
+
+	// 当前节点被过滤时切换一次
+	record, err := c.Probe(ctx)
+	if err != nil || record == nil || record.Reason != ReasonFiltered || record.From.Id != "c" || record.To.Id != "a" {
+		t.Fatalf("got %v %v, want filtered switch to a", record, err)
+	}
+	if record, err = c.Probe(ctx); err != nil || record != nil {
+		t.Fatalf("got %v %v, want no switch", record, err)
+	}
+
+	// 手动切换回被过滤的节点后保持不变
+	router.global = "c"
+	for i := 0; i < 3; i++ {
+		if record, err = c.Probe(ctx); err != nil || record != nil {
+			t.Fatalf("got %v %v, want no switch", record, err)
+		}
+	}
+	if len(router.applied) != 1 || len(c.Records()) != 1 {
+		t.Errorf("got applied %v records %v", router.applied, c.Records())
+	}
+}
+
+// blockingRouter 测速时等待release, 用于检查探测期间不阻塞查询
+type blockingRouter struct {
+	*fakeRouter
+	testing, release chan struct{}
+}
+
+func (b *blockingRouter) TestAllProxyNodeLatency(ctx context.Context, nodes []*openwrt.ProxyNodeInfo, opts *openwrt.LatencyOptions) (*openwrt.LatencyReport, error) {
+	close(b.testing)
+	<-b.release
+	return b.fakeRouter.TestAllProxyNodeLatency(ctx, nodes, opts)
+}
+
+func TestController_ProbeUnlocked(t *testing.T) {
+	router := &blockingRouter{
+		fakeRouter: &fakeRouter{latencies: map[string]int{"a": 100}},
+		testing:    make(chan struct{}),
+		release:    make(chan struct{}),
+	}
+	c := NewController(router, nil)
+	done := make(chan error)
+	go func() {
+		_, err := c.Probe(context.Background())
+		done <- err
+	}()
+
+	<-router.testing
+	queried := make(chan struct{})
+	go func() {
+		c.Current()
+		c.Records()
+		close(queried)
+	}()
+	select {
+	case <-queried:
+	case <-time.After(time.Second):
+		t.Fatal("Current blocked by a running probe")
+	}
+	close(router.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if c.Current() == nil || c.Current().Id != "a" {
+		t.Fatalf("got current %v, want a", c.Current())
+	}
+}
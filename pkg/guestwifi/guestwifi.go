@@ -0,0 +1,70 @@
+package guestwifi
+
+import (
+	"context"
+	"github.com/huge-kumo/net-utils/pkg/log"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"time"
+)
+
+const DefaultInterval = 7 * 24 * time.Hour // 默认每周轮换一次
+
+// Router 轮换依赖的路由器接口, *openwrt.Router 实现了该接口
+type Router interface {
+	RotateWifiKeyContext(ctx context.Context, name string, opts *openwrt.UCIApplyOptions) (string, error)
+}
+
+// Config 轮换配置, 为空的字段使用默认值
+type Config struct {
+	Interface string                   // 访客网络的无线接口配置节名称, 如 guest
+	Interval  time.Duration            // 轮换间隔
+	Apply     *openwrt.UCIApplyOptions // 应用修改的参数
+	OnRotate  func(key string)         // 轮换成功后回调, 用于通知新密码
+}
+
+// Rotator 定期轮换访客网络密码
+type Rotator struct {
+	router Router
+	conf   Config
+}
+
+// NewRotator 创建访客网络密码轮换器
+func NewRotator(router Router, conf *Config) (r *Rotator) {
+	r = &Rotator{router: router}
+	if conf != nil {
+		r.conf = *conf
+	}
+	if r.conf.Interval <= 0 {
+		r.conf.Interval = DefaultInterval
+	}
+	return
+}
+
+// Rotate 立即轮换一次密码
+func (r *Rotator) Rotate(ctx context.Context) (key string, err error) {
+	if key, err = r.router.RotateWifiKeyContext(ctx, r.conf.Interface, r.conf.Apply); err != nil {
+		return
+	}
+	log.GetInstance().Infof("[访客网络] %s 密码已轮换", r.conf.Interface)
+	if r.conf.OnRotate != nil {
+		r.conf.OnRotate(key)
+	}
+	return
+}
+
+// Run 每个轮换间隔轮换一次密码直到ctx取消, 启动时不轮换; 单次轮换失败只记录日志
+func (r *Rotator) Run(ctx context.Context) (err error) {
+	ticker := time.NewTicker(r.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if _, err = r.Rotate(ctx); err != nil && ctx.Err() == nil {
+			log.GetInstance().Warnf("[访客网络] %s 密码轮换失败 %s", r.conf.Interface, err.Error())
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package guestwifi
+
+import (
+	"context"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"testing"
+	"time"
+)
+
+type fakeRouter struct {
+	rotated []string
+}
+
+func (f *fakeRouter) RotateWifiKeyContext(ctx context.Context, name string, opts *openwrt.UCIApplyOptions) (string, error) {
+	f.rotated = append(f.rotated, name)
+	return "newkey123456", nil
+}
+
+func TestRotator_Run(t *testing.T) {
+	router := &fakeRouter{}
+	keys := make(chan string, 10)
+	r := NewRotator(router, &Config{
+		Interface: "guest",
+		Interval:  10 * time.Millisecond,
+		OnRotate:  func(key string) { keys <- key },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.Run(ctx) }()
+	for i := 0; i < 2; i++ {
+		select {
+		case key := <-keys:
+			if key != "newkey123456" {
+				t.Errorf("got key %q", key)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("rotation not triggered")
+		}
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if len(router.rotated) < 2 || router.rotated[0] != "guest" {
+		t.Errorf("got rotated %v", router.rotated)
+	}
+}
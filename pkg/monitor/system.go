@@ -0,0 +1,67 @@
+package monitor
+
+import (
+	"context"
+	"github.com/huge-kumo/net-utils/pkg/log"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"time"
+)
+
+const DefaultSystemInterval = time.Minute // 默认系统状态采样间隔
+
+// SystemRouter 系统状态采样依赖的路由器接口, *openwrt.Router 实现了该接口
+type SystemRouter interface {
+	GetSystemInfoContext(ctx context.Context) (*openwrt.SystemInfo, error)
+}
+
+// SystemRecorder 系统状态记录器, *db.SystemRecorder 实现了该接口
+type SystemRecorder interface {
+	RecordSystemInfo(info *openwrt.SystemInfo, at time.Time) error
+}
+
+// SystemPoller 定期采样路由器系统状态并交给记录器保存
+type SystemPoller struct {
+	router   SystemRouter
+	recorder SystemRecorder
+	interval time.Duration
+	now      func() time.Time
+}
+
+// NewSystemPoller 创建系统状态采样器, interval为空时使用 DefaultSystemInterval
+func NewSystemPoller(router SystemRouter, recorder SystemRecorder, interval time.Duration) *SystemPoller {
+	if interval <= 0 {
+		interval = DefaultSystemInterval
+	}
+	return &SystemPoller{
+		router:   router,
+		recorder: recorder,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Poll 执行一次采样并记录
+func (p *SystemPoller) Poll(ctx context.Context) (info *openwrt.SystemInfo, err error) {
+	if info, err = p.router.GetSystemInfoContext(ctx); err != nil {
+		return
+	}
+	err = p.recorder.RecordSystemInfo(info, p.now())
+	return
+}
+
+// Run 按采样间隔持续运行直到ctx取消, 单次采样失败只记录日志
+func (p *SystemPoller) Run(ctx context.Context) (err error) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err = p.Poll(ctx); err != nil && ctx.Err() == nil {
+			log.GetInstance().Warnf("[系统监控] 采样失败 %s", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
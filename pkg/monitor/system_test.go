@@ -0,0 +1,55 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"testing"
+	"time"
+)
+
+type fakeSystemRouter struct {
+	uptime time.Duration
+	err    error
+}
+
+func (f *fakeSystemRouter) GetSystemInfoContext(ctx context.Context) (*openwrt.SystemInfo, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.uptime += time.Minute
+	return &openwrt.SystemInfo{Uptime: f.uptime}, nil
+}
+
+type fakeSystemRecorder struct {
+	times []time.Time
+}
+
+func (f *fakeSystemRecorder) RecordSystemInfo(info *openwrt.SystemInfo, at time.Time) error {
+	f.times = append(f.times, at)
+	return nil
+}
+
+func TestSystemPoller_Poll(t *testing.T) {
+	router, recorder := &fakeSystemRouter{}, &fakeSystemRecorder{}
+	p := NewSystemPoller(router, recorder, 0)
+	now := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+
+	info, err := p.Poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Uptime != time.Minute || len(recorder.times) != 1 || !recorder.times[0].Equal(now) {
+		t.Errorf("got uptime %s records %v", info.Uptime, recorder.times)
+	}
+
+	// 采样失败时不记录
+	router.err = errors.New("unreachable")
+	if _, err = p.Poll(context.Background()); err == nil {
+		t.Error("expected poll error")
+	}
+	if len(recorder.times) != 1 {
+		t.Errorf("got %d records, want 1", len(recorder.times))
+	}
+}
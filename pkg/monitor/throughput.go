@@ -0,0 +1,173 @@
+package monitor
+
+import (
+	"context"
+	"github.com/huge-kumo/net-utils/pkg/log"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"math"
+	"time"
+)
+
+const DefaultThroughputInterval = 5 * time.Second // 默认吞吐量采样间隔
+
+// ThroughputRouter 吞吐量采样依赖的路由器接口, *openwrt.Router 实现了该接口
+type ThroughputRouter interface {
+	ListInterfacesContext(ctx context.Context) ([]*openwrt.Interface, error)
+}
+
+// ThroughputRecorder 吞吐量记录器, *db.ThroughputRecorder 实现了该接口
+type ThroughputRecorder interface {
+	RecordThroughput(items []*Throughput) error
+}
+
+// ThroughputRecorderFunc 将函数转换为吞吐量记录器, 可用于实时输出
+type ThroughputRecorderFunc func(items []*Throughput) error
+
+// RecordThroughput 调用函数本身
+func (f ThroughputRecorderFunc) RecordThroughput(items []*Throughput) error {
+	return f(items)
+}
+
+// Throughput 接口在一个采样周期内的吞吐量
+type Throughput struct {
+	Interface string        // 接口名称
+	Device    string        // 三层设备名称
+	Time      time.Time     // 采样时间
+	Interval  time.Duration // 计算速率使用的时长
+	RxBytes   uint64        // 周期内接收字节数
+	TxBytes   uint64        // 周期内发送字节数
+	RxRate    float64       // 接收速率, 字节每秒
+	TxRate    float64       // 发送速率, 字节每秒
+	Reset     bool          // 周期内接口重启或计数器清零, 数值只统计重启之后的部分
+}
+
+// throughputBaseline 上一次的计数
+type throughputBaseline struct {
+	device           string
+	uptime           time.Duration
+	rxBytes, txBytes uint64
+	time             time.Time
+}
+
+// ThroughputSampler 根据相邻两次的接口流量计数计算吞吐量
+type ThroughputSampler struct {
+	router   ThroughputRouter
+	recorder ThroughputRecorder
+	interval time.Duration
+	now      func() time.Time
+	prev     map[string]*throughputBaseline
+}
+
+// NewThroughputSampler 创建吞吐量采样器, recorder可以为空, interval为空时使用 DefaultThroughputInterval
+func NewThroughputSampler(router ThroughputRouter, recorder ThroughputRecorder, interval time.Duration) *ThroughputSampler {
+	if interval <= 0 {
+		interval = DefaultThroughputInterval
+	}
+	return &ThroughputSampler{
+		router:   router,
+		recorder: recorder,
+		interval: interval,
+		now:      time.Now,
+		prev:     map[string]*throughputBaseline{},
+	}
+}
+
+// Sample 读取一次接口计数并与上一次比较, 首次采样及新出现的接口只记录基线;
+// 未启动的接口不计算吞吐量
+func (s *ThroughputSampler) Sample(ctx context.Context) (items []*Throughput, err error) {
+	ifaces, err := s.router.ListInterfacesContext(ctx)
+	if err != nil {
+		return
+	}
+	now := s.now()
+	seen := map[string]bool{}
+	for _, iface := range ifaces {
+		if !iface.Up {
+			continue
+		}
+		seen[iface.Name] = true
+		cur := &throughputBaseline{
+			device:  iface.Device,
+			uptime:  iface.Uptime,
+			rxBytes: iface.RxBytes,
+			txBytes: iface.TxBytes,
+			time:    now,
+		}
+		prev, ok := s.prev[iface.Name]
+		s.prev[iface.Name] = cur
+		if !ok {
+			continue
+		}
+		if item := throughputBetween(iface.Name, prev, cur); item != nil {
+			items = append(items, item)
+		}
+	}
+	for name := range s.prev {
+		if !seen[name] {
+			delete(s.prev, name)
+		}
+	}
+
+	if s.recorder != nil && len(items) != 0 {
+		err = s.recorder.RecordThroughput(items)
+	}
+	return
+}
+
+// throughputBetween 计算两次计数之间的吞吐量, 时间间隔无效时返回nil
+func throughputBetween(name string, prev, cur *throughputBaseline) (item *Throughput) {
+	elapsed := cur.time.Sub(prev.time)
+	if elapsed <= 0 {
+		return nil
+	}
+	// 设备变化时计数器属于新设备; 运行时间变短说明接口重启过, 但持久存在的设备(如ethX, br-lan)不会清零计数器
+	deviceChanged := cur.device != prev.device
+	restarted := cur.uptime < prev.uptime
+	item = &Throughput{
+		Interface: name,
+		Device:    cur.device,
+		Time:      cur.time,
+		Interval:  elapsed,
+	}
+	var rxReset, txReset bool
+	item.RxBytes, rxReset = counterDelta(prev.rxBytes, cur.rxBytes, deviceChanged, restarted)
+	item.TxBytes, txReset = counterDelta(prev.txBytes, cur.txBytes, deviceChanged, restarted)
+	item.Reset = rxReset || txReset
+	if item.Reset && cur.uptime > 0 && cur.uptime < elapsed {
+		item.Interval = cur.uptime
+	}
+	item.RxRate = float64(item.RxBytes) / item.Interval.Seconds()
+	item.TxRate = float64(item.TxBytes) / item.Interval.Seconds()
+	return
+}
+
+// counterDelta 计算计数器增量; 设备变化时视为计数器清零, 增量为当前计数; 计数不变小时按差值计算;
+// 计数变小时, 接口未重启且上一次计数在32位范围内视为32位计数器回绕, 否则视为计数器清零
+func counterDelta(prev, cur uint64, deviceChanged, restarted bool) (delta uint64, reset bool) {
+	switch {
+	case deviceChanged:
+		return cur, true
+	case cur >= prev:
+		return cur - prev, false
+	case !restarted && prev <= math.MaxUint32:
+		return cur + math.MaxUint32 + 1 - prev, false
+	}
+	return cur, true
+}
+
+// Run 按采样间隔持续运行直到ctx取消, 单次采样失败只记录日志
+func (s *ThroughputSampler) Run(ctx context.Context) (err error) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err = s.Sample(ctx); err != nil && ctx.Err() == nil {
+			log.GetInstance().Warnf("[流量监控] 采样失败 %s", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package monitor
+
+import (
+	"context"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"math"
+	"testing"
+	"time"
+)
+
+type fakeThroughputRouter struct {
+	ifaces []*openwrt.Interface
+}
+
+func (f *fakeThroughputRouter) ListInterfacesContext(ctx context.Context) ([]*openwrt.Interface, error) {
+	return f.ifaces, nil
+}
+
+func TestThroughputSampler_Sample(t *testing.T) {
+	wan := &openwrt.Interface{Name: "wan", Device: "pppoe-wan", Up: true, Uptime: time.Hour, RxBytes: 1000, TxBytes: math.MaxUint32 - 99}
+	router := &fakeThroughputRouter{ifaces: []*openwrt.Interface{wan}}
+	var recorded [][]*Throughput
+	s := NewThroughputSampler(router, ThroughputRecorderFunc(func(items []*Throughput) error {
+		recorded = append(recorded, items)
+		return nil
+	}), 0)
+	now := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	// 首次采样只记录基线
+	items, err := s.Sample(context.Background())
+	if err != nil || len(items) != 0 || len(recorded) != 0 {
+		t.Fatalf("got items %v err %v", items, err)
+	}
+
+	// 发送计数32位回绕
+	now = now.Add(10 * time.Second)
+	wan.Uptime += 10 * time.Second
+	wan.RxBytes, wan.TxBytes = 11000, 100
+	if items, err = s.Sample(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].RxBytes != 10000 || items[0].RxRate != 1000 || items[0].TxBytes != 200 || items[0].Reset || len(recorded) != 1 {
+		t.Errorf("got items %+v", items[0])
+	}
+
+	// 接口重启, 计数器清零, 速率按重启后的运行时间计算
+	now = now.Add(10 * time.Second)
+	wan.Uptime = 2 * time.Second
+	wan.RxBytes, wan.TxBytes = 4000, 50
+	if items, err = s.Sample(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || !items[0].Reset || items[0].RxBytes != 4000 || items[0].RxRate != 2000 || items[0].Interval != 2*time.Second {
+		t.Errorf("got items %+v", items[0])
+	}
+
+	// 设备不变的接口重启不会清零计数器, 仍按差值及采样间隔计算
+	now = now.Add(10 * time.Second)
+	wan.Uptime = time.Second
+	wan.RxBytes, wan.TxBytes = 5000, 50
+	if items, err = s.Sample(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Reset || items[0].RxBytes != 1000 || items[0].RxRate != 100 || items[0].Interval != 10*time.Second {
+		t.Errorf("got items %+v", items[0])
+	}
+
+	// 接口停止后重新出现时重新建立基线
+	wan.Up = false
+	if items, _ = s.Sample(context.Background()); len(items) != 0 {
+		t.Errorf("got items %+v", items)
+	}
+	wan.Up = true
+	now = now.Add(10 * time.Second)
+	if items, _ = s.Sample(context.Background()); len(items) != 0 {
+		t.Errorf("got items %+v", items)
+	}
+}
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		prev, cur     uint64
+		deviceChanged bool
+		restarted     bool
+		delta         uint64
+		reset         bool
+	}{
+		{100, 150, false, false, 50, false},
+		{100, 150, false, true, 50, false},
+		{100, 100, false, true, 0, false},
+		{100, 150, true, false, 150, true},
+		{math.MaxUint32, 9, false, false, 10, false},
+		{math.MaxUint32, 9, false, true, 9, true},
+		{math.MaxUint32 + 100, 9, false, false, 9, true},
+	}
+	for _, tt := range tests {
+		if delta, reset := counterDelta(tt.prev, tt.cur, tt.deviceChanged, tt.restarted); delta != tt.delta || reset != tt.reset {
+			t.Errorf("counterDelta(%d, %d, %v, %v) = %d, %v", tt.prev, tt.cur, tt.deviceChanged, tt.restarted, delta, reset)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package nodemeta
+
+import (
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Meta 从节点名称中解析出的元数据
+type Meta struct {
+	Region     string   // 地区代码, ISO 3166-1 alpha-2, 如 HK; 无法识别时为空
+	RegionName string   // 地区中文名称, 如 香港
+	Tags       []string // 线路标签, 如 IPLC/IEPL/BGP, 按名称中出现的顺序
+	Multiplier float64  // 流量倍率, 名称中没有时为1
+	Sequence   int      // 序号, 名称中没有时为0
+}
+
+// HasTag 是否包含线路标签, 不区分大小写
+func (m *Meta) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// region 地区及其在节点名称中的写法
+type region struct {
+	code    string
+	name    string
+	chinese []string // 中文名称及城市, 按子串匹配
+	english []string // 英文名称, 城市及三位代码, 按单词匹配, 三位代码区分大小写
+}
+
+// regions 可识别的地区
+var regions = []*region{
+	{"HK", "香港", []string{"香港", "深港", "沪港", "京港", "广港"}, []string{"Hong Kong", "HongKong", "HKG"}},
+	{"TW", "台湾", []string{"台湾", "臺灣", "台灣", "台北", "新北", "彰化"}, []string{"Taiwan", "Taipei", "TWN"}},
+	{"MO", "澳门", []string{"澳门", "澳門"}, []string{"Macau", "Macao", "MAC"}},
+	{"JP", "日本", []string{"日本", "东京", "東京", "大阪", "埼玉"}, []string{"Japan", "Tokyo", "Osaka", "JPN"}},
+	{"KR", "韩国", []string{"韩国", "韓國", "首尔", "春川"}, []string{"Korea", "Seoul", "KOR"}},
+	{"SG", "新加坡", []string{"新加坡", "狮城", "獅城"}, []string{"Singapore", "SGP"}},
+	{"US", "美国", []string{"美国", "美國", "洛杉矶", "圣何塞", "硅谷", "西雅图", "芝加哥", "纽约", "达拉斯", "凤凰城", "波特兰"}, []string{"United States", "America", "Los Angeles", "San Jose", "Silicon Valley", "Seattle", "Chicago", "New York", "Dallas", "USA"}},
+	{"CA", "加拿大", []string{"加拿大", "多伦多", "温哥华", "蒙特利尔"}, []string{"Canada", "Toronto", "Vancouver", "Montreal", "CAN"}},
+	{"GB", "英国", []string{"英国", "英國", "伦敦"}, []string{"United Kingdom", "Britain", "England", "London", "GBR"}},
+	{"DE", "德国", []string{"德国", "德國", "法兰克福"}, []string{"Germany", "Frankfurt", "DEU"}},
+	{"FR", "法国", []string{"法国", "法國", "巴黎"}, []string{"France", "Paris", "FRA"}},
+	{"NL", "荷兰", []string{"荷兰", "荷蘭", "阿姆斯特丹"}, []string{"Netherlands", "Amsterdam", "NLD"}},
+	{"IT", "意大利", []string{"意大利", "米兰"}, []string{"Italy", "Milan", "ITA"}},
+	{"ES", "西班牙", []string{"西班牙"}, []string{"Spain", "ESP"}},
+	{"CH", "瑞士", []string{"瑞士"}, []string{"Switzerland", "Zurich", "CHE"}},
+	{"SE", "瑞典", []string{"瑞典"}, []string{"Sweden", "SWE"}},
+	{"IE", "爱尔兰", []string{"爱尔兰", "愛爾蘭"}, []string{"Ireland", "IRL"}},
+	{"PL", "波兰", []string{"波兰", "波蘭"}, []string{"Poland", "POL"}},
+	{"UA", "乌克兰", []string{"乌克兰", "烏克蘭"}, []string{"Ukraine", "UKR"}},
+	{"RU", "俄罗斯", []string{"俄罗斯", "俄羅斯", "莫斯科", "伯力"}, []string{"Russia", "Moscow", "RUS"}},
+	{"TR", "土耳其", []string{"土耳其", "伊斯坦布尔"}, []string{"Turkey", "Türkiye", "Istanbul", "TUR"}},
+	{"IN", "印度", []string{"印度", "孟买"}, []string{"India", "Mumbai", "IND"}},
+	{"AU", "澳大利亚", []string{"澳大利亚", "澳洲", "悉尼", "墨尔本"}, []string{"Australia", "Sydney", "Melbourne", "AUS"}},
+	{"NZ", "新西兰", []string{"新西兰", "紐西蘭"}, []string{"New Zealand", "NZL"}},
+	{"MY", "马来西亚", []string{"马来西亚", "馬來西亞", "吉隆坡"}, []string{"Malaysia", "Kuala Lumpur", "MYS"}},
+	{"TH", "泰国", []string{"泰国", "泰國", "曼谷"}, []string{"Thailand", "Bangkok", "THA"}},
+	{"VN", "越南", []string{"越南", "胡志明"}, []string{"Vietnam", "VNM"}},
+	{"PH", "菲律宾", []string{"菲律宾", "菲律賓", "马尼拉"}, []string{"Philippines", "Manila", "PHL"}},
+	{"ID", "印度尼西亚", []string{"印度尼西亚", "印尼", "雅加达"}, []string{"Indonesia", "Jakarta", "IDN"}},
+	{"AE", "阿联酋", []string{"阿联酋", "迪拜"}, []string{"United Arab Emirates", "Dubai", "UAE", "ARE"}},
+	{"IL", "以色列", []string{"以色列"}, []string{"Israel", "ISR"}},
+	{"ZA", "南非", []string{"南非", "约翰内斯堡"}, []string{"South Africa", "Johannesburg", "ZAF"}},
+	{"EG", "埃及", []string{"埃及"}, []string{"Egypt", "EGY"}},
+	{"NG", "尼日利亚", []string{"尼日利亚"}, []string{"Nigeria", "NGA"}},
+	{"BR", "巴西", []string{"巴西", "圣保罗"}, []string{"Brazil", "Sao Paulo", "BRA"}},
+	{"AR", "阿根廷", []string{"阿根廷"}, []string{"Argentina", "ARG"}},
+	{"MX", "墨西哥", []string{"墨西哥"}, []string{"Mexico", "MEX"}},
+	{"CL", "智利", []string{"智利"}, []string{"Chile", "CHL"}},
+	{"KZ", "哈萨克斯坦", []string{"哈萨克斯坦", "哈萨克"}, []string{"Kazakhstan", "KAZ"}},
+	{"PK", "巴基斯坦", []string{"巴基斯坦"}, []string{"Pakistan", "PAK"}},
+	{"CN", "中国", []string{"中国", "中國", "回国", "上海", "北京", "广州", "深圳", "杭州"}, []string{"China", "CHN"}},
+}
+
+// regionByCode 按地区代码索引
+var regionByCode = map[string]*region{}
+
+// englishPatterns 英文名称的单词匹配规则, codePatterns 大写三位代码的匹配规则, 均与regions一一对应
+var englishPatterns, codePatterns []*regexp.Regexp
+
+// lineTags 可识别的线路标签, ASCII标签按单词匹配, 结果使用此处的写法
+var lineTags = []string{"IPLC", "IEPL", "BGP", "CN2", "GIA", "CMI", "AIA", "专线", "中转", "直连", "原生"}
+
+// storageUnits 与地区代码相同或相近的流量单位, 前面是数字时不作为地区
+var storageUnits = map[string]bool{"KB": true, "MB": true, "GB": true, "TB": true}
+
+var (
+	tagPatterns = map[string]*regexp.Regexp{}
+
+	// 2x / 1.5倍 / x2 / ×0.5 / 倍率:2
+	multiplierPattern = regexp.MustCompile(`(?i)(?:倍率\s*[:：]?\s*(\d+(?:\.\d+)?))|(?:(?:^|[^a-z0-9])[x×]\s*(\d+(?:\.\d+)?))|(?:(\d+(?:\.\d+)?)\s*(?:[x×](?:[^a-z0-9]|$)|倍))`)
+	isoPattern        = regexp.MustCompile(`(?:^|[^A-Za-z0-9])([A-Z]{2})(?:[^A-Za-z]|$)`)
+	sequencePattern   = regexp.MustCompile(`\d+`)
+
+	// 100GB / 100.5 GB 之类的流量信息
+	trafficPattern = regexp.MustCompile(`(?i)\d+(?:\.\d+)?\s*[KMGT]i?B\b`)
+)
+
+func init() {
+	for _, r := range regions {
+		regionByCode[r.code] = r
+		// 三位代码区分大小写, 避免匹配到普通单词
+		var words, codes []string
+		for _, word := range r.english {
+			if len(word) == 3 && strings.ToUpper(word) == word {
+				codes = append(codes, word)
+			} else {
+				words = append(words, regexp.QuoteMeta(word))
+			}
+		}
+		englishPatterns = append(englishPatterns, wordPattern("(?i)", words))
+		codePatterns = append(codePatterns, wordPattern("", codes))
+	}
+	for _, tag := range lineTags {
+		if tag[0] < utf8.RuneSelf {
+			tagPatterns[tag] = regexp.MustCompile(`(?i)(?:^|[^A-Za-z0-9])(` + tag + `)(?:[^A-Za-z0-9]|$)`)
+		} else {
+			tagPatterns[tag] = regexp.MustCompile(regexp.QuoteMeta(tag))
+		}
+	}
+}
+
+// wordPattern 按单词匹配任一候选, 没有候选时返回空
+func wordPattern(flags string, words []string) *regexp.Regexp {
+	if len(words) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(flags + `(?:^|[^A-Za-z])(` + strings.Join(words, "|") + `)(?:[^A-Za-z]|$)`)
+}
+
+// Parse 解析节点名称, 如 "🇭🇰 香港 IPLC 01 [1.5x]";
+// 地区优先使用旗帜, 其次是中文名称, 英文名称及两位地区代码, 同类匹配取最靠前的
+func Parse(name string) (meta *Meta) {
+	meta = &Meta{Multiplier: 1}
+	meta.Region = parseRegion(name)
+	if r, ok := regionByCode[meta.Region]; ok {
+		meta.RegionName = r.name
+	}
+
+	// 倍率及标签从名称中去掉, 避免被当作序号
+	rest := name
+	if m := multiplierPattern.FindStringSubmatchIndex(name); m != nil {
+		for i := 2; i < len(m); i += 2 {
+			if m[i] >= 0 {
+				meta.Multiplier, _ = strconv.ParseFloat(name[m[i]:m[i+1]], 64)
+				break
+			}
+		}
+		rest = name[:m[0]] + " " + name[m[1]:]
+	}
+	type found struct {
+		tag string
+		pos int
+	}
+	var tags []found
+	for _, tag := range lineTags {
+		if loc := tagPatterns[tag].FindStringIndex(rest); loc != nil {
+			tags = append(tags, found{tag, loc[0]})
+		}
+	}
+	for _, t := range tags {
+		rest = tagPatterns[t.tag].ReplaceAllString(rest, " ")
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].pos < tags[j].pos
+	})
+	for _, t := range tags {
+		meta.Tags = append(meta.Tags, t.tag)
+	}
+
+	// 序号取最后一个不超过4位的数字, 流量信息中的数字不是序号
+	rest = trafficPattern.ReplaceAllString(rest, " ")
+	for _, s := range sequencePattern.FindAllString(rest, -1) {
+		if len(s) <= 4 {
+			meta.Sequence, _ = strconv.Atoi(s)
+		}
+	}
+	return
+}
+
+// parseRegion 识别地区代码; 中国只在没有其他地区时使用, 避免 "中国香港" 被识别为中国
+func parseRegion(name string) string {
+	if code := flagRegion(name); code != "" {
+		return code
+	}
+
+	// 同一位置有多个匹配时取最长的, 如 印度尼西亚 与 印度
+	best, bestPos, bestLen := "", -1, 0
+	fallback := ""
+	consider := func(code string, pos, length int) {
+		if code == "CN" {
+			fallback = code
+			return
+		}
+		if bestPos < 0 || pos < bestPos || (pos == bestPos && length > bestLen) {
+			best, bestPos, bestLen = code, pos, length
+		}
+	}
+	for _, r := range regions {
+		for _, word := range r.chinese {
+			if pos := strings.Index(name, word); pos >= 0 {
+				consider(r.code, pos, len(word))
+			}
+		}
+	}
+	if best != "" {
+		return best
+	}
+
+	for i, r := range regions {
+		for _, pattern := range []*regexp.Regexp{englishPatterns[i], codePatterns[i]} {
+			if pattern == nil {
+				continue
+			}
+			if loc := pattern.FindStringSubmatchIndex(name); loc != nil {
+				consider(r.code, loc[2], loc[3]-loc[2])
+			}
+		}
+	}
+	for _, m := range isoPattern.FindAllStringSubmatchIndex(name, -1) {
+		code := name[m[2]:m[3]]
+		// 100.5 GB 之类的流量信息不是地区
+		before := strings.TrimRight(name[:m[2]], " ")
+		if storageUnits[code] && before != "" && before[len(before)-1] >= '0' && before[len(before)-1] <= '9' {
+			continue
+		}
+		if code == "UK" {
+			code = "GB"
+		}
+		if _, ok := regionByCode[code]; ok {
+			consider(code, m[2], 2)
+		}
+	}
+	if best == "" {
+		best = fallback
+	}
+	return best
+}
+
+// flagRegion 从旗帜emoji(两个区域指示符)中识别地区代码
+func flagRegion(name string) string {
+	var prev rune
+	for _, c := range name {
+		if c >= 0x1F1E6 && c <= 0x1F1FF {
+			if prev != 0 {
+				code := string([]rune{'A' + prev - 0x1F1E6, 'A' + c - 0x1F1E6})
+				if code == "UK" {
+					code = "GB"
+				}
+				return code
+			}
+			prev = c
+			continue
+		}
+		prev = 0
+	}
+	return ""
+}
+
+// ParseNode 解析路由器节点的名称
+func ParseNode(pn *openwrt.ProxyNodeInfo) *Meta {
+	return Parse(pn.Name)
+}
+
+// GroupByRegion 按地区代码分组, 无法识别地区的节点分组为空字符串
+func GroupByRegion(nodes []*openwrt.ProxyNodeInfo) (groups map[string][]*openwrt.ProxyNodeInfo) {
+	groups = make(map[string][]*openwrt.ProxyNodeInfo)
+	for _, pn := range nodes {
+		code := ParseNode(pn).Region
+		groups[code] = append(groups[code], pn)
+	}
+	return
+}
+
+// RegionName 地区代码对应的中文名称, 未知代码返回代码本身
+func RegionName(code string) string {
+	if r, ok := regionByCode[code]; ok {
+		return r.name
+	}
+	return code
+}
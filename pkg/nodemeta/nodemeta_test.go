@@ -0,0 +1,44 @@
+package nodemeta
+
+import (
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		want Meta
+	}{
+		{"🇭🇰 香港 IPLC 01 [1.5x]", Meta{Region: "HK", RegionName: "香港", Tags: []string{"IPLC"}, Multiplier: 1.5, Sequence: 1}},
+		{"🇺🇸 United States 03", Meta{Region: "US", RegionName: "美国", Multiplier: 1, Sequence: 3}},
+		{"日本 IEPL专线 x2 02", Meta{Region: "JP", RegionName: "日本", Tags: []string{"IEPL", "专线"}, Multiplier: 2, Sequence: 2}},
+		{"中国香港 BGP 中转 0.5倍 #12", Meta{Region: "HK", RegionName: "香港", Tags: []string{"BGP", "中转"}, Multiplier: 0.5, Sequence: 12}},
+		{"SG-CN2 GIA-05", Meta{Region: "SG", RegionName: "新加坡", Tags: []string{"CN2", "GIA"}, Multiplier: 1, Sequence: 5}},
+		{"Tokyo premium 倍率:3", Meta{Region: "JP", RegionName: "日本", Multiplier: 3}},
+		{"印度尼西亚 01", Meta{Region: "ID", RegionName: "印度尼西亚", Multiplier: 1, Sequence: 1}},
+		{"UK London 1x", Meta{Region: "GB", RegionName: "英国", Multiplier: 1}},
+		{"上海回国 iplc", Meta{Region: "CN", RegionName: "中国", Tags: []string{"IPLC"}, Multiplier: 1}},
+		{"剩余流量: 100GB", Meta{Multiplier: 1}},
+		{"剩余流量：100.5 GB", Meta{Multiplier: 1}},
+		{"HK01 IEPL", Meta{Region: "HK", RegionName: "香港", Tags: []string{"IEPL"}, Multiplier: 1, Sequence: 1}},
+		{"Netflix can watch", Meta{Multiplier: 1}},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.name); !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.name, *got, tt.want)
+		}
+	}
+}
+
+func TestGroupByRegion(t *testing.T) {
+	nodes := []*openwrt.ProxyNodeInfo{{Name: "🇭🇰 香港01"}, {Name: "日本01"}, {Name: "HK 02"}, {Name: "官网"}}
+	groups := GroupByRegion(nodes)
+	if len(groups["HK"]) != 2 || len(groups["JP"]) != 1 || len(groups[""]) != 1 {
+		t.Errorf("got groups %v", groups)
+	}
+	if RegionName("HK") != "香港" || RegionName("XX") != "XX" {
+		t.Error("unexpected region name")
+	}
+}
@@ -0,0 +1,330 @@
+package nodequery
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenKind 词法单元类型
+type tokenKind int
+
+const (
+	tokEOF    tokenKind = iota
+	tokWord             // 字段名, 关键字或未加引号的值
+	tokString           // 引号中的字符串
+	tokOp               // 比较运算符
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // 在表达式中的字节偏移
+}
+
+// SyntaxError 查询表达式语法错误
+type SyntaxError struct {
+	Pos int    // 出错位置, 字节偏移
+	Msg string // 错误信息
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("node query: %s at position %d", e.Msg, e.Pos)
+}
+
+// wordBreaks 结束未加引号的单词的字符
+const wordBreaks = `()=,!<>~"'`
+
+// lex 切分查询表达式
+func lex(src string) (tokens []token, err error) {
+	for i := 0; i < len(src); {
+		c, size := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case unicode.IsSpace(c):
+			i += size
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+		case c == '"' || c == '\'':
+			var text strings.Builder
+			j := i + 1
+			for ; j < len(src) && rune(src[j]) != c; j++ {
+				if src[j] == '\\' && c == '"' && j+1 < len(src) {
+					j++
+				}
+				text.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, &SyntaxError{i, "unterminated string"}
+			}
+			tokens = append(tokens, token{tokString, text.String(), i})
+			i = j + 1
+		case strings.ContainsRune("=!<>~", c):
+			op := src[i : i+1]
+			if i+1 < len(src) && op != "~" && (src[i+1] == '=' || op == "!" && src[i+1] == '~') {
+				op = src[i : i+2]
+			}
+			if op == "!" {
+				return nil, &SyntaxError{i, `unknown operator "!"`}
+			}
+			text := op
+			if op == "==" {
+				text = "="
+			}
+			tokens = append(tokens, token{tokOp, text, i})
+			i += len(op)
+		default:
+			j := i
+			for j < len(src) {
+				r, n := utf8.DecodeRuneInString(src[j:])
+				if unicode.IsSpace(r) || strings.ContainsRune(wordBreaks, r) {
+					break
+				}
+				j += n
+			}
+			tokens = append(tokens, token{tokWord, src[i:j], i})
+			i = j
+		}
+	}
+	tokens = append(tokens, token{tokEOF, "", len(src)})
+	return
+}
+
+// parser 递归下降解析器, 语法见包文档
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() (tok token) {
+	tok = p.tokens[p.i]
+	if tok.kind != tokEOF {
+		p.i++
+	}
+	return
+}
+
+// keyword 下一个单词是否为关键字, 不区分大小写
+func (p *parser) keyword(kw string) bool {
+	tok := p.peek()
+	return tok.kind == tokWord && strings.EqualFold(tok.text, kw)
+}
+
+// expect 读取指定类型的词法单元
+func (p *parser) expect(kind tokenKind, what string) (tok token, err error) {
+	if tok = p.next(); tok.kind != kind {
+		err = p.unexpected(tok, what)
+	}
+	return
+}
+
+func (p *parser) unexpected(tok token, want string) error {
+	if tok.kind == tokEOF {
+		return &SyntaxError{tok.pos, "unexpected end of query, want " + want}
+	}
+	return &SyntaxError{tok.pos, fmt.Sprintf("unexpected %q, want %s", tok.text, want)}
+}
+
+// parseQuery query := [or] ["order" "by" key ["asc"|"desc"] {"," ...}] ["limit" N]
+func (p *parser) parseQuery(q *Query) (err error) {
+	if !p.keyword("order") && !p.keyword("limit") && p.peek().kind != tokEOF {
+		if q.expr, err = p.parseOr(); err != nil {
+			return
+		}
+	}
+	if p.keyword("order") {
+		p.next()
+		if !p.keyword("by") {
+			return p.unexpected(p.next(), `"by"`)
+		}
+		p.next()
+		for {
+			var tok token
+			if tok, err = p.expect(tokWord, "field"); err != nil {
+				return
+			}
+			key := sortKey{field: fields[strings.ToLower(tok.text)]}
+			if key.field == nil || key.field.kind == kindBool {
+				return &SyntaxError{tok.pos, fmt.Sprintf("cannot order by %q", tok.text)}
+			}
+			if p.keyword("asc") {
+				p.next()
+			} else if p.keyword("desc") {
+				p.next()
+				key.desc = true
+			}
+			q.order = append(q.order, key)
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if p.keyword("limit") {
+		p.next()
+		var tok token
+		if tok, err = p.expect(tokWord, "limit"); err != nil {
+			return
+		}
+		if q.limit, err = strconv.Atoi(tok.text); err != nil || q.limit <= 0 {
+			return &SyntaxError{tok.pos, fmt.Sprintf("invalid limit %q", tok.text)}
+		}
+	}
+	if tok := p.next(); tok.kind != tokEOF {
+		return p.unexpected(tok, `"and", "or", "order by", "limit" or end of query`)
+	}
+	return
+}
+
+// parseOr or := and {"or" and}
+func (p *parser) parseOr() (n node, err error) {
+	if n, err = p.parseAnd(); err != nil {
+		return
+	}
+	for p.keyword("or") {
+		p.next()
+		var right node
+		if right, err = p.parseAnd(); err != nil {
+			return
+		}
+		n = &orNode{n, right}
+	}
+	return
+}
+
+// parseAnd and := unary {"and" unary}
+func (p *parser) parseAnd() (n node, err error) {
+	if n, err = p.parseUnary(); err != nil {
+		return
+	}
+	for p.keyword("and") {
+		p.next()
+		var right node
+		if right, err = p.parseUnary(); err != nil {
+			return
+		}
+		n = &andNode{n, right}
+	}
+	return
+}
+
+// parseUnary unary := "not" unary | "(" or ")" | cond
+func (p *parser) parseUnary() (n node, err error) {
+	switch {
+	case p.keyword("not"):
+		p.next()
+		if n, err = p.parseUnary(); err != nil {
+			return
+		}
+		return &notNode{n}, nil
+	case p.peek().kind == tokLParen:
+		p.next()
+		if n, err = p.parseOr(); err != nil {
+			return
+		}
+		_, err = p.expect(tokRParen, `")"`)
+		return
+	}
+	return p.parseCond()
+}
+
+// parseCond cond := field op value | field ["not"] "in" "(" value {"," value} ")" | boolField
+func (p *parser) parseCond() (n node, err error) {
+	tok, err := p.expect(tokWord, "field")
+	if err != nil {
+		return
+	}
+	f := fields[strings.ToLower(tok.text)]
+	if f == nil {
+		return nil, &SyntaxError{tok.pos, fmt.Sprintf("unknown field %q", tok.text)}
+	}
+	if f.kind == kindBool {
+		return &boolNode{f}, nil
+	}
+
+	cond := &condNode{field: f}
+	opTok := p.peek()
+	switch {
+	case p.keyword("in"):
+		p.next()
+		cond.op = "in"
+	case p.keyword("not"):
+		p.next()
+		if !p.keyword("in") {
+			return nil, p.unexpected(p.next(), `"in"`)
+		}
+		p.next()
+		cond.op = "not in"
+	case opTok.kind == tokOp:
+		p.next()
+		cond.op = opTok.text
+	default:
+		return nil, p.unexpected(p.next(), "operator")
+	}
+	if f.kind == kindNumber && (cond.op == "~" || cond.op == "!~") ||
+		f.kind == kindString && strings.ContainsAny(cond.op, "<>") {
+		return nil, &SyntaxError{opTok.pos, fmt.Sprintf("operator %q not supported by field %q", cond.op, f.name)}
+	}
+
+	if cond.op == "in" || cond.op == "not in" {
+		if _, err = p.expect(tokLParen, `"("`); err != nil {
+			return
+		}
+		for {
+			if err = p.parseValue(cond); err != nil {
+				return
+			}
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+		if _, err = p.expect(tokRParen, `")"`); err != nil {
+			return
+		}
+		return cond, nil
+	}
+	if err = p.parseValue(cond); err != nil {
+		return
+	}
+	return cond, nil
+}
+
+// parseValue 读取条件的一个值, 数值字段解析为数字, 正则运算符编译正则表达式
+func (p *parser) parseValue(cond *condNode) (err error) {
+	tok := p.next()
+	if tok.kind != tokWord && tok.kind != tokString {
+		return p.unexpected(tok, "value")
+	}
+	switch {
+	case cond.field.kind == kindNumber:
+		var num float64
+		if num, err = strconv.ParseFloat(tok.text, 64); err != nil {
+			return &SyntaxError{tok.pos, fmt.Sprintf("field %q wants a number, got %q", cond.field.name, tok.text)}
+		}
+		cond.nums = append(cond.nums, num)
+	case cond.op == "~" || cond.op == "!~":
+		if cond.re, err = regexp.Compile(tok.text); err != nil {
+			return &SyntaxError{tok.pos, err.Error()}
+		}
+	default:
+		cond.strs = append(cond.strs, tok.text)
+	}
+	return
+}
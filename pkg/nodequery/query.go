@@ -0,0 +1,352 @@
+// Package nodequery 提供代理节点的筛选及排序表达式, 如
+//
+//	region in (HK, JP) and latency < 200 and not name ~ "test" order by latency, multiplier limit 3
+//
+// 语法:
+//
+//	query := [expr] ["order" "by" field ["asc"|"desc"] {"," field ["asc"|"desc"]}] ["limit" N]
+//	expr  := expr "or" expr | expr "and" expr | "not" expr | "(" expr ")" | cond
+//	cond  := field op value | field ["not"] "in" "(" value {"," value} ")" | offline | online
+//	op    := "=" | "!=" | "<" | "<=" | ">" | ">=" | "~" | "!~"
+//
+// 字段:
+//
+//	name, id, host      字符串
+//	region              地区代码或中文名称, 如 HK 或 香港, 由 nodemeta 从名称解析
+//	tag                 线路标签, 如 IPLC; 节点有任一标签满足条件即匹配
+//	latency             延迟毫秒数, 离线节点不满足任何延迟条件, 排序时总在最后
+//	port, multiplier, sequence  数字
+//	offline, online     节点状态
+//
+// 关键字不区分大小写; 字符串比较不区分大小写, ~ 为正则匹配(区分大小写, 可用 (?i) 忽略);
+// 包含空格或运算符的值需要加引号. 没有 order by 时保持节点的原始顺序.
+package nodequery
+
+import (
+	"github.com/huge-kumo/net-utils/pkg/nodemeta"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Query 解析后的节点查询, 零值匹配所有节点; 实现了 flag.Value, 可直接用作命令行参数
+type Query struct {
+	text  string
+	expr  node
+	order []sortKey
+	limit int
+}
+
+// Parse 解析查询表达式, 语法错误返回 *SyntaxError
+func Parse(text string) (q *Query, err error) {
+	tokens, err := lex(text)
+	if err != nil {
+		return
+	}
+	q = &Query{text: strings.TrimSpace(text)}
+	p := &parser{tokens: tokens}
+	if err = p.parseQuery(q); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// MustParse 解析查询表达式, 出错时panic, 用于固定的表达式
+func MustParse(text string) *Query {
+	q, err := Parse(text)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// String 查询表达式原文
+func (q *Query) String() string {
+	if q == nil {
+		return ""
+	}
+	return q.text
+}
+
+// Set 解析并替换为新的查询表达式, 用于 flag.Var
+func (q *Query) Set(text string) (err error) {
+	parsed, err := Parse(text)
+	if err != nil {
+		return
+	}
+	*q = *parsed
+	return
+}
+
+// Match 节点是否满足筛选条件, 不考虑排序和数量限制; 可用作 failover.Config.Filter
+func (q *Query) Match(pn *openwrt.ProxyNodeInfo) bool {
+	return q.expr == nil || q.expr.match(&env{pn: pn})
+}
+
+// Filter 返回满足筛选条件的节点, 保持原始顺序
+func (q *Query) Filter(nodes []*openwrt.ProxyNodeInfo) (matched []*openwrt.ProxyNodeInfo) {
+	for _, pn := range nodes {
+		if q.Match(pn) {
+			matched = append(matched, pn)
+		}
+	}
+	return
+}
+
+// Select 筛选节点后按 order by 排序并截取 limit 个, 不修改nodes
+func (q *Query) Select(nodes []*openwrt.ProxyNodeInfo) (selected []*openwrt.ProxyNodeInfo) {
+	var envs []*env
+	for _, pn := range nodes {
+		e := &env{pn: pn}
+		if q.expr == nil || q.expr.match(e) {
+			envs = append(envs, e)
+		}
+	}
+	if len(q.order) != 0 {
+		sort.SliceStable(envs, func(i, j int) bool {
+			for _, key := range q.order {
+				if c := key.compare(envs[i], envs[j]); c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+	if q.limit > 0 && len(envs) > q.limit {
+		envs = envs[:q.limit]
+	}
+	for _, e := range envs {
+		selected = append(selected, e.pn)
+	}
+	return
+}
+
+// First 返回 Select 结果中的第一个节点, 没有时为空
+func (q *Query) First(nodes []*openwrt.ProxyNodeInfo) *openwrt.ProxyNodeInfo {
+	if selected := q.Select(nodes); len(selected) != 0 {
+		return selected[0]
+	}
+	return nil
+}
+
+// Fields 查询条件及排序引用的字段名称, 按首次出现的顺序排列且不重复
+func (q *Query) Fields() (names []string) {
+	seen := map[string]bool{}
+	visit := func(f *field) {
+		if !seen[f.name] {
+			seen[f.name] = true
+			names = append(names, f.name)
+		}
+	}
+	if q.expr != nil {
+		q.expr.walk(visit)
+	}
+	for _, key := range q.order {
+		visit(key.field)
+	}
+	return
+}
+
+// env 求值时的节点, 元数据在第一次使用时解析
+type env struct {
+	pn   *openwrt.ProxyNodeInfo
+	meta *nodemeta.Meta
+}
+
+func (e *env) Meta() *nodemeta.Meta {
+	if e.meta == nil {
+		e.meta = nodemeta.ParseNode(e.pn)
+	}
+	return e.meta
+}
+
+// fieldKind 字段类型
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindNumber
+	kindBool
+)
+
+// field 可查询的字段
+type field struct {
+	name string
+	kind fieldKind
+	strs func(e *env) []string        // 字符串字段的取值, 有多个时任一满足即匹配
+	num  func(e *env) (float64, bool) // 数字字段的取值, false表示未知
+	bool func(e *env) bool            // 布尔字段的取值
+}
+
+// fields 按小写名称索引的字段
+var fields = map[string]*field{}
+
+func init() {
+	for _, f := range []*field{
+		{name: "name", kind: kindString, strs: func(e *env) []string { return []string{e.pn.Name} }},
+		{name: "id", kind: kindString, strs: func(e *env) []string { return []string{e.pn.Id} }},
+		{name: "host", kind: kindString, strs: func(e *env) []string { return []string{e.pn.Host} }},
+		{name: "region", kind: kindString, strs: func(e *env) []string {
+			meta := e.Meta()
+			if meta.Region == "" {
+				return []string{""}
+			}
+			return []string{meta.Region, meta.RegionName}
+		}},
+		{name: "tag", kind: kindString, strs: func(e *env) []string { return e.Meta().Tags }},
+		{name: "latency", kind: kindNumber, num: func(e *env) (float64, bool) { return float64(e.pn.Latency), !e.pn.Offline }},
+		{name: "port", kind: kindNumber, num: func(e *env) (float64, bool) {
+			port, err := strconv.Atoi(e.pn.Port)
+			return float64(port), err == nil
+		}},
+		{name: "multiplier", kind: kindNumber, num: func(e *env) (float64, bool) { return e.Meta().Multiplier, true }},
+		{name: "sequence", kind: kindNumber, num: func(e *env) (float64, bool) { return float64(e.Meta().Sequence), true }},
+		{name: "offline", kind: kindBool, bool: func(e *env) bool { return e.pn.Offline }},
+		{name: "online", kind: kindBool, bool: func(e *env) bool { return !e.pn.Offline }},
+	} {
+		fields[f.name] = f
+	}
+}
+
+// node 表达式节点
+type node interface {
+	match(e *env) bool
+	walk(visit func(f *field))
+}
+
+type andNode struct{ left, right node }
+
+func (n *andNode) match(e *env) bool { return n.left.match(e) && n.right.match(e) }
+
+func (n *andNode) walk(visit func(f *field)) { n.left.walk(visit); n.right.walk(visit) }
+
+type orNode struct{ left, right node }
+
+func (n *orNode) match(e *env) bool { return n.left.match(e) || n.right.match(e) }
+
+func (n *orNode) walk(visit func(f *field)) { n.left.walk(visit); n.right.walk(visit) }
+
+type notNode struct{ expr node }
+
+func (n *notNode) match(e *env) bool { return !n.expr.match(e) }
+
+func (n *notNode) walk(visit func(f *field)) { n.expr.walk(visit) }
+
+type boolNode struct{ field *field }
+
+func (n *boolNode) match(e *env) bool { return n.field.bool(e) }
+
+func (n *boolNode) walk(visit func(f *field)) { visit(n.field) }
+
+// condNode 字段比较条件
+type condNode struct {
+	field *field
+	op    string
+	strs  []string       // 字符串字段的比较值
+	nums  []float64      // 数字字段的比较值
+	re    *regexp.Regexp // ~ 及 !~ 的正则表达式
+}
+
+func (n *condNode) match(e *env) bool {
+	if n.field.kind == kindNumber {
+		val, ok := n.field.num(e)
+		if !ok {
+			return false
+		}
+		switch n.op {
+		case "=", "in":
+			return containsNum(n.nums, val)
+		case "!=", "not in":
+			return !containsNum(n.nums, val)
+		case "<":
+			return val < n.nums[0]
+		case "<=":
+			return val <= n.nums[0]
+		case ">":
+			return val > n.nums[0]
+		case ">=":
+			return val >= n.nums[0]
+		}
+		return false
+	}
+
+	// 字符串字段的否定条件要求所有取值都不满足
+	matched := false
+	for _, val := range n.field.strs(e) {
+		switch n.op {
+		case "=", "!=", "in", "not in":
+			matched = matched || containsFold(n.strs, val)
+		case "~", "!~":
+			matched = matched || n.re.MatchString(val)
+		}
+	}
+	switch n.op {
+	case "!=", "not in", "!~":
+		return !matched
+	}
+	return matched
+}
+
+func (n *condNode) walk(visit func(f *field)) { visit(n.field) }
+
+func containsNum(nums []float64, val float64) bool {
+	for _, num := range nums {
+		if num == val {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(strs []string, val string) bool {
+	for _, s := range strs {
+		if strings.EqualFold(s, val) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortKey 排序字段
+type sortKey struct {
+	field *field
+	desc  bool
+}
+
+// compare 比较两个节点, 取值未知的节点无论升降序都排在最后
+func (k sortKey) compare(a, b *env) (c int) {
+	if k.field.kind == kindNumber {
+		x, xOK := k.field.num(a)
+		y, yOK := k.field.num(b)
+		switch {
+		case !xOK || !yOK:
+			if xOK != yOK {
+				if xOK {
+					return -1
+				}
+				return 1
+			}
+			return 0
+		case x < y:
+			c = -1
+		case x > y:
+			c = 1
+		}
+	} else {
+		c = strings.Compare(sortString(k.field.strs(a)), sortString(k.field.strs(b)))
+	}
+	if k.desc {
+		c = -c
+	}
+	return
+}
+
+// sortString 字符串字段用于排序的值, 取第一个值的小写
+func sortString(vals []string) string {
+	if len(vals) == 0 {
+		return ""
+	}
+	return strings.ToLower(vals[0])
+}
@@ -0,0 +1,124 @@
+package nodequery
+
+import (
+	"errors"
+	"flag"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"reflect"
+	"testing"
+)
+
+var testNodes = []*openwrt.ProxyNodeInfo{
+	{Id: "a", Name: "🇭🇰 香港 IPLC 01 [2x]", Port: "443", Latency: 80},
+	{Id: "b", Name: "🇭🇰 香港 02", Port: "8443", Latency: 60},
+	{Id: "c", Name: "日本 BGP 01 0.5x", Port: "443", Latency: 150},
+	{Id: "d", Name: "日本 test 02", Port: "443", Latency: 40},
+	{Id: "e", Name: "美国 01", Port: "443", Latency: 190},
+	{Id: "f", Name: "香港 IEPL 03", Port: "443", Offline: true},
+}
+
+func ids(nodes []*openwrt.ProxyNodeInfo) (result []string) {
+	for _, pn := range nodes {
+		result = append(result, pn.Id)
+	}
+	return
+}
+
+func TestQuery_Select(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{``, []string{"a", "b", "c", "d", "e", "f"}},
+		{`region in (HK, JP) and latency < 200 and not name ~ "test" order by latency, multiplier`, []string{"b", "a", "c"}},
+		{`region = 香港 order by latency desc`, []string{"a", "b", "f"}},
+		{`tag in (iplc, IEPL) or multiplier < 1`, []string{"a", "c", "f"}},
+		{`tag != IPLC and region not in (JP, US)`, []string{"b", "f"}},
+		{`offline or port != 443`, []string{"b", "f"}},
+		{`online AND (latency <= 60 OR latency >= 190) ORDER BY name`, []string{"d", "e", "b"}},
+		{`name ~ '(?i)^日本' order by sequence desc, id`, []string{"d", "c"}},
+		{`order by multiplier desc, latency limit 2`, []string{"a", "d"}},
+		{`latency = 80 or id == d`, []string{"a", "d"}},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.query)
+		if err != nil {
+			t.Fatalf("Parse(%q) error %v", tt.query, err)
+		}
+		if got := ids(q.Select(testNodes)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q selected %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestQuery_First(t *testing.T) {
+	q := MustParse("online order by latency")
+	if pn := q.First(testNodes); pn == nil || pn.Id != "d" {
+		t.Fatalf("first %v", pn)
+	}
+	if pn := MustParse("region = SG").First(testNodes); pn != nil {
+		t.Fatalf("unexpected %v", pn)
+	}
+	if got := ids(MustParse("region = HK order by latency").Filter(testNodes)); !reflect.DeepEqual(got, []string{"a", "b", "f"}) {
+		t.Fatalf("filter must keep input order, got %v", got)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+	}{
+		{`speed > 1`, 0},
+		{`latency ~ 1`, 8},
+		{`name < a`, 5},
+		{`latency < fast`, 10},
+		{`region in (HK, JP`, 17},
+		{`name = "abc`, 7},
+		{`name ! a`, 5},
+		{`online order latency`, 13},
+		{`online limit 0`, 13},
+		{`order by online`, 9},
+		{`name = a b`, 9},
+		{`name ~ "("`, 7},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.query)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Parse(%q) error %v, want syntax error", tt.query, err)
+			continue
+		}
+		if syntaxErr.Pos != tt.pos {
+			t.Errorf("Parse(%q) error %v, want position %d", tt.query, err, tt.pos)
+		}
+	}
+}
+
+func TestQuery_Flag(t *testing.T) {
+	var q Query
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&q, "query", "node query")
+	if err := fs.Parse([]string{"-query", "region = JP order by latency"}); err != nil {
+		t.Fatal(err)
+	}
+	if q.String() != "region = JP order by latency" {
+		t.Fatalf("query %q", q.String())
+	}
+	if got := ids(q.Select(testNodes)); !reflect.DeepEqual(got, []string{"d", "c"}) {
+		t.Fatalf("selected %v", got)
+	}
+	if !(&Query{}).Match(testNodes[0]) {
+		t.Fatal("zero query must match all nodes")
+	}
+}
+
+func TestQuery_Fields(t *testing.T) {
+	q := MustParse("(region = HK or not online) and region != JP order by latency, name")
+	if got := q.Fields(); !reflect.DeepEqual(got, []string{"region", "online", "latency", "name"}) {
+		t.Fatalf("fields %v", got)
+	}
+	if got := (&Query{}).Fields(); len(got) != 0 {
+		t.Fatalf("zero query fields %v", got)
+	}
+}
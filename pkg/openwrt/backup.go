@@ -0,0 +1,125 @@
+package openwrt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	CGIBackupPath    = "/cgi-bin/cgi-backup" // cgi-io下载配置备份的路径
+	CGIUploadPath    = "/cgi-bin/cgi-upload" // cgi-io上传文件的路径
+	restoreArchive   = "/tmp/backup.tar.gz"  // 恢复时备份文件在路由器上的保存位置
+	sysupgradeBinary = "/sbin/sysupgrade"
+)
+
+// Backup 下载sysupgrade配置备份(tar.gz)并写入w, 返回写入的字节数
+func (r *Router) Backup(w io.Writer) (n int64, err error) {
+	return r.BackupContext(context.Background(), w)
+}
+
+// BackupContext 通过cgi-io下载sysupgrade配置备份(tar.gz)并写入w, 返回写入的字节数
+func (r *Router) BackupContext(ctx context.Context, w io.Writer) (n int64, err error) {
+	rsp, err := r.cgiIORequest(ctx, CGIBackupPath, func(session string) (io.Reader, string, error) {
+		form := url.Values{}
+		form.Set("sessionid", session)
+		return bytes.NewBufferString(form.Encode()), "application/x-www-form-urlencoded", nil
+	})
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = rsp.Body.Close()
+	}()
+	return io.Copy(w, rsp.Body)
+}
+
+// Restore 上传配置备份并恢复, 随后重启路由器并等待恢复, 返回从触发重启到恢复的时长
+func (r *Router) Restore(archive io.Reader, opts *HealthOptions) (outage time.Duration, err error) {
+	return r.RestoreContext(context.Background(), archive, opts)
+}
+
+// RestoreContext 上传配置备份并执行sysupgrade --restore-backup, 随后重启路由器并等待恢复,
+// 返回从触发重启到恢复的时长
+func (r *Router) RestoreContext(ctx context.Context, archive io.Reader, opts *HealthOptions) (outage time.Duration, err error) {
+	data, err := ioutil.ReadAll(archive)
+	if err != nil {
+		return
+	}
+	rsp, err := r.cgiIORequest(ctx, CGIUploadPath, func(session string) (io.Reader, string, error) {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		for _, field := range [][2]string{{"sessionid", session}, {"filename", restoreArchive}, {"filemode", "0600"}} {
+			if err := mw.WriteField(field[0], field[1]); err != nil {
+				return nil, "", err
+			}
+		}
+		fw, err := mw.CreateFormFile("filedata", "backup.tar.gz")
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err = fw.Write(data); err != nil {
+			return nil, "", err
+		}
+		if err = mw.Close(); err != nil {
+			return nil, "", err
+		}
+		return body, mw.FormDataContentType(), nil
+	})
+	if err != nil {
+		return
+	}
+	_ = rsp.Body.Close()
+
+	res, err := r.fileExec(ctx, sysupgradeBinary, "--restore-backup", restoreArchive)
+	if err != nil {
+		return
+	}
+	if res.Code != 0 {
+		err = fmt.Errorf("restore backup exited with code %d: %s", res.Code, res.Stderr)
+		return
+	}
+	return r.RebootContext(ctx, opts)
+}
+
+// cgiIORequest 使用ubus会话请求cgi-io, 会话过期(403)时重新登录一次并重放请求;
+// body根据会话构建请求体及Content-Type, 调用方负责关闭响应
+func (r *Router) cgiIORequest(ctx context.Context, path string, body func(session string) (io.Reader, string, error)) (rsp *http.Response, err error) {
+	session := r.credential(&r.ubusSession)
+	if session == "" {
+		if session, err = r.renewCredential(ctx, &r.ubusSession, "", r.ubusLogin); err != nil {
+			return
+		}
+	}
+	for retried := false; ; retried = true {
+		var reader io.Reader
+		var contentType string
+		if reader, contentType, err = body(session); err != nil {
+			return
+		}
+		var req *http.Request
+		if req, err = http.NewRequestWithContext(ctx, http.MethodPost, r.url(path), reader); err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", contentType)
+		if rsp, err = r.client.Do(req); err != nil {
+			return
+		}
+		if rsp.StatusCode == http.StatusOK {
+			return
+		}
+		_ = rsp.Body.Close()
+		if rsp.StatusCode != http.StatusForbidden || retried {
+			return nil, fmt.Errorf("%s returned status %s", path, rsp.Status)
+		}
+		if session, err = r.renewCredential(ctx, &r.ubusSession, session, r.ubusLogin); err != nil {
+			return
+		}
+	}
+}
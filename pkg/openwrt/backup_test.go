@@ -0,0 +1,107 @@
+package openwrt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newBackupTestServer(t *testing.T, restoreCode int) (r *Router, uploaded *[]byte, rebooted *bool) {
+	var mu sync.Mutex
+	uploaded, rebooted = new([]byte), new(bool)
+	ubus := newUbusTestServer(t, func(object, method string, args map[string]interface{}) []interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		switch object + "." + method {
+		case "session.login":
+			return []interface{}{0, map[string]interface{}{"ubus_rpc_session": "test-session"}}
+		case "file.exec":
+			if args["command"] != "/sbin/sysupgrade" {
+				return []interface{}{6}
+			}
+			return []interface{}{0, map[string]interface{}{"code": restoreCode, "stderr": "invalid archive"}}
+		case "system.reboot":
+			*rebooted = true
+			return []interface{}{0}
+		case "system.board":
+			return []interface{}{0, map[string]interface{}{}}
+		case "system.info":
+			return []interface{}{0, map[string]interface{}{"uptime": 0, "root": map[string]interface{}{}}}
+		}
+		return []interface{}{3}
+	})
+	t.Cleanup(ubus.Close)
+
+	// cgi-io与ubus共用会话, 第一次请求使用过期会话返回403
+	expired := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == UbusPath {
+			ubus.Config.Handler.ServeHTTP(w, req)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch req.URL.Path {
+		case CGIBackupPath:
+			if expired || req.FormValue("sessionid") != "test-session" {
+				expired = false
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte("archive"))
+		case CGIUploadPath:
+			if req.FormValue("sessionid") != "test-session" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			file, _, err := req.FormFile("filedata")
+			if err != nil || req.FormValue("filename") != restoreArchive {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			*uploaded, _ = ioutil.ReadAll(file)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	r = NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+	r.setCredential(&r.ubusSession, "stale-session")
+	return
+}
+
+func TestRouter_Backup(t *testing.T) {
+	r, _, _ := newBackupTestServer(t, 0)
+	buf := &bytes.Buffer{}
+	n, err := r.Backup(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 7 || buf.String() != "archive" {
+		t.Errorf("got %d bytes %q", n, buf.String())
+	}
+}
+
+func TestRouter_Restore(t *testing.T) {
+	opts := &HealthOptions{Timeout: time.Second, Interval: 5 * time.Millisecond, Holdoff: 5 * time.Millisecond}
+	r, uploaded, rebooted := newBackupTestServer(t, 0)
+	if _, err := r.Restore(strings.NewReader("archive"), opts); err != nil {
+		t.Fatal(err)
+	}
+	if string(*uploaded) != "archive" || !*rebooted {
+		t.Errorf("got uploaded %q rebooted %v", *uploaded, *rebooted)
+	}
+
+	r, _, rebooted = newBackupTestServer(t, 1)
+	if _, err := r.Restore(strings.NewReader("archive"), opts); err == nil || !strings.Contains(err.Error(), "invalid archive") {
+		t.Errorf("got %v, want restore error", err)
+	}
+	if *rebooted {
+		t.Error("router rebooted after failed restore")
+	}
+}
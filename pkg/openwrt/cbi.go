@@ -0,0 +1,88 @@
+package openwrt
+
+import (
+	"encoding/json"
+	"github.com/PuerkitoBio/goquery"
+	"strings"
+)
+
+// cbiValues LuCI CBI表单中的配置值, 按 配置节 -> 选项 -> 值列表 组织
+type cbiValues map[string]map[string][]string
+
+// parseCBIForm 解析LuCI CBI页面中指定配置的表单值, 字段名称形如 cbid.<config>.<section>.<option>;
+// 复选框未选中时值为"0", 动态列表兼容多个同名输入框及LuCI 19以后的data-values写法
+func parseCBIForm(doc *goquery.Document, config string) (values cbiValues) {
+	values = cbiValues{}
+	prefix := "cbid." + config + "."
+	add := func(name string, items ...string) {
+		section, option := splitCBIName(strings.TrimPrefix(name, prefix))
+		if section == "" || option == "" {
+			return
+		}
+		if values[section] == nil {
+			values[section] = map[string][]string{}
+		}
+		values[section][option] = append(values[section][option], items...)
+	}
+
+	doc.Find("[name^='" + prefix + "']").Each(func(i int, selection *goquery.Selection) {
+		name, _ := selection.Attr("name")
+		switch goquery.NodeName(selection) {
+		case "select":
+			selection.Find("option[selected]").Each(func(i int, option *goquery.Selection) {
+				val, _ := option.Attr("value")
+				add(name, val)
+			})
+		case "textarea":
+			add(name, selection.Text())
+		case "input":
+			val, _ := selection.Attr("value")
+			switch typ, _ := selection.Attr("type"); typ {
+			case "checkbox", "radio":
+				if _, checked := selection.Attr("checked"); checked {
+					add(name, val)
+				} else if typ == "checkbox" {
+					add(name, "0")
+				}
+			default:
+				add(name, val)
+			}
+		}
+	})
+	doc.Find("[data-prefix^='" + prefix + "'][data-values]").Each(func(i int, selection *goquery.Selection) {
+		name, _ := selection.Attr("data-prefix")
+		raw, _ := selection.Attr("data-values")
+		var items []string
+		if err := json.Unmarshal([]byte(raw), &items); err == nil {
+			add(name, items...)
+		}
+	})
+	return
+}
+
+// splitCBIName 将 <section>.<option> 切分为配置节和选项名称
+func splitCBIName(name string) (section, option string) {
+	idx := strings.Index(name, ".")
+	if idx < 0 {
+		return
+	}
+	return name[:idx], name[idx+1:]
+}
+
+// first 获取选项的第一个值
+func (v cbiValues) first(section, option string) string {
+	if items := v[section][option]; len(items) != 0 {
+		return items[0]
+	}
+	return ""
+}
+
+// sectionWith 获取包含指定选项的第一个配置节名称
+func (v cbiValues) sectionWith(option string) string {
+	for section, options := range v {
+		if _, ok := options[option]; ok {
+			return section
+		}
+	}
+	return ""
+}
@@ -0,0 +1,213 @@
+package openwrt
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dhcpLeasesPath = "/tmp/dhcp.leases" // dnsmasq租约文件
+	arpTablePath   = "/proc/net/arp"    // IPv4邻居表
+)
+
+// Client 连接到路由器的设备, 汇总DHCP租约, 静态租约, ARP表及无线关联列表
+type Client struct {
+	MAC         string    // MAC地址, 小写冒号分隔
+	IP          string    // IPv4地址
+	Hostname    string    // 主机名称, 优先使用DHCP上报的名称
+	Interface   string    // 所在接口, 无线设备为无线接口名称
+	Wireless    bool      // 是否为无线设备
+	Signal      int       // 无线信号强度(dBm), 有线设备为0
+	Static      bool      // 是否配置了静态租约
+	LeaseExpiry time.Time // 租约到期时间, 无租约或永久租约为零值
+	FirstSeen   time.Time // 首次发现的时间
+	LastSeen    time.Time // 最近一次发现的时间
+}
+
+// ClientDiff 两次设备快照之间的变化
+type ClientDiff struct {
+	Joined []*Client // 新加入网络的设备
+	Left   []*Client // 离开网络的设备
+}
+
+// DiffClients 比较两次设备快照, 按MAC地址匹配; 仍在线的设备会沿用上一次快照的FirstSeen
+func DiffClients(prev, cur []*Client) (diff *ClientDiff) {
+	diff = &ClientDiff{}
+	before := make(map[string]*Client, len(prev))
+	for _, c := range prev {
+		before[c.MAC] = c
+	}
+	after := make(map[string]bool, len(cur))
+	for _, c := range cur {
+		after[c.MAC] = true
+		if old, ok := before[c.MAC]; ok {
+			if !old.FirstSeen.IsZero() && (c.FirstSeen.IsZero() || old.FirstSeen.Before(c.FirstSeen)) {
+				c.FirstSeen = old.FirstSeen
+			}
+			continue
+		}
+		diff.Joined = append(diff.Joined, c)
+	}
+	for _, c := range prev {
+		if !after[c.MAC] {
+			diff.Left = append(diff.Left, c)
+		}
+	}
+	return
+}
+
+// ListClients 列出连接到路由器的设备
+func (r *Router) ListClients() (clients []*Client, err error) {
+	return r.ListClientsContext(context.Background())
+}
+
+// ListClientsContext 列出连接到路由器的设备, 通过ubus读取租约文件, ARP表, dhcp配置及iwinfo;
+// 只有静态租约而不在线的设备不会出现在结果中
+func (r *Router) ListClientsContext(ctx context.Context) (clients []*Client, err error) {
+	now := time.Now()
+	byMAC := map[string]*Client{}
+	get := func(mac string) *Client {
+		mac = strings.ToLower(mac)
+		c, ok := byMAC[mac]
+		if !ok {
+			c = &Client{MAC: mac, FirstSeen: now, LastSeen: now}
+			byMAC[mac] = c
+		}
+		return c
+	}
+
+	// ARP表: IP address, HW type, Flags, HW address, Mask, Device
+	arp, err := r.fileRead(ctx, arpTablePath)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(arp, "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 6 || fields[2] == "0x0" || fields[3] == "00:00:00:00:00:00" {
+			continue
+		}
+		c := get(fields[3])
+		c.IP = fields[0]
+		c.Interface = fields[5]
+	}
+
+	// 无线关联列表
+	if err = r.mergeWirelessClients(ctx, get); err != nil {
+		return
+	}
+
+	// DHCP租约: expiry mac ip hostname clientid, 只保留仍在线的设备
+	leases, err := r.fileRead(ctx, dhcpLeasesPath)
+	if err != nil && !isUbusNotFound(err) {
+		return
+	}
+	for _, line := range strings.Split(leases, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		c, ok := byMAC[strings.ToLower(fields[1])]
+		if !ok {
+			continue
+		}
+		if c.IP == "" {
+			c.IP = fields[2]
+		}
+		if fields[3] != "*" {
+			c.Hostname = fields[3]
+		}
+		if expiry, _ := strconv.ParseInt(fields[0], 10, 64); expiry > 0 {
+			c.LeaseExpiry = time.Unix(expiry, 0)
+		}
+	}
+
+	// 静态租约, mac选项可能包含多个地址
+	hosts, err := r.uciSections(ctx, "dhcp", "host")
+	if err != nil && !isUbusNotFound(err) {
+		return
+	}
+	err = nil
+	for _, host := range hosts {
+		for _, item := range host.list("mac") {
+			for _, mac := range strings.Fields(item) {
+				c, ok := byMAC[strings.ToLower(mac)]
+				if !ok {
+					continue
+				}
+				c.Static = true
+				if c.Hostname == "" {
+					c.Hostname = host.str("name")
+				}
+				if c.IP == "" {
+					c.IP = host.str("ip")
+				}
+			}
+		}
+	}
+
+	for _, c := range byMAC {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].MAC < clients[j].MAC
+	})
+	return
+}
+
+// mergeWirelessClients 合并所有无线接口的关联列表, 没有无线设备时跳过
+func (r *Router) mergeWirelessClients(ctx context.Context, get func(mac string) *Client) (err error) {
+	devices := &struct {
+		Devices []string `json:"devices"`
+	}{}
+	if err = r.ubusCall(ctx, "iwinfo", "devices", nil, devices); err != nil {
+		if isUbusNotFound(err) {
+			err = nil
+		}
+		return
+	}
+	for _, device := range devices.Devices {
+		assoc := &struct {
+			Results []struct {
+				Mac    string `json:"mac"`
+				Signal int    `json:"signal"`
+			} `json:"results"`
+		}{}
+		if err = r.ubusCall(ctx, "iwinfo", "assoclist", map[string]interface{}{"device": device}, assoc); err != nil {
+			return
+		}
+		for _, station := range assoc.Results {
+			c := get(station.Mac)
+			c.Interface = device
+			c.Wireless = true
+			c.Signal = station.Signal
+		}
+	}
+	return
+}
+
+// fileRead 读取路由器上的文件内容
+func (r *Router) fileRead(ctx context.Context, path string) (data string, err error) {
+	res := &struct {
+		Data string `json:"data"`
+	}{}
+	if err = r.ubusCall(ctx, "file", "read", map[string]interface{}{"path": path}, res); err != nil {
+		return
+	}
+	data = res.Data
+	return
+}
+
+// fileWrite 覆盖写入路由器上的文件, 需要会话拥有该文件的写权限
+func (r *Router) fileWrite(ctx context.Context, path, data string) (err error) {
+	return r.ubusCall(ctx, "file", "write", map[string]interface{}{"path": path, "data": data}, nil)
+}
+
+// isUbusNotFound 判断是否为对象, 配置或文件不存在的错误
+func isUbusNotFound(err error) bool {
+	var ue *UbusError
+	return errors.As(err, &ue) && (ue.Code == ubusStatusNotFound || ue.Code == ubusStatusNoData)
+}
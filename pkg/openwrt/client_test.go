@@ -0,0 +1,78 @@
+package openwrt
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouter_ListClients(t *testing.T) {
+	srv := newUbusTestServer(t, func(object, method string, args map[string]interface{}) []interface{} {
+		switch object + "." + method {
+		case "session.login":
+			return []interface{}{0, map[string]interface{}{"ubus_rpc_session": "test-session"}}
+		case "file.read":
+			switch args["path"] {
+			case "/proc/net/arp":
+				return []interface{}{0, map[string]interface{}{"data": "IP address       HW type     Flags       HW address            Mask     Device\n" +
+					"192.168.2.10     0x1         0x2         AA:BB:CC:00:00:01     *        br-lan\n" +
+					"192.168.2.11     0x1         0x2         aa:bb:cc:00:00:02     *        br-lan\n" +
+					"192.168.2.12     0x1         0x0         00:00:00:00:00:00     *        br-lan\n"}}
+			case "/tmp/dhcp.leases":
+				return []interface{}{0, map[string]interface{}{"data": "1660003600 aa:bb:cc:00:00:01 192.168.2.10 laptop 01:aa:bb:cc:00:00:01\n" +
+					"1660003600 aa:bb:cc:00:00:09 192.168.2.19 gone *\n"}}
+			}
+			return []interface{}{4}
+		case "iwinfo.devices":
+			return []interface{}{0, map[string]interface{}{"devices": []string{"phy0-ap0"}}}
+		case "iwinfo.assoclist":
+			return []interface{}{0, map[string]interface{}{"results": []interface{}{
+				map[string]interface{}{"mac": "AA:BB:CC:00:00:01", "signal": -52},
+				map[string]interface{}{"mac": "AA:BB:CC:00:00:03", "signal": -70},
+			}}}
+		case "uci.get":
+			return []interface{}{0, map[string]interface{}{"values": map[string]interface{}{
+				"cfg01": map[string]interface{}{".name": "cfg01", ".index": 1, "name": "nas", "mac": "aa:bb:cc:00:00:02 aa:bb:cc:00:00:08", "ip": "192.168.2.11"},
+			}}}
+		}
+		return []interface{}{3}
+	})
+	defer srv.Close()
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+
+	clients, err := r.ListClients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 3 {
+		t.Fatalf("got %d clients, want 3", len(clients))
+	}
+	laptop, nas, phone := clients[0], clients[1], clients[2]
+	if laptop.Hostname != "laptop" || laptop.IP != "192.168.2.10" || !laptop.Wireless || laptop.Signal != -52 || laptop.Interface != "phy0-ap0" || laptop.LeaseExpiry.Unix() != 1660003600 {
+		t.Errorf("got laptop %+v", laptop)
+	}
+	if nas.Hostname != "nas" || !nas.Static || nas.Wireless || nas.Interface != "br-lan" {
+		t.Errorf("got nas %+v", nas)
+	}
+	if phone.MAC != "aa:bb:cc:00:00:03" || phone.IP != "" || phone.Signal != -70 {
+		t.Errorf("got phone %+v", phone)
+	}
+}
+
+func TestDiffClients(t *testing.T) {
+	t0 := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	prev := []*Client{{MAC: "aa:00", FirstSeen: t0}, {MAC: "bb:00", FirstSeen: t0}}
+	cur := []*Client{{MAC: "bb:00", FirstSeen: t1}, {MAC: "cc:00", FirstSeen: t1}}
+
+	diff := DiffClients(prev, cur)
+	if len(diff.Joined) != 1 || diff.Joined[0].MAC != "cc:00" {
+		t.Errorf("got joined %+v", diff.Joined)
+	}
+	if len(diff.Left) != 1 || diff.Left[0].MAC != "aa:00" {
+		t.Errorf("got left %+v", diff.Left)
+	}
+	if !cur[0].FirstSeen.Equal(t0) {
+		t.Errorf("got first seen %s, want %s", cur[0].FirstSeen, t0)
+	}
+}
@@ -0,0 +1,645 @@
+package openwrt
+
+import (
+	"context"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	firewallConfig        = "firewall"  // 防火墙的uci配置名称
+	firewallManagedOption = "net_utils" // 标记由本工具新增的配置节, 对账时只删除带有该标记的配置节
+)
+
+// PortForward 端口转发, 对应firewall中target为DNAT的redirect配置节
+type PortForward struct {
+	Section      string `uci:".name" yaml:"-"`                           // 配置节名称
+	Name         string `uci:"name" yaml:"name"`                         // 规则名称, 对账时以名称匹配
+	Protocol     string `uci:"proto" yaml:"proto,omitempty"`             // 协议, 如 tcp/udp/"tcp udp", 为空时为"tcp udp"
+	SrcZone      string `uci:"src" yaml:"src,omitempty"`                 // 来源区域, 为空时为wan
+	ExternalPort string `uci:"src_dport" yaml:"external_port"`           // 外部端口, 支持 8000-8100 形式的范围
+	DestZone     string `uci:"dest" yaml:"dest,omitempty"`               // 目标区域, 为空时为lan
+	InternalIP   string `uci:"dest_ip" yaml:"internal_ip"`               // 内部IP地址
+	InternalPort string `uci:"dest_port" yaml:"internal_port,omitempty"` // 内部端口, 为空时与外部端口相同
+	Enabled      bool   `uci:"enabled" yaml:"enabled"`                   // 是否启用, YAML中省略时为启用
+	Managed      bool   `uci:"net_utils" yaml:"-"`                       // 是否由本工具新增
+}
+
+// TrafficRule 通信规则, 对应firewall中的rule配置节
+type TrafficRule struct {
+	Section  string `uci:".name" yaml:"-"`                   // 配置节名称
+	Name     string `uci:"name" yaml:"name"`                 // 规则名称, 对账时以名称匹配
+	Protocol string `uci:"proto" yaml:"proto,omitempty"`     // 协议, 为空时为"tcp udp"
+	SrcZone  string `uci:"src" yaml:"src,omitempty"`         // 来源区域, 为空表示路由器自身发出
+	SrcIP    string `uci:"src_ip" yaml:"src_ip,omitempty"`   // 来源IP地址
+	DestZone string `uci:"dest" yaml:"dest,omitempty"`       // 目标区域, 为空表示访问路由器自身
+	DestIP   string `uci:"dest_ip" yaml:"dest_ip,omitempty"` // 目标IP地址
+	DestPort string `uci:"dest_port" yaml:"dest_port,omitempty"`
+	Target   string `uci:"target" yaml:"target"`   // 动作, ACCEPT/REJECT/DROP
+	Enabled  bool   `uci:"enabled" yaml:"enabled"` // 是否启用, YAML中省略时为启用
+	Managed  bool   `uci:"net_utils" yaml:"-"`     // 是否由本工具新增
+}
+
+// UnmarshalYAML 省略enabled时默认启用
+func (p *PortForward) UnmarshalYAML(value *yaml.Node) (err error) {
+	type plain PortForward
+	v := plain{Enabled: true}
+	if err = value.Decode(&v); err != nil {
+		return
+	}
+	*p = PortForward(v)
+	return
+}
+
+// UnmarshalYAML 省略enabled时默认启用
+func (t *TrafficRule) UnmarshalYAML(value *yaml.Node) (err error) {
+	type plain TrafficRule
+	v := plain{Enabled: true}
+	if err = value.Decode(&v); err != nil {
+		return
+	}
+	*t = TrafficRule(v)
+	return
+}
+
+// normalize 填充默认值, 使路由器读取的规则与YAML声明的规则可以直接比较
+func (p *PortForward) normalize() {
+	if p.Protocol == "" {
+		p.Protocol = "tcp udp"
+	}
+	if p.SrcZone == "" {
+		p.SrcZone = "wan"
+	}
+	if p.DestZone == "" {
+		p.DestZone = "lan"
+	}
+	if p.InternalPort == "" {
+		p.InternalPort = p.ExternalPort
+	}
+}
+
+// validate 校验端口转发
+func (p *PortForward) validate() (err error) {
+	if p.Name == "" {
+		return fmt.Errorf("port forward name is empty")
+	}
+	if net.ParseIP(p.InternalIP) == nil {
+		return fmt.Errorf("port forward %s: invalid internal ip %q", p.Name, p.InternalIP)
+	}
+	if _, _, err = parsePortRange(p.ExternalPort); err != nil {
+		return fmt.Errorf("port forward %s: %w", p.Name, err)
+	}
+	if _, _, err = parsePortRange(p.InternalPort); err != nil {
+		return fmt.Errorf("port forward %s: %w", p.Name, err)
+	}
+	return
+}
+
+// values 转换为uci选项
+func (p *PortForward) values() map[string]string {
+	return map[string]string{
+		"name":      p.Name,
+		"target":    "DNAT",
+		"proto":     p.Protocol,
+		"src":       p.SrcZone,
+		"src_dport": p.ExternalPort,
+		"dest":      p.DestZone,
+		"dest_ip":   p.InternalIP,
+		"dest_port": p.InternalPort,
+		"enabled":   boolOption(p.Enabled),
+	}
+}
+
+// normalize 填充默认值
+func (t *TrafficRule) normalize() {
+	if t.Protocol == "" {
+		t.Protocol = "tcp udp"
+	}
+	t.Target = strings.ToUpper(t.Target)
+}
+
+// validate 校验通信规则
+func (t *TrafficRule) validate() (err error) {
+	if t.Name == "" {
+		return fmt.Errorf("traffic rule name is empty")
+	}
+	switch t.Target {
+	case "ACCEPT", "REJECT", "DROP":
+	default:
+		return fmt.Errorf("traffic rule %s: invalid target %q", t.Name, t.Target)
+	}
+	if t.DestPort != "" {
+		if _, _, err = parsePortRange(t.DestPort); err != nil {
+			return fmt.Errorf("traffic rule %s: %w", t.Name, err)
+		}
+	}
+	return
+}
+
+// values 转换为uci选项
+func (t *TrafficRule) values() map[string]string {
+	return map[string]string{
+		"name":      t.Name,
+		"proto":     t.Protocol,
+		"src":       t.SrcZone,
+		"src_ip":    t.SrcIP,
+		"dest":      t.DestZone,
+		"dest_ip":   t.DestIP,
+		"dest_port": t.DestPort,
+		"target":    t.Target,
+		"enabled":   boolOption(t.Enabled),
+	}
+}
+
+// parsePortRange 解析端口或 起始-结束 形式的端口范围
+func parsePortRange(s string) (from, to int, err error) {
+	begin, end, isRange := strings.Cut(s, "-")
+	if !isRange {
+		begin, end, isRange = strings.Cut(s, ":")
+	}
+	if from, err = strconv.Atoi(begin); err != nil || from <= 0 || from > 65535 {
+		return 0, 0, fmt.Errorf("invalid port %q", s)
+	}
+	to = from
+	if isRange {
+		if to, err = strconv.Atoi(end); err != nil || to < from || to > 65535 {
+			return 0, 0, fmt.Errorf("invalid port range %q", s)
+		}
+	}
+	return
+}
+
+// protocols 协议集合, "all"或为空时视为tcp及udp
+func protocols(proto string) map[string]bool {
+	set := map[string]bool{}
+	for _, p := range strings.Fields(strings.ToLower(proto)) {
+		switch p {
+		case "all", "tcpudp":
+			set["tcp"], set["udp"] = true, true
+		default:
+			set[p] = true
+		}
+	}
+	if len(set) == 0 {
+		set["tcp"], set["udp"] = true, true
+	}
+	return set
+}
+
+// ValidatePortForwards 校验端口转发列表: 字段合法, 名称不重复, 同一来源区域启用的规则外部端口不重叠
+func ValidatePortForwards(fwds []*PortForward) (err error) {
+	names := map[string]bool{}
+	for i, a := range fwds {
+		if err = a.validate(); err != nil {
+			return
+		}
+		if names[a.Name] {
+			return fmt.Errorf("duplicate port forward name %q", a.Name)
+		}
+		names[a.Name] = true
+		for _, b := range fwds[:i] {
+			if err = checkPortOverlap(a, b); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// validateAgainst 校验即将写入的端口转发, 并与路由器上的其他规则(不含同一配置节)比较名称及外部端口;
+// 现有规则可能由LuCI等写入, 端口无法解析的现有规则不参与重叠检查
+func (p *PortForward) validateAgainst(current []*PortForward) (err error) {
+	if err = p.validate(); err != nil {
+		return
+	}
+	for _, b := range current {
+		if p.Section != "" && b.Section == p.Section {
+			continue
+		}
+		if b.Name == p.Name {
+			return fmt.Errorf("duplicate port forward name %q", p.Name)
+		}
+		if _, _, err = parsePortRange(b.ExternalPort); err != nil {
+			err = nil
+			continue
+		}
+		if err = checkPortOverlap(p, b); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// checkPortOverlap 同一来源区域启用的两条规则在相同协议上外部端口重叠时返回错误, 两者端口均需已校验
+func checkPortOverlap(a, b *PortForward) (err error) {
+	if !a.Enabled || !b.Enabled || a.SrcZone != b.SrcZone {
+		return
+	}
+	aFrom, aTo, _ := parsePortRange(a.ExternalPort)
+	bFrom, bTo, _ := parsePortRange(b.ExternalPort)
+	if aFrom > bTo || bFrom > aTo {
+		return
+	}
+	for p := range protocols(a.Protocol) {
+		if protocols(b.Protocol)[p] {
+			return fmt.Errorf("port forward %s overlaps %s on %s/%s", a.Name, b.Name, p, a.ExternalPort)
+		}
+	}
+	return
+}
+
+// ListPortForwards 列出端口转发
+func (r *Router) ListPortForwards() (fwds []*PortForward, err error) {
+	return r.ListPortForwardsContext(context.Background())
+}
+
+// ListPortForwardsContext 列出端口转发
+func (r *Router) ListPortForwardsContext(ctx context.Context) (fwds []*PortForward, err error) {
+	sections, err := r.GetUCISectionsContext(ctx, firewallConfig, "redirect")
+	if err != nil {
+		return
+	}
+	for _, section := range sections {
+		if target := section.Get("target"); target != "" && target != "DNAT" {
+			continue
+		}
+		fwd := &PortForward{Enabled: true}
+		if err = section.Decode(fwd); err != nil {
+			return
+		}
+		fwd.normalize()
+		fwds = append(fwds, fwd)
+	}
+	return
+}
+
+// AddPortForward 新增端口转发并以确认或回滚方式应用
+func (r *Router) AddPortForward(fwd *PortForward, opts *UCIApplyOptions) (err error) {
+	return r.AddPortForwardContext(context.Background(), fwd, opts)
+}
+
+// AddPortForwardContext 新增端口转发并以确认或回滚方式应用, 与现有规则端口重叠时返回错误
+func (r *Router) AddPortForwardContext(ctx context.Context, fwd *PortForward, opts *UCIApplyOptions) (err error) {
+	fwd.normalize()
+	current, err := r.ListPortForwardsContext(ctx)
+	if err != nil {
+		return
+	}
+	fwd.Section = ""
+	if err = fwd.validateAgainst(current); err != nil {
+		return
+	}
+	if fwd.Section, err = r.addFirewallSection(ctx, "redirect", fwd.values()); err != nil {
+		return
+	}
+	return r.ApplyUCIContext(ctx, opts)
+}
+
+// UpdatePortForward 按配置节名称修改端口转发并以确认或回滚方式应用
+func (r *Router) UpdatePortForward(fwd *PortForward, opts *UCIApplyOptions) (err error) {
+	return r.UpdatePortForwardContext(context.Background(), fwd, opts)
+}
+
+// UpdatePortForwardContext 按配置节名称修改端口转发并以确认或回滚方式应用, 与其他规则端口重叠时返回错误
+func (r *Router) UpdatePortForwardContext(ctx context.Context, fwd *PortForward, opts *UCIApplyOptions) (err error) {
+	fwd.normalize()
+	current, err := r.ListPortForwardsContext(ctx)
+	if err != nil {
+		return
+	}
+	found := false
+	for _, item := range current {
+		found = found || item.Section == fwd.Section
+	}
+	if fwd.Section == "" || !found {
+		return fmt.Errorf("port forward %q not found", fwd.Section)
+	}
+	if err = fwd.validateAgainst(current); err != nil {
+		return
+	}
+	if err = r.setFirewallSection(ctx, fwd.Section, fwd.values()); err != nil {
+		return
+	}
+	return r.ApplyUCIContext(ctx, opts)
+}
+
+// DeletePortForward 删除端口转发并以确认或回滚方式应用
+func (r *Router) DeletePortForward(section string, opts *UCIApplyOptions) (err error) {
+	return r.DeletePortForwardContext(context.Background(), section, opts)
+}
+
+// DeletePortForwardContext 删除端口转发并以确认或回滚方式应用
+func (r *Router) DeletePortForwardContext(ctx context.Context, section string, opts *UCIApplyOptions) (err error) {
+	if err = r.DeleteUCIContext(ctx, firewallConfig, section); err != nil {
+		return
+	}
+	return r.ApplyUCIContext(ctx, opts)
+}
+
+// ListTrafficRules 列出通信规则
+func (r *Router) ListTrafficRules() (rules []*TrafficRule, err error) {
+	return r.ListTrafficRulesContext(context.Background())
+}
+
+// ListTrafficRulesContext 列出通信规则
+func (r *Router) ListTrafficRulesContext(ctx context.Context) (rules []*TrafficRule, err error) {
+	sections, err := r.GetUCISectionsContext(ctx, firewallConfig, "rule")
+	if err != nil {
+		return
+	}
+	for _, section := range sections {
+		rule := &TrafficRule{Enabled: true}
+		if err = section.Decode(rule); err != nil {
+			return
+		}
+		rule.normalize()
+		rules = append(rules, rule)
+	}
+	return
+}
+
+// AddTrafficRule 新增通信规则并以确认或回滚方式应用
+func (r *Router) AddTrafficRule(rule *TrafficRule, opts *UCIApplyOptions) (err error) {
+	return r.AddTrafficRuleContext(context.Background(), rule, opts)
+}
+
+// AddTrafficRuleContext 新增通信规则并以确认或回滚方式应用
+func (r *Router) AddTrafficRuleContext(ctx context.Context, rule *TrafficRule, opts *UCIApplyOptions) (err error) {
+	rule.normalize()
+	if err = rule.validate(); err != nil {
+		return
+	}
+	if rule.Section, err = r.addFirewallSection(ctx, "rule", rule.values()); err != nil {
+		return
+	}
+	return r.ApplyUCIContext(ctx, opts)
+}
+
+// UpdateTrafficRule 按配置节名称修改通信规则并以确认或回滚方式应用
+func (r *Router) UpdateTrafficRule(rule *TrafficRule, opts *UCIApplyOptions) (err error) {
+	return r.UpdateTrafficRuleContext(context.Background(), rule, opts)
+}
+
+// UpdateTrafficRuleContext 按配置节名称修改通信规则并以确认或回滚方式应用
+func (r *Router) UpdateTrafficRuleContext(ctx context.Context, rule *TrafficRule, opts *UCIApplyOptions) (err error) {
+	rule.normalize()
+	if err = rule.validate(); err != nil {
+		return
+	}
+	if err = r.setFirewallSection(ctx, rule.Section, rule.values()); err != nil {
+		return
+	}
+	return r.ApplyUCIContext(ctx, opts)
+}
+
+// DeleteTrafficRule 删除通信规则并以确认或回滚方式应用
+func (r *Router) DeleteTrafficRule(section string, opts *UCIApplyOptions) (err error) {
+	return r.DeleteTrafficRuleContext(context.Background(), section, opts)
+}
+
+// DeleteTrafficRuleContext 删除通信规则并以确认或回滚方式应用
+func (r *Router) DeleteTrafficRuleContext(ctx context.Context, section string, opts *UCIApplyOptions) (err error) {
+	if err = r.DeleteUCIContext(ctx, firewallConfig, section); err != nil {
+		return
+	}
+	return r.ApplyUCIContext(ctx, opts)
+}
+
+// addFirewallSection 暂存新增的防火墙配置节, 忽略空选项并加上本工具的标记
+func (r *Router) addFirewallSection(ctx context.Context, typ string, values map[string]string) (name string, err error) {
+	set := map[string]interface{}{firewallManagedOption: "1"}
+	for option, val := range values {
+		if val != "" {
+			set[option] = val
+		}
+	}
+	return r.AddUCISectionContext(ctx, firewallConfig, typ, set)
+}
+
+// setFirewallSection 暂存防火墙配置节的修改, 空选项会被删除
+func (r *Router) setFirewallSection(ctx context.Context, section string, values map[string]string) (err error) {
+	set := map[string]interface{}{}
+	var unset []string
+	for option, val := range values {
+		if val == "" {
+			unset = append(unset, option)
+		} else {
+			set[option] = val
+		}
+	}
+	if err = r.SetUCIContext(ctx, firewallConfig, section, set); err != nil {
+		return
+	}
+	if len(unset) != 0 {
+		if err = r.DeleteUCIContext(ctx, firewallConfig, section, unset...); err != nil && !isUbusNotFound(err) {
+			return
+		}
+		err = nil
+	}
+	return
+}
+
+// FirewallFile 声明式的防火墙规则文件
+type FirewallFile struct {
+	PortForwards []*PortForward `yaml:"port_forwards,omitempty"`
+	TrafficRules []*TrafficRule `yaml:"traffic_rules,omitempty"`
+}
+
+// ParseFirewallFile 解析YAML格式的防火墙规则文件并校验
+func ParseFirewallFile(data []byte) (f *FirewallFile, err error) {
+	f = &FirewallFile{}
+	if err = yaml.Unmarshal(data, f); err != nil {
+		return
+	}
+	err = f.validate()
+	return
+}
+
+// Marshal 输出YAML格式的防火墙规则文件
+func (f *FirewallFile) Marshal() ([]byte, error) {
+	return yaml.Marshal(f)
+}
+
+// validate 填充默认值并校验规则, 名称在同类规则中必须唯一
+func (f *FirewallFile) validate() (err error) {
+	for _, fwd := range f.PortForwards {
+		fwd.normalize()
+	}
+	if err = ValidatePortForwards(f.PortForwards); err != nil {
+		return
+	}
+	names := map[string]bool{}
+	for _, rule := range f.TrafficRules {
+		rule.normalize()
+		if err = rule.validate(); err != nil {
+			return
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate traffic rule name %q", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	return
+}
+
+// ExportFirewall 导出路由器上的端口转发及通信规则
+func (r *Router) ExportFirewall() (f *FirewallFile, err error) {
+	return r.ExportFirewallContext(context.Background())
+}
+
+// ExportFirewallContext 导出路由器上的端口转发及通信规则
+func (r *Router) ExportFirewallContext(ctx context.Context) (f *FirewallFile, err error) {
+	f = &FirewallFile{}
+	if f.PortForwards, err = r.ListPortForwardsContext(ctx); err != nil {
+		return
+	}
+	f.TrafficRules, err = r.ListTrafficRulesContext(ctx)
+	return
+}
+
+// FirewallReconcileOptions 对账参数
+type FirewallReconcileOptions struct {
+	Prune  bool             // 删除文件中没有声明且由本工具新增的同名规则, 不会删除未命名或系统自带的规则; 默认只新增和修改
+	DryRun bool             // 只生成变更计划, 不修改路由器
+	Apply  *UCIApplyOptions // 应用修改的参数
+}
+
+// FirewallPlan 对账生成的变更计划, 内容为规则名称
+type FirewallPlan struct {
+	Add    []string
+	Update []string
+	Delete []string
+}
+
+// Empty 是否没有任何变更
+func (p *FirewallPlan) Empty() bool {
+	return len(p.Add) == 0 && len(p.Update) == 0 && len(p.Delete) == 0
+}
+
+// ReconcileFirewall 将声明的规则同步到路由器
+func (r *Router) ReconcileFirewall(desired *FirewallFile, opts *FirewallReconcileOptions) (plan *FirewallPlan, err error) {
+	return r.ReconcileFirewallContext(context.Background(), desired, opts)
+}
+
+// ReconcileFirewallContext 将声明的规则按名称同步到路由器, 所有修改一次性以确认或回滚方式应用
+func (r *Router) ReconcileFirewallContext(ctx context.Context, desired *FirewallFile, opts *FirewallReconcileOptions) (plan *FirewallPlan, err error) {
+	if opts == nil {
+		opts = &FirewallReconcileOptions{}
+	}
+	if err = desired.validate(); err != nil {
+		return
+	}
+	current, err := r.ExportFirewallContext(ctx)
+	if err != nil {
+		return
+	}
+
+	// 生成变更计划
+	plan = &FirewallPlan{}
+	var stages []func() error
+	type entry struct {
+		name, section string
+		values        map[string]string
+		managed       bool
+	}
+	// reconcile 按名称比较, 计划按文件及路由器上的规则顺序生成; 路由器上未命名的配置节不参与匹配,
+	// 同名的多个配置节以第一个为准. 返回需要新增或修改的规则名称, 以及将被修改或删除的配置节
+	reconcile := func(typ string, want, have []entry) (changed, touched map[string]bool) {
+		changed, touched = map[string]bool{}, map[string]bool{}
+		byName := map[string]entry{}
+		for _, h := range have {
+			if _, ok := byName[h.name]; h.name != "" && !ok {
+				byName[h.name] = h
+			}
+		}
+		declared := map[string]bool{}
+		for _, w := range want {
+			declared[w.name] = true
+			h, ok := byName[w.name]
+			switch {
+			case !ok:
+				plan.Add = append(plan.Add, w.name)
+				changed[w.name] = true
+				values := w.values
+				stages = append(stages, func() error {
+					_, err := r.addFirewallSection(ctx, typ, values)
+					return err
+				})
+			case !reflect.DeepEqual(w.values, h.values):
+				plan.Update = append(plan.Update, w.name)
+				changed[w.name], touched[h.section] = true, true
+				section, values := h.section, w.values
+				stages = append(stages, func() error {
+					return r.setFirewallSection(ctx, section, values)
+				})
+			}
+		}
+		if !opts.Prune {
+			return
+		}
+		for _, h := range have {
+			if h.managed && h.name != "" && !declared[h.name] {
+				plan.Delete = append(plan.Delete, h.name)
+				touched[h.section] = true
+				section := h.section
+				stages = append(stages, func() error {
+					return r.DeleteUCIContext(ctx, firewallConfig, section)
+				})
+			}
+		}
+		return
+	}
+
+	var want, have []entry
+	for _, fwd := range desired.PortForwards {
+		want = append(want, entry{fwd.Name, fwd.Section, fwd.values(), fwd.Managed})
+	}
+	for _, fwd := range current.PortForwards {
+		have = append(have, entry{fwd.Name, fwd.Section, fwd.values(), fwd.Managed})
+	}
+	changed, touched := reconcile("redirect", want, have)
+
+	// 新增及修改的端口转发还要与计划保留的现有规则比较, 包括未命名或不由本工具管理的规则
+	var kept []*PortForward
+	for _, fwd := range current.PortForwards {
+		if !touched[fwd.Section] {
+			kept = append(kept, fwd)
+		}
+	}
+	for _, fwd := range desired.PortForwards {
+		if !changed[fwd.Name] {
+			continue
+		}
+		if err = fwd.validateAgainst(kept); err != nil {
+			return nil, err
+		}
+	}
+
+	want, have = nil, nil
+	for _, rule := range desired.TrafficRules {
+		want = append(want, entry{rule.Name, rule.Section, rule.values(), rule.Managed})
+	}
+	for _, rule := range current.TrafficRules {
+		have = append(have, entry{rule.Name, rule.Section, rule.values(), rule.Managed})
+	}
+	reconcile("rule", want, have)
+
+	if opts.DryRun || plan.Empty() {
+		return
+	}
+	// 暂存失败时丢弃已暂存的修改, 避免之后的应用提交不完整的计划
+	for _, stage := range stages {
+		if err = stage(); err != nil {
+			if revertErr := r.RevertUCIContext(ctx, firewallConfig); revertErr != nil {
+				err = fmt.Errorf("%w; revert firewall: %v", err, revertErr)
+			}
+			return
+		}
+	}
+	err = r.ApplyUCIContext(ctx, opts.Apply)
+	return
+}
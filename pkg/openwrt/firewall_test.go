@@ -0,0 +1,266 @@
+package openwrt
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func newFirewallTestStore() uciTestStore {
+	return uciTestStore{
+		"firewall": {
+			"cfg01": {".name": "cfg01", ".type": "rule", ".index": float64(0), "name": "Allow-Ping", "src": "wan", "proto": "icmp", "target": "ACCEPT"},
+			"cfg02": {".name": "cfg02", ".type": "redirect", ".index": float64(1), "name": "ssh", "target": "DNAT", "src": "wan", "dest": "lan", "proto": "tcp", "src_dport": "2222", "dest_ip": "192.168.1.10", "dest_port": "22"},
+			"cfg03": {".name": "cfg03", ".type": "redirect", ".index": float64(2), "name": "games", "src": "wan", "src_dport": "27000-27050", "dest_ip": "192.168.1.20", "enabled": "0", "net_utils": "1"},
+			"cfg04": {".name": "cfg04", ".type": "redirect", ".index": float64(3), "name": "masq", "target": "SNAT", "src": "lan"},
+			"cfg05": {".name": "cfg05", ".type": "rule", ".index": float64(4), "name": "Allow-DHCP-Renew", "src": "wan", "proto": "udp", "dest_port": "68", "target": "ACCEPT"},
+		},
+	}
+}
+
+func TestValidatePortForwards(t *testing.T) {
+	web := &PortForward{Name: "web", Protocol: "tcp", SrcZone: "wan", ExternalPort: "8000-8100", InternalIP: "192.168.1.2", InternalPort: "80", Enabled: true}
+	tests := []struct {
+		name string
+		fwd  *PortForward
+		ok   bool
+	}{
+		{"overlap", &PortForward{Name: "a", Protocol: "tcp udp", SrcZone: "wan", ExternalPort: "8080", InternalIP: "192.168.1.3", InternalPort: "80", Enabled: true}, false},
+		{"other protocol", &PortForward{Name: "b", Protocol: "udp", SrcZone: "wan", ExternalPort: "8080", InternalIP: "192.168.1.3", InternalPort: "80", Enabled: true}, true},
+		{"other zone", &PortForward{Name: "c", Protocol: "tcp", SrcZone: "vpn", ExternalPort: "8080", InternalIP: "192.168.1.3", InternalPort: "80", Enabled: true}, true},
+		{"disabled", &PortForward{Name: "d", Protocol: "tcp", SrcZone: "wan", ExternalPort: "8080", InternalIP: "192.168.1.3", InternalPort: "80"}, true},
+		{"duplicate name", &PortForward{Name: "web", Protocol: "tcp", SrcZone: "wan", ExternalPort: "9000", InternalIP: "192.168.1.3", InternalPort: "80", Enabled: true}, false},
+		{"invalid ip", &PortForward{Name: "e", Protocol: "tcp", SrcZone: "wan", ExternalPort: "9000", InternalIP: "nas", InternalPort: "80", Enabled: true}, false},
+		{"invalid range", &PortForward{Name: "f", Protocol: "tcp", SrcZone: "wan", ExternalPort: "9100-9000", InternalIP: "192.168.1.3", InternalPort: "80", Enabled: true}, false},
+	}
+	for _, tt := range tests {
+		if err := ValidatePortForwards([]*PortForward{web, tt.fwd}); (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v", tt.name, err)
+		}
+	}
+}
+
+func TestRouter_PortForwardCRUD(t *testing.T) {
+	store := newFirewallTestStore()
+	srv := newUciTestServer(t, store, nil)
+	defer srv.Close()
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+	opts := &UCIApplyOptions{Holdoff: time.Millisecond}
+
+	fwds, err := r.ListPortForwards()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fwds) != 2 {
+		t.Fatalf("got %d port forwards, want 2", len(fwds))
+	}
+	if ssh := fwds[0]; ssh.Section != "cfg02" || ssh.ExternalPort != "2222" || ssh.InternalPort != "22" || !ssh.Enabled {
+		t.Errorf("got ssh %+v", ssh)
+	}
+	if games := fwds[1]; games.Protocol != "tcp udp" || games.InternalPort != "27000-27050" || games.Enabled {
+		t.Errorf("got games %+v", games)
+	}
+
+	if err = r.AddPortForward(&PortForward{Name: "ssh2", Protocol: "tcp", ExternalPort: "2222", InternalIP: "192.168.1.11", Enabled: true}, opts); err == nil {
+		t.Error("expected overlap error")
+	}
+	// 现有规则端口无法解析时不影响新增及修改
+	store["firewall"]["cfg06"] = map[string]interface{}{".name": "cfg06", ".type": "redirect", ".index": float64(5), "name": "luci", "src": "wan", "src_dport": "http", "dest_ip": "192.168.1.30"}
+	web := &PortForward{Name: "web", Protocol: "tcp", ExternalPort: "8080", InternalIP: "192.168.1.2", InternalPort: "80", Enabled: true}
+	if err = r.AddPortForward(web, opts); err != nil {
+		t.Fatal(err)
+	}
+	if added := store["firewall"][web.Section]; added["target"] != "DNAT" || added["src"] != "wan" || added["dest_ip"] != "192.168.1.2" || added["enabled"] != "1" || added["net_utils"] != "1" {
+		t.Errorf("got added section %v", added)
+	}
+
+	if err = r.AddPortForward(&PortForward{Name: "ssh", Protocol: "tcp", ExternalPort: "2022", InternalIP: "192.168.1.11", Enabled: true}, opts); err == nil {
+		t.Error("expected duplicate name error")
+	}
+
+	web.ExternalPort = "27010"
+	web.Enabled = false
+	if err = r.UpdatePortForward(web, opts); err != nil {
+		t.Fatal(err)
+	}
+	if updated := store["firewall"][web.Section]; updated["src_dport"] != "27010" || updated["enabled"] != "0" {
+		t.Errorf("got updated section %v", updated)
+	}
+
+	if err = r.DeletePortForward("cfg02", opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store["firewall"]["cfg02"]; ok {
+		t.Error("port forward not deleted")
+	}
+}
+
+func TestRouter_TrafficRuleCRUD(t *testing.T) {
+	store := newFirewallTestStore()
+	srv := newUciTestServer(t, store, nil)
+	defer srv.Close()
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+	opts := &UCIApplyOptions{Holdoff: time.Millisecond}
+
+	rule := &TrafficRule{Name: "Block-IoT", SrcZone: "iot", DestZone: "wan", Target: "reject", Enabled: true}
+	if err := r.AddTrafficRule(rule, opts); err != nil {
+		t.Fatal(err)
+	}
+	rule.SrcIP = "192.168.2.0/24"
+	rule.DestZone = ""
+	if err := r.UpdateTrafficRule(rule, opts); err != nil {
+		t.Fatal(err)
+	}
+	section := store["firewall"][rule.Section]
+	if _, ok := section["dest"]; ok || section["src_ip"] != "192.168.2.0/24" || section["target"] != "REJECT" {
+		t.Errorf("got updated section %v", section)
+	}
+
+	rules, err := r.ListTrafficRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 || rules[0].Name != "Allow-Ping" || !rules[0].Enabled || rules[2].SrcIP != "192.168.2.0/24" || !rules[2].Managed {
+		t.Errorf("got rules %+v %+v", rules[0], rules[2])
+	}
+
+	if err = r.DeleteTrafficRule(rule.Section, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store["firewall"][rule.Section]; ok {
+		t.Error("traffic rule not deleted")
+	}
+}
+
+func TestRouter_ReconcileFirewall(t *testing.T) {
+	store := newFirewallTestStore()
+	srv := newUciTestServer(t, store, nil)
+	defer srv.Close()
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+
+	f, err := ParseFirewallFile([]byte(`
+port_forwards:
+  - name: ssh
+    proto: tcp
+    external_port: "2022"
+    internal_ip: 192.168.1.10
+    internal_port: "22"
+  - name: web
+    proto: tcp
+    external_port: "8080"
+    internal_ip: 192.168.1.2
+    internal_port: "80"
+traffic_rules:
+  - name: Allow-Ping
+    src: wan
+    proto: icmp
+    target: ACCEPT
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.PortForwards[1].Enabled {
+		t.Error("port forward should default to enabled")
+	}
+
+	opts := &FirewallReconcileOptions{DryRun: true, Prune: true}
+	plan, err := r.ReconcileFirewall(f, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Add) != 1 || plan.Add[0] != "web" || len(plan.Update) != 1 || plan.Update[0] != "ssh" || len(plan.Delete) != 1 || plan.Delete[0] != "games" {
+		t.Errorf("got plan %+v", plan)
+	}
+	if store["firewall"]["cfg02"]["src_dport"] != "2222" {
+		t.Error("dry run modified the router")
+	}
+
+	opts.DryRun = false
+	opts.Apply = &UCIApplyOptions{Holdoff: time.Millisecond}
+	if _, err = r.ReconcileFirewall(f, opts); err != nil {
+		t.Fatal(err)
+	}
+	if store["firewall"]["cfg02"]["src_dport"] != "2022" {
+		t.Errorf("got ssh %v", store["firewall"]["cfg02"])
+	}
+	if _, ok := store["firewall"]["cfg03"]; ok {
+		t.Error("games not pruned")
+	}
+	if _, ok := store["firewall"]["cfg04"]; !ok {
+		t.Error("SNAT redirect should not be managed")
+	}
+	if _, ok := store["firewall"]["cfg05"]; !ok {
+		t.Error("rule not created by reconcile should not be pruned")
+	}
+
+	// 同步后再次对账没有变更, 导出的文件可以重新解析
+	if plan, err = r.ReconcileFirewall(f, opts); err != nil || !plan.Empty() {
+		t.Errorf("got plan %+v, err %v", plan, err)
+	}
+	exported, err := r.ExportFirewall()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := exported.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseFirewallFile(data); err != nil {
+		t.Errorf("parse exported file: %v\n%s", err, data)
+	}
+
+	// 未命名的配置节不参与匹配及删除
+	store["firewall"]["cfg07"] = map[string]interface{}{".name": "cfg07", ".type": "redirect", ".index": float64(6), "src": "wan", "src_dport": "9000", "dest_ip": "192.168.1.40", "net_utils": "1"}
+	if plan, err = r.ReconcileFirewall(f, opts); err != nil || !plan.Empty() {
+		t.Errorf("got plan %+v, err %v", plan, err)
+	}
+	if _, ok := store["firewall"]["cfg07"]; !ok {
+		t.Error("unnamed redirect should not be pruned")
+	}
+}
+
+func TestRouter_ReconcileFirewallExistingRules(t *testing.T) {
+	store := newFirewallTestStore()
+	// 未命名且不由本工具管理的规则同样不能与新规则端口重叠
+	store["firewall"]["cfg06"] = uciSection{".name": "cfg06", ".type": "redirect", ".index": float64(5), "src": "wan", "proto": "tcp", "src_dport": "8080", "dest_ip": "192.168.1.30"}
+	var reverted []string
+	handler := uciTestHandler(store, nil)
+	srv := newUbusTestServer(t, func(object, method string, args map[string]interface{}) []interface{} {
+		switch object + "." + method {
+		case "uci.revert":
+			reverted = append(reverted, args["config"].(string))
+			return []interface{}{0}
+		case "uci.add":
+			return []interface{}{6}
+		}
+		return handler(object, method, args)
+	})
+	defer srv.Close()
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+	opts := &FirewallReconcileOptions{Apply: &UCIApplyOptions{Holdoff: time.Millisecond}}
+
+	f, err := ParseFirewallFile([]byte(`
+port_forwards:
+  - name: web
+    proto: tcp
+    external_port: "8000-8100"
+    internal_ip: 192.168.1.2
+    internal_port: "80"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.ReconcileFirewall(f, opts); err == nil || !strings.Contains(err.Error(), "overlaps") {
+		t.Fatalf("got %v, want overlap error", err)
+	}
+
+	// 暂存失败时丢弃已暂存的修改
+	f.PortForwards[0].ExternalPort = "9000"
+	f.PortForwards = append([]*PortForward{{Name: "ssh", Protocol: "tcp", SrcZone: "wan", DestZone: "lan", ExternalPort: "2022", InternalIP: "192.168.1.10", InternalPort: "22", Enabled: true}}, f.PortForwards...)
+	if _, err = r.ReconcileFirewall(f, opts); err == nil {
+		t.Fatal("expected staging error")
+	}
+	if len(reverted) != 1 || reverted[0] != "firewall" {
+		t.Errorf("got reverted %v", reverted)
+	}
+}
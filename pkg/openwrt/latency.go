@@ -0,0 +1,171 @@
+package openwrt
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultLatencyConcurrency = 8                // 默认并发测速的节点数
+	DefaultLatencyTimeout     = 10 * time.Second // 默认单个节点的测速超时时间
+)
+
+var ErrProxyNodeOffline = errors.New("proxy node offline") // 测速时节点无法连接
+
+// LatencyOptions 批量测速参数
+type LatencyOptions struct {
+	Concurrency int                                       // 并发测速的节点数, 为空时使用 DefaultLatencyConcurrency
+	Timeout     time.Duration                             // 单个节点所有采样的超时时间, 为空时使用 DefaultLatencyTimeout
+	Samples     int                                       // 每个节点的采样次数, 为空时采样一次
+	Progress    func(done, total int, res *LatencyResult) // 每个节点测速完成后串行回调, 可用于命令行显示进度
+}
+
+// LatencyResult 单个节点的测速结果
+type LatencyResult struct {
+	Node    *ProxyNodeInfo // 测速节点
+	Samples []int          // 成功采样的延迟时间
+	Failed  int            // 离线或出错的采样次数
+	Latency int            // 成功采样的平均延迟
+	Offline bool           // 所有采样都失败
+	Err     error          // 最后一次出错采样的错误
+}
+
+// LatencyReport 批量测速结果
+type LatencyReport struct {
+	Online  []*LatencyResult // 在线节点, 按延迟从低到高排序
+	Offline []*LatencyResult // 离线或测速失败的节点, 保持输入顺序
+}
+
+// TestAllProxyNodeLatency 并发测试所有代理节点延迟, 测速完成后同时更新节点的 Latency 和 Offline;
+// 重复的节点(同一指针或相同编号)只测速一次, 结果中只出现第一个, 空节点被忽略; ctx取消时返回已完成的结果, 未测速的节点计入离线列表
+func (r *Router) TestAllProxyNodeLatency(ctx context.Context, nodes []*ProxyNodeInfo, opts *LatencyOptions) (report *LatencyReport, err error) {
+	nodes = uniqueNodes(nodes)
+	if opts == nil {
+		opts = &LatencyOptions{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultLatencyConcurrency
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultLatencyTimeout
+	}
+	samples := opts.Samples
+	if samples <= 0 {
+		samples = 1
+	}
+
+	// 分发测速任务
+	jobs := make(chan int)
+	results := make(chan int)
+	all := make([]*LatencyResult, len(nodes))
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency && i < len(nodes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				all[idx] = r.testNodeLatency(ctx, nodes[idx], samples, timeout)
+				results <- idx
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for idx := range nodes {
+			select {
+			case jobs <- idx:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// 收集测速结果, ctx取消后等待正在测速的节点退出
+	done := 0
+	for idx := range results {
+		done++
+		if opts.Progress != nil {
+			opts.Progress(done, len(nodes), all[idx])
+		}
+	}
+	err = ctx.Err()
+
+	report = &LatencyReport{}
+	for idx, res := range all {
+		if res == nil {
+			res = &LatencyResult{Node: nodes[idx], Offline: true, Err: err}
+		}
+		if res.Offline {
+			report.Offline = append(report.Offline, res)
+		} else {
+			report.Online = append(report.Online, res)
+		}
+	}
+	sort.SliceStable(report.Online, func(i, j int) bool {
+		return report.Online[i].Latency < report.Online[j].Latency
+	})
+	return
+}
+
+// uniqueNodes 按指针及编号去重并跳过空节点, 避免多个测速协程同时更新同一个节点
+func uniqueNodes(nodes []*ProxyNodeInfo) (unique []*ProxyNodeInfo) {
+	seen := map[*ProxyNodeInfo]bool{}
+	seenId := map[string]bool{}
+	for _, pn := range nodes {
+		if pn == nil || seen[pn] || (pn.Id != "" && seenId[pn.Id]) {
+			continue
+		}
+		seen[pn] = true
+		seenId[pn.Id] = true
+		unique = append(unique, pn)
+	}
+	return
+}
+
+// testNodeLatency 对单个节点多次采样, 采样在节点副本上进行
+func (r *Router) testNodeLatency(ctx context.Context, pn *ProxyNodeInfo, samples int, timeout time.Duration) (res *LatencyResult) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res = &LatencyResult{Node: pn}
+	for i := 0; i < samples; i++ {
+		probe := *pn
+		if err := r.TestProxyNodeLatencyContext(ctx, &probe); err != nil {
+			res.Failed++
+			res.Err = err
+			if ctx.Err() != nil {
+				res.Failed += samples - i - 1
+				break
+			}
+			continue
+		}
+		if probe.Offline {
+			res.Failed++
+			res.Err = ErrProxyNodeOffline
+			continue
+		}
+		res.Samples = append(res.Samples, probe.Latency)
+	}
+
+	if len(res.Samples) == 0 {
+		res.Offline = true
+	} else {
+		total := 0
+		for _, latency := range res.Samples {
+			total += latency
+		}
+		res.Latency = (total + len(res.Samples)/2) / len(res.Samples)
+	}
+	pn.Latency = res.Latency
+	pn.Offline = res.Offline
+	return
+}
@@ -0,0 +1,97 @@
+package openwrt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRouter_TestAllProxyNodeLatency(t *testing.T) {
+	var inflight, peak int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == LoginPath {
+			w.Header().Set("Set-Cookie", "sysauth=abc; path=/cgi-bin/luci/")
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+
+		// 端口即延迟, 端口为0的节点离线
+		port := req.URL.Query().Get("port")
+		if port == "0" {
+			_, _ = w.Write([]byte(`{"ret":"0","used":3000}`))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"ret":"1","used":%s}`, port)
+	}))
+	defer srv.Close()
+
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://")})
+	var nodes []*ProxyNodeInfo
+	for i, port := range []string{"300", "0", "100", "200", "0", "150"} {
+		nodes = append(nodes, &ProxyNodeInfo{Id: fmt.Sprintf("cfg%d", i), Host: "example.com", Port: port})
+	}
+
+	// 重复的指针及编号只测速一次, 空节点被忽略
+	duplicated := append(append([]*ProxyNodeInfo{nil}, nodes...), nodes[0], nil, &ProxyNodeInfo{Id: "cfg2", Host: "example.com", Port: "100"})
+	var calls []int
+	report, err := r.TestAllProxyNodeLatency(context.Background(), duplicated, &LatencyOptions{
+		Concurrency: 2,
+		Samples:     3,
+		Progress: func(done, total int, res *LatencyResult) {
+			if total != len(nodes) {
+				t.Errorf("got total %d, want %d", total, len(nodes))
+			}
+			calls = append(calls, done)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != len(nodes) || calls[len(calls)-1] != len(nodes) {
+		t.Errorf("got progress %v", calls)
+	}
+	if peak > 2 {
+		t.Errorf("got %d concurrent requests, want at most 2", peak)
+	}
+
+	var got []int
+	for _, res := range report.Online {
+		if len(res.Samples) != 3 {
+			t.Errorf("got %d samples, want 3", len(res.Samples))
+		}
+		got = append(got, res.Latency)
+	}
+	if fmt.Sprint(got) != "[100 150 200 300]" {
+		t.Errorf("got online latencies %v", got)
+	}
+	if len(report.Offline) != 2 || !nodes[1].Offline || report.Offline[0].Failed != 3 {
+		t.Errorf("got offline %+v", report.Offline)
+	}
+}
+
+func TestRouter_TestAllProxyNodeLatencyCanceled(t *testing.T) {
+	r := NewRouterInstance(&RouterConfig{Addr: "127.0.0.1:1"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	nodes := []*ProxyNodeInfo{{Host: "example.com", Port: "1"}, {Host: "example.com", Port: "2"}}
+	report, err := r.TestAllProxyNodeLatency(ctx, nodes, nil)
+	if err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if len(report.Online) != 0 || len(report.Offline) != 2 {
+		t.Errorf("got %d online %d offline", len(report.Online), len(report.Offline))
+	}
+}
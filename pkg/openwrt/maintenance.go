@@ -0,0 +1,158 @@
+package openwrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+const (
+	DefaultHealthTimeout  = 5 * time.Minute // 默认等待恢复的最长时间
+	DefaultHealthInterval = 3 * time.Second // 默认健康检查间隔
+	DefaultHealthHoldoff  = 2 * time.Second // 默认触发操作后开始检查前的等待时间
+)
+
+var ErrHealthTimeout = errors.New("router did not become healthy before timeout") // 超时仍未恢复
+
+// serviceNamePattern 允许的服务名称, 避免拼接到命令中产生注入
+var serviceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// HealthOptions 等待路由器恢复的参数
+type HealthOptions struct {
+	Timeout    time.Duration // 从触发操作开始计算的最长等待时间, 为空时使用 DefaultHealthTimeout
+	Interval   time.Duration // 健康检查间隔, 为空时使用 DefaultHealthInterval
+	Holdoff    time.Duration // 触发操作后开始检查前的等待时间, 为空时使用 DefaultHealthHoldoff
+	ProxyCheck bool          // 除登录外还要求全局节点测速通过
+}
+
+// withDefaults 填充默认值
+func (o *HealthOptions) withDefaults() *HealthOptions {
+	opts := HealthOptions{}
+	if o != nil {
+		opts = *o
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultHealthTimeout
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultHealthInterval
+	}
+	if opts.Holdoff <= 0 {
+		opts.Holdoff = DefaultHealthHoldoff
+	}
+	return &opts
+}
+
+// Reboot 重启路由器并等待恢复, 返回从触发重启到恢复的时长
+func (r *Router) Reboot(opts *HealthOptions) (outage time.Duration, err error) {
+	return r.RebootContext(context.Background(), opts)
+}
+
+// RebootContext 重启路由器并等待恢复, 返回从触发重启到恢复的时长;
+// 重新登录成功且系统运行时间短于已等待的时间才视为重启完成
+func (r *Router) RebootContext(ctx context.Context, opts *HealthOptions) (outage time.Duration, err error) {
+	start := time.Now()
+	if err = r.ubusCall(ctx, "system", "reboot", nil, nil); err != nil {
+		return
+	}
+	return r.waitHealthy(ctx, start, opts, true)
+}
+
+// RestartService 重启路由器上的服务并等待恢复, 返回从触发重启到恢复的时长
+func (r *Router) RestartService(name string, opts *HealthOptions) (outage time.Duration, err error) {
+	return r.RestartServiceContext(context.Background(), name, opts)
+}
+
+// RestartServiceContext 重启/etc/init.d下的服务并等待恢复, 返回从触发重启到恢复的时长;
+// 服务在后台重启, 重启network等服务导致连接中断时不会返回错误
+func (r *Router) RestartServiceContext(ctx context.Context, name string, opts *HealthOptions) (outage time.Duration, err error) {
+	if !serviceNamePattern.MatchString(name) {
+		return 0, fmt.Errorf("invalid service name %q", name)
+	}
+	script := "/etc/init.d/" + name
+	if err = r.ubusCall(ctx, "file", "stat", map[string]interface{}{"path": script}, nil); err != nil {
+		if isUbusNotFound(err) {
+			err = fmt.Errorf("service %s not found: %w", name, err)
+		}
+		return
+	}
+	start := time.Now()
+	if err = r.backgroundExec(ctx, script+" restart >/dev/null 2>&1 &"); err != nil {
+		return
+	}
+	return r.waitHealthy(ctx, start, opts, false)
+}
+
+// WaitHealthy 等待路由器恢复, 返回等待的时长
+func (r *Router) WaitHealthy(opts *HealthOptions) (outage time.Duration, err error) {
+	return r.WaitHealthyContext(context.Background(), opts)
+}
+
+// WaitHealthyContext 等待路由器恢复, 返回等待的时长; 用于手动修改配置或重启服务之后
+func (r *Router) WaitHealthyContext(ctx context.Context, opts *HealthOptions) (outage time.Duration, err error) {
+	return r.waitHealthy(ctx, time.Now(), opts, false)
+}
+
+// waitHealthy 从start开始轮询健康检查直到通过或超时, 账号或密码错误时立即返回
+func (r *Router) waitHealthy(ctx context.Context, start time.Time, opts *HealthOptions, rebooted bool) (outage time.Duration, err error) {
+	opts = opts.withDefaults()
+	deadline := start.Add(opts.Timeout)
+	wait := opts.Holdoff
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait = opts.Interval
+
+		checkErr := r.healthCheck(ctx, start, rebooted, opts.ProxyCheck)
+		if checkErr == nil {
+			return time.Since(start), nil
+		}
+		if errors.Is(checkErr, ErrAuthFailed) {
+			return 0, checkErr
+		}
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		if !time.Now().Add(wait).Before(deadline) {
+			return 0, fmt.Errorf("%w after %s: %v", ErrHealthTimeout, time.Since(start).Round(time.Second), checkErr)
+		}
+	}
+}
+
+// healthCheck 单次健康检查: 重新登录, 重启时检查运行时间, 可选全局节点测速
+func (r *Router) healthCheck(ctx context.Context, start time.Time, rebooted, proxyCheck bool) (err error) {
+	if err = r.LoginContext(ctx); err != nil {
+		return
+	}
+	if rebooted {
+		var info *SystemInfo
+		if info, err = r.GetSystemInfoContext(ctx); err != nil {
+			return
+		}
+		if info.Uptime >= time.Since(start) {
+			return fmt.Errorf("router has not rebooted yet, uptime %s", info.Uptime)
+		}
+	}
+	if !proxyCheck {
+		return
+	}
+	pn, err := r.GetGlobalProxyNodeContext(ctx)
+	if err != nil {
+		return
+	}
+	if pn == nil {
+		return errors.New("global proxy node not set")
+	}
+	if err = r.TestProxyNodeLatencyContext(ctx, pn); err != nil {
+		return
+	}
+	if pn.Offline {
+		err = fmt.Errorf("global proxy node %s is offline", pn.Name)
+	}
+	return
+}
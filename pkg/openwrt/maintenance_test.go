@@ -0,0 +1,95 @@
+package openwrt
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRouter_Reboot(t *testing.T) {
+	var mu sync.Mutex
+	var rebootAt time.Time
+	srv := newUbusTestServer(t, func(object, method string, args map[string]interface{}) []interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		rebooting := !rebootAt.IsZero() && time.Since(rebootAt) < 30*time.Millisecond
+		if rebooting && object == "session" {
+			// 重启期间连接被断开
+			panic(http.ErrAbortHandler)
+		}
+		switch object + "." + method {
+		case "session.login":
+			return []interface{}{0, map[string]interface{}{"ubus_rpc_session": "test-session"}}
+		case "system.reboot":
+			rebootAt = time.Now()
+			return []interface{}{0}
+		case "system.board":
+			return []interface{}{0, map[string]interface{}{"hostname": "OpenWrt"}}
+		case "system.info":
+			uptime := 3600
+			if !rebootAt.IsZero() && !rebooting {
+				uptime = 0
+			}
+			return []interface{}{0, map[string]interface{}{"uptime": uptime, "root": map[string]interface{}{}}}
+		}
+		return []interface{}{3}
+	})
+	defer srv.Close()
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+
+	outage, err := r.Reboot(&HealthOptions{Timeout: time.Second, Interval: 5 * time.Millisecond, Holdoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outage < 30*time.Millisecond {
+		t.Errorf("got outage %s, want at least 30ms", outage)
+	}
+}
+
+func TestRouter_RestartService(t *testing.T) {
+	var scripts []string
+	loginFails := false
+	srv := newUbusTestServer(t, func(object, method string, args map[string]interface{}) []interface{} {
+		switch object + "." + method {
+		case "session.login":
+			if loginFails {
+				return []interface{}{3}
+			}
+			return []interface{}{0, map[string]interface{}{"ubus_rpc_session": "test-session"}}
+		case "file.stat":
+			if args["path"] != "/etc/init.d/vssr" {
+				return []interface{}{4}
+			}
+			return []interface{}{0, map[string]interface{}{"type": "file"}}
+		case "file.exec":
+			params, _ := args["params"].([]interface{})
+			scripts = append(scripts, params[1].(string))
+			return []interface{}{0, map[string]interface{}{"code": 0}}
+		}
+		return []interface{}{3}
+	})
+	defer srv.Close()
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+	opts := &HealthOptions{Timeout: 50 * time.Millisecond, Interval: 5 * time.Millisecond, Holdoff: time.Millisecond}
+
+	if _, err := r.RestartService("vssr", opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) != 1 || scripts[0] != "/etc/init.d/vssr restart >/dev/null 2>&1 &" {
+		t.Errorf("got scripts %q", scripts)
+	}
+	if _, err := r.RestartService("dnsmasq", opts); !isUbusNotFound(err) {
+		t.Errorf("got %v, want not found", err)
+	}
+	if _, err := r.RestartService("vssr; reboot", opts); err == nil {
+		t.Error("expected invalid service name error")
+	}
+
+	loginFails = true
+	if _, err := r.RestartService("vssr", opts); !errors.Is(err, ErrHealthTimeout) {
+		t.Errorf("got %v, want ErrHealthTimeout", err)
+	}
+}
@@ -0,0 +1,143 @@
+package openwrt
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const netDevPath = "/proc/net/dev" // 网络设备流量统计
+
+// Interface 逻辑网络接口(如 wan/lan)的运行状态
+type Interface struct {
+	Name      string        // 接口名称, 如 wan
+	Protocol  string        // 协议, 如 dhcp/pppoe/static
+	Device    string        // 三层设备名称, 如 pppoe-wan, 未启动时为配置的设备
+	Up        bool          // 是否已启动
+	Uptime    time.Duration // 本次启动后的运行时间
+	IPv4      []string      // IPv4地址, CIDR格式
+	IPv6      []string      // IPv6地址, CIDR格式
+	Gateway   string        // IPv4默认网关
+	DNS       []string      // DNS服务器
+	RxBytes   uint64        // 设备接收字节数
+	TxBytes   uint64        // 设备发送字节数
+	RxPackets uint64        // 设备接收包数
+	TxPackets uint64        // 设备发送包数
+}
+
+// ubusInterfaceAddress network.interface中的地址
+type ubusInterfaceAddress struct {
+	Address string `json:"address"`
+	Mask    int    `json:"mask"`
+}
+
+// netDevCounters 网络设备的流量计数
+type netDevCounters struct {
+	rxBytes, rxPackets, txBytes, txPackets uint64
+}
+
+// ListInterfaces 列出逻辑网络接口的运行状态
+func (r *Router) ListInterfaces() (ifaces []*Interface, err error) {
+	return r.ListInterfacesContext(context.Background())
+}
+
+// ListInterfacesContext 列出逻辑网络接口的运行状态, 流量计数读取自三层设备, 不包含loopback
+func (r *Router) ListInterfacesContext(ctx context.Context) (ifaces []*Interface, err error) {
+	dump := &struct {
+		Interface []struct {
+			Interface   string                 `json:"interface"`
+			Up          bool                   `json:"up"`
+			Uptime      int64                  `json:"uptime"`
+			Proto       string                 `json:"proto"`
+			Device      string                 `json:"device"`
+			L3Device    string                 `json:"l3_device"`
+			IPv4Address []ubusInterfaceAddress `json:"ipv4-address"`
+			IPv6Address []ubusInterfaceAddress `json:"ipv6-address"`
+			Route       []struct {
+				Target  string `json:"target"`
+				Mask    int    `json:"mask"`
+				Nexthop string `json:"nexthop"`
+			} `json:"route"`
+			DNSServer []string `json:"dns-server"`
+		} `json:"interface"`
+	}{}
+	if err = r.ubusCall(ctx, "network.interface", "dump", nil, dump); err != nil {
+		return
+	}
+	data, err := r.fileRead(ctx, netDevPath)
+	if err != nil {
+		return
+	}
+	counters, err := parseNetDev(data)
+	if err != nil {
+		return
+	}
+
+	for _, item := range dump.Interface {
+		if item.Interface == "loopback" {
+			continue
+		}
+		iface := &Interface{
+			Name:     item.Interface,
+			Protocol: item.Proto,
+			Device:   item.L3Device,
+			Up:       item.Up,
+			Uptime:   time.Duration(item.Uptime) * time.Second,
+			DNS:      item.DNSServer,
+		}
+		if iface.Device == "" {
+			iface.Device = item.Device
+		}
+		for _, addr := range item.IPv4Address {
+			iface.IPv4 = append(iface.IPv4, fmt.Sprintf("%s/%d", addr.Address, addr.Mask))
+		}
+		for _, addr := range item.IPv6Address {
+			iface.IPv6 = append(iface.IPv6, fmt.Sprintf("%s/%d", addr.Address, addr.Mask))
+		}
+		for _, route := range item.Route {
+			if route.Target == "0.0.0.0" && route.Mask == 0 {
+				iface.Gateway = route.Nexthop
+				break
+			}
+		}
+		if c, ok := counters[iface.Device]; ok {
+			iface.RxBytes, iface.RxPackets = c.rxBytes, c.rxPackets
+			iface.TxBytes, iface.TxPackets = c.txBytes, c.txPackets
+		}
+		ifaces = append(ifaces, iface)
+	}
+	return
+}
+
+// parseNetDev 解析/proc/net/dev, 前两行为表头
+func parseNetDev(data string) (counters map[string]*netDevCounters, err error) {
+	counters = map[string]*netDevCounters{}
+	lines := strings.Split(data, "\n")
+	if len(lines) < 2 {
+		return
+	}
+	for _, line := range lines[2:] {
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			continue
+		}
+		// 接收: bytes packets errs drop fifo frame compressed multicast, 发送: bytes packets ...
+		fields := strings.Fields(line[idx+1:])
+		if len(fields) < 10 {
+			return nil, fmt.Errorf("unexpected %s line %q", netDevPath, line)
+		}
+		c := &netDevCounters{}
+		for _, v := range []struct {
+			dst   *uint64
+			field string
+		}{{&c.rxBytes, fields[0]}, {&c.rxPackets, fields[1]}, {&c.txBytes, fields[8]}, {&c.txPackets, fields[9]}} {
+			if *v.dst, err = strconv.ParseUint(v.field, 10, 64); err != nil {
+				return nil, fmt.Errorf("unexpected %s line %q", netDevPath, line)
+			}
+		}
+		counters[strings.TrimSpace(line[:idx])] = c
+	}
+	return
+}
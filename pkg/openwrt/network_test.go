@@ -0,0 +1,60 @@
+package openwrt
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouter_ListInterfaces(t *testing.T) {
+	srv := newUbusTestServer(t, func(object, method string, args map[string]interface{}) []interface{} {
+		switch object + "." + method {
+		case "session.login":
+			return []interface{}{0, map[string]interface{}{"ubus_rpc_session": "test-session"}}
+		case "network.interface.dump":
+			return []interface{}{0, map[string]interface{}{"interface": []interface{}{
+				map[string]interface{}{"interface": "lan", "up": true, "uptime": 3600, "proto": "static", "device": "br-lan", "l3_device": "br-lan",
+					"ipv4-address": []interface{}{map[string]interface{}{"address": "192.168.1.1", "mask": 24}}},
+				map[string]interface{}{"interface": "loopback", "up": true, "proto": "static", "l3_device": "lo"},
+				map[string]interface{}{"interface": "wan", "up": true, "uptime": 120, "proto": "pppoe", "device": "eth1", "l3_device": "pppoe-wan",
+					"ipv4-address": []interface{}{map[string]interface{}{"address": "100.64.1.2", "mask": 32}},
+					"route": []interface{}{
+						map[string]interface{}{"target": "100.64.0.1", "mask": 32, "nexthop": "0.0.0.0"},
+						map[string]interface{}{"target": "0.0.0.0", "mask": 0, "nexthop": "100.64.0.1"},
+					},
+					"dns-server": []string{"223.5.5.5", "119.29.29.29"}},
+				map[string]interface{}{"interface": "wwan", "up": false, "proto": "dhcp", "device": "phy1-sta0"},
+			}}}
+		case "file.read":
+			if args["path"] == "/proc/net/dev" {
+				return []interface{}{0, map[string]interface{}{"data": "Inter-|   Receive                                                |  Transmit\n" +
+					" face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed\n" +
+					"    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0\n" +
+					"br-lan: 5000000    4000    0    0    0     0          0         0 90000000   70000    0    0    0     0       0          0\n" +
+					"pppoe-wan: 80000000   60000    0    0    0     0          0         0  4000000    3000    0    0    0     0       0          0\n"}}
+			}
+			return []interface{}{4}
+		}
+		return []interface{}{3}
+	})
+	defer srv.Close()
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+
+	ifaces, err := r.ListInterfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ifaces) != 3 {
+		t.Fatalf("got %d interfaces, want 3", len(ifaces))
+	}
+	lan, wan, wwan := ifaces[0], ifaces[1], ifaces[2]
+	if lan.IPv4[0] != "192.168.1.1/24" || lan.RxBytes != 5000000 || lan.TxPackets != 70000 || lan.Gateway != "" {
+		t.Errorf("got lan %+v", lan)
+	}
+	if wan.Device != "pppoe-wan" || wan.Gateway != "100.64.0.1" || len(wan.DNS) != 2 || wan.Uptime != 2*time.Minute || wan.RxBytes != 80000000 || wan.TxBytes != 4000000 {
+		t.Errorf("got wan %+v", wan)
+	}
+	if wwan.Up || wwan.Device != "phy1-sta0" || wwan.RxBytes != 0 {
+		t.Errorf("got wwan %+v", wwan)
+	}
+}
@@ -1,30 +1,70 @@
 package openwrt
 
 import (
+	"bytes"
+	"context"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Router struct {
 	addr          string
+	scheme        string
 	username      string
 	password      string
-	timeout       time.Duration
+	backend       Backend
+	recorder      LatencyRecorder
+	client        *http.Client
+	loginClient   *http.Client
+	mu            sync.Mutex // 保护登录凭证
+	loginMu       sync.Mutex // 串行化登录, 避免并发请求同时重新登录
 	cookieSysAuth string
+	ubusSession   string
 }
 
 type RouterConfig struct {
 	Addr     string
 	Username string
 	Password string
+	Backend  Backend // 接口后端, 为空时使用 BackendLuCI
+
+	Timeout    time.Duration     // 单次请求超时时间, 为空时使用 DefaultTimeout
+	HTTPClient *http.Client      // 自定义HTTP客户端, 其重定向策略会被替换
+	Transport  http.RoundTripper // 自定义传输层, 仅在HTTPClient为空或未设置传输层时使用
+
+	// 以下TLS选项只作用于内置的传输层: HTTPClient设置了传输层或设置了Transport时,
+	// RootCAs, ServerName 及 PinnedCertSHA256 不生效, HTTPS 只决定请求地址的协议, 证书校验由自定义传输层负责
+	HTTPS            bool           // 通过HTTPS访问uhttpd
+	RootCAs          *x509.CertPool // 校验路由器证书的CA, 为空时使用系统CA
+	ServerName       string         // 校验证书时使用的主机名, 为空时使用Addr中的主机
+	PinnedCertSHA256 []string       // 固定的证书SHA-256指纹, 设置后只校验指纹而不校验证书链
+
+	LatencyRecorder LatencyRecorder // 节点测速结果记录器, 为空时不记录
+}
+
+// LatencyRecorder 节点测速结果记录器
+type LatencyRecorder interface {
+	RecordLatency(pn *ProxyNodeInfo, at time.Time) error
 }
 
+// Backend 路由器接口后端
+type Backend string
+
+const (
+	BackendLuCI Backend = "luci" // 解析LuCI页面及vssr接口
+	BackendUbus Backend = "ubus" // 调用ubus JSON-RPC接口, 需要rpcd为登录用户授予vssr配置及file.exec权限
+)
+
 type ProxyNodeInfo struct {
 	Name    string // 代理名称
 	Id      string // 代理编号
@@ -34,6 +74,11 @@ type ProxyNodeInfo struct {
 	Offline bool   // 下线状态
 }
 
+var (
+	ErrSessionExpired = errors.New("session expired")       // 会话过期且重新登录后仍无法访问
+	ErrAuthFailed     = errors.New("authentication failed") // 账号或密码错误
+)
+
 const (
 	LoginPath                  = "/cgi-bin/luci/"                              // 登录路径
 	ListAllProxyNodeInfoPath   = "/cgi-bin/luci/admin/services/vssr/servers"   // 列出所有代理服务器节点信息
@@ -42,56 +87,83 @@ const (
 	ApplyProxyNodeToGlobalPath = "/cgi-bin/luci/admin/services/vssr/change"    // 应用节点配置为全局代理
 )
 
-// NewRouterInstance 获取路由实例
+// NewRouterInstance 获取路由实例, 同一实例的所有请求共享一个长连接客户端
 func NewRouterInstance(conf *RouterConfig) (r *Router) {
 	r = &Router{
 		addr:     conf.Addr,
+		scheme:   "http",
 		username: conf.Username,
 		password: conf.Password,
+		backend:  conf.Backend,
+		recorder: conf.LatencyRecorder,
+	}
+	if r.backend == "" {
+		r.backend = BackendLuCI
+	}
+	if conf.HTTPS {
+		r.scheme = "https"
 	}
+	r.client, r.loginClient = newHTTPClients(conf)
 	return
 }
 
+// url 拼接路由器接口地址
+func (r *Router) url(path string) string {
+	return fmt.Sprintf("%s://%s%s", r.scheme, r.addr, path)
+}
+
 // Login 登录接口
 func (r *Router) Login() (err error) {
+	return r.LoginContext(context.Background())
+}
+
+// LoginContext 登录接口
+func (r *Router) LoginContext(ctx context.Context) (err error) {
+	if r.backend == BackendUbus {
+		return r.ubusLogin(ctx)
+	}
+	return r.luciLogin(ctx)
+}
+
+// luciLogin 通过LuCI登录页面获取sysauth
+func (r *Router) luciLogin(ctx context.Context) (err error) {
 	// 构建请求
-	path := fmt.Sprintf("http://%s%s", r.addr, LoginPath)
-	req, err := http.NewRequest(http.MethodPost, path, strings.NewReader(fmt.Sprintf(`luci_username=%s&luci_password=%s`, r.username, r.password)))
+	form := url.Values{}
+	form.Set("luci_username", r.username)
+	form.Set("luci_password", r.password)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url(LoginPath), strings.NewReader(form.Encode()))
 	if err != nil {
 		return
 	}
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
 	// 服务请求
-	client := http.Client{
-		Timeout: r.timeout,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return errors.New("break")
-		},
-	}
 	var rsp *http.Response
-	rsp, err = client.Do(req)
+	rsp, err = r.loginClient.Do(req)
 	if err != nil {
-		if !strings.Contains(err.Error(), "break") {
-			return
-		}
-		err = nil
-
-		if rsp == nil || rsp.StatusCode != 302 {
-			err = errors.New("get cookie err, rsp is nil or status code not equal 302")
-			return
-		}
+		return
 	}
 	defer func() {
-		_ = rsp.Close
+		_ = rsp.Body.Close()
 	}()
 
+	// 登录成功时重定向, 账号密码错误时重新返回登录页面
+	switch rsp.StatusCode {
+	case http.StatusFound:
+	case http.StatusOK, http.StatusForbidden:
+		err = fmt.Errorf("%w: login page returned status code %d", ErrAuthFailed, rsp.StatusCode)
+		return
+	default:
+		err = fmt.Errorf("get cookie err, status code %d not equal 302", rsp.StatusCode)
+		return
+	}
+
 	// 序列化响应结果
 	cookie := rsp.Header.Get("Set-Cookie")
 	items := strings.Split(cookie, ";")
 	for _, item := range items {
 		if strings.Contains(item, "sysauth") {
-			r.cookieSysAuth = item
+			r.setCredential(&r.cookieSysAuth, item)
 			return
 		}
 	}
@@ -100,37 +172,140 @@ func (r *Router) Login() (err error) {
 	return
 }
 
-// ListAllProxyNodeInfo 列出所有代理节点信息
-func (r *Router) ListAllProxyNodeInfo() (pns []*ProxyNodeInfo, err error) {
-	if r.cookieSysAuth == "" {
-		if err = r.Login(); err != nil {
+// luciRequest 携带登录凭证请求LuCI接口, GET请求的参数放在查询字符串中, POST请求的参数以表单提交;
+// 会话过期时重新登录一次并重放请求
+func (r *Router) luciRequest(ctx context.Context, method, path string, params url.Values) (body []byte, err error) {
+	cookie := r.credential(&r.cookieSysAuth)
+	if cookie == "" {
+		if cookie, err = r.renewCredential(ctx, &r.cookieSysAuth, "", r.luciLogin); err != nil {
 			return
 		}
 	}
 
+	var expired bool
+	if body, expired, err = r.doLuCIRequest(ctx, cookie, method, path, params); err != nil || !expired {
+		return
+	}
+
+	// 会话过期, 重新登录后重放请求
+	if cookie, err = r.renewCredential(ctx, &r.cookieSysAuth, cookie, r.luciLogin); err != nil {
+		if !errors.Is(err, ErrAuthFailed) {
+			err = fmt.Errorf("%w: %v", ErrSessionExpired, err)
+		}
+		return
+	}
+	if body, expired, err = r.doLuCIRequest(ctx, cookie, method, path, params); err != nil {
+		return
+	}
+	if expired {
+		err = ErrSessionExpired
+	}
+	return
+}
+
+// credential 读取登录凭证
+func (r *Router) credential(field *string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return *field
+}
+
+// setCredential 保存登录凭证
+func (r *Router) setCredential(field *string, credential string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	*field = credential
+}
+
+// renewCredential 登录并返回新的凭证; 其他请求已经替换掉过期凭证stale时直接复用, 避免并发请求重复登录
+func (r *Router) renewCredential(ctx context.Context, field *string, stale string, login func(ctx context.Context) error) (credential string, err error) {
+	r.loginMu.Lock()
+	defer r.loginMu.Unlock()
+	if credential = r.credential(field); credential != "" && credential != stale {
+		return
+	}
+	if err = login(ctx); err != nil {
+		return
+	}
+	credential = r.credential(field)
+	return
+}
+
+// doLuCIRequest 执行一次LuCI请求, 并判断响应是否为会话过期
+func (r *Router) doLuCIRequest(ctx context.Context, cookie, method, path string, params url.Values) (body []byte, expired bool, err error) {
 	// 构建请求
-	path := fmt.Sprintf("http://%s%s", r.addr, ListAllProxyNodeInfoPath)
-	req, err := http.NewRequest(http.MethodGet, path, nil)
+	var reader io.Reader
+	path = r.url(path)
+	if method == http.MethodGet {
+		if len(params) != 0 {
+			path = path + "?" + params.Encode()
+		}
+	} else {
+		reader = strings.NewReader(params.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, path, reader)
 	if err != nil {
 		return
 	}
-	req.Header.Add("Cookie", r.cookieSysAuth)
-
-	// 服务请求
-	client := http.Client{
-		Timeout: r.timeout,
+	if reader != nil {
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	}
+	req.Header.Add("Cookie", cookie)
+
+	// 服务请求, 重定向到登录页面时停止跟随
 	var rsp *http.Response
-	rsp, err = client.Do(req)
+	rsp, err = r.client.Do(req)
 	if err != nil {
 		return
 	}
 	defer func() {
-		_ = rsp.Close
+		_ = rsp.Body.Close()
 	}()
 
+	if body, err = ioutil.ReadAll(rsp.Body); err != nil {
+		return
+	}
+	expired = isSessionExpired(rsp, body)
+	return
+}
+
+// isSessionExpired 判断LuCI响应是否为会话过期: 返回403, 重定向到登录路径或返回登录表单
+func isSessionExpired(rsp *http.Response, body []byte) bool {
+	if rsp.StatusCode == http.StatusForbidden {
+		return true
+	}
+	if rsp.StatusCode >= 300 && rsp.StatusCode < 400 {
+		if loc, err := rsp.Location(); err == nil && isLoginPath(loc.Path) {
+			return true
+		}
+	}
+	return bytes.Contains(body, []byte(`name="luci_password"`))
+}
+
+// isLoginPath 判断是否为LuCI登录路径
+func isLoginPath(path string) bool {
+	return strings.TrimSuffix(path, "/") == strings.TrimSuffix(LoginPath, "/")
+}
+
+// ListAllProxyNodeInfo 列出所有代理节点信息
+func (r *Router) ListAllProxyNodeInfo() (pns []*ProxyNodeInfo, err error) {
+	return r.ListAllProxyNodeInfoContext(context.Background())
+}
+
+// ListAllProxyNodeInfoContext 列出所有代理节点信息
+func (r *Router) ListAllProxyNodeInfoContext(ctx context.Context) (pns []*ProxyNodeInfo, err error) {
+	if r.backend == BackendUbus {
+		return r.ubusListAllProxyNodeInfo(ctx)
+	}
+
+	// 服务请求
+	body, err := r.luciRequest(ctx, http.MethodGet, ListAllProxyNodeInfoPath, nil)
+	if err != nil {
+		return
+	}
+
 	// 解析html
-	doc, err := goquery.NewDocumentFromReader(rsp.Body)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
 	if err != nil {
 		return
 	}
@@ -171,38 +346,34 @@ func (r *Router) ListAllProxyNodeInfo() (pns []*ProxyNodeInfo, err error) {
 
 // TestProxyNodeLatency 测试代理节点延迟
 func (r *Router) TestProxyNodeLatency(pn *ProxyNodeInfo) (err error) {
-	if r.cookieSysAuth == "" {
-		if err = r.Login(); err != nil {
-			return
-		}
-	}
+	return r.TestProxyNodeLatencyContext(context.Background(), pn)
+}
 
-	// 构建请求
-	path := fmt.Sprintf("http://%s%s", r.addr, TestProxyNodeLatencyPath+fmt.Sprintf("?host=%s&port=%s", pn.Host, pn.Port))
-	req, err := http.NewRequest(http.MethodGet, path, nil)
-	if err != nil {
+// TestProxyNodeLatencyContext 测试代理节点延迟, 配置了记录器时同时记录测速结果
+func (r *Router) TestProxyNodeLatencyContext(ctx context.Context, pn *ProxyNodeInfo) (err error) {
+	if r.backend == BackendUbus {
+		err = r.ubusTestProxyNodeLatency(ctx, pn)
+	} else {
+		err = r.luciTestProxyNodeLatency(ctx, pn)
+	}
+	if err != nil || r.recorder == nil {
 		return
 	}
-	req.Header.Add("Cookie", r.cookieSysAuth)
+	return r.recorder.RecordLatency(pn, time.Now())
+}
 
+// luciTestProxyNodeLatency 通过vssr的checkport接口测试代理节点延迟
+func (r *Router) luciTestProxyNodeLatency(ctx context.Context, pn *ProxyNodeInfo) (err error) {
 	// 服务请求
-	client := http.Client{
-		Timeout: r.timeout,
-	}
-	var rsp *http.Response
-	rsp, err = client.Do(req)
+	params := url.Values{}
+	params.Set("host", pn.Host)
+	params.Set("port", pn.Port)
+	body, err := r.luciRequest(ctx, http.MethodGet, TestProxyNodeLatencyPath, params)
 	if err != nil {
 		return
 	}
-	defer func() {
-		_ = rsp.Close
-	}()
 
 	// 序列化数据
-	body, err := ioutil.ReadAll(rsp.Body)
-	if err != nil {
-		return
-	}
 	data := &struct {
 		Ret  string `json:"ret"`
 		Used int    `json:"used"`
@@ -218,10 +389,13 @@ func (r *Router) TestProxyNodeLatency(pn *ProxyNodeInfo) (err error) {
 
 // ApplyProxyNodeToGlobal 应用代理节点到全局
 func (r *Router) ApplyProxyNodeToGlobal(p *ProxyNodeInfo) (err error) {
-	if r.cookieSysAuth == "" {
-		if err = r.Login(); err != nil {
-			return
-		}
+	return r.ApplyProxyNodeToGlobalContext(context.Background(), p)
+}
+
+// ApplyProxyNodeToGlobalContext 应用代理节点到全局
+func (r *Router) ApplyProxyNodeToGlobalContext(ctx context.Context, p *ProxyNodeInfo) (err error) {
+	if r.backend == BackendUbus {
+		return r.ubusApplyProxyNodeToGlobal(ctx, p)
 	}
 
 	// 获取节点编号
@@ -232,32 +406,16 @@ func (r *Router) ApplyProxyNodeToGlobal(p *ProxyNodeInfo) (err error) {
 		id = p.Id
 	}
 
-	// 准备请求
-	path := fmt.Sprintf("http://%s%s", r.addr, ApplyProxyNodeToGlobalPath+fmt.Sprintf("?server=global&set=%s", id))
-	req, err := http.NewRequest(http.MethodGet, path, nil)
-	if err != nil {
-		return
-	}
-	req.Header.Add("Cookie", r.cookieSysAuth)
-
 	// 接口请求
-	client := http.Client{
-		Timeout: r.timeout,
-	}
-	var rsp *http.Response
-	rsp, err = client.Do(req)
+	params := url.Values{}
+	params.Set("server", "global")
+	params.Set("set", id)
+	body, err := r.luciRequest(ctx, http.MethodGet, ApplyProxyNodeToGlobalPath, params)
 	if err != nil {
 		return
 	}
-	defer func() {
-		_ = rsp.Close
-	}()
 
 	// 序列化数据
-	body, err := ioutil.ReadAll(rsp.Body)
-	if err != nil {
-		return
-	}
 	data := &struct {
 		Status bool   `json:"status,omitempty"`
 		Sid    string `json:"sid,omitempty"`
@@ -272,65 +430,15 @@ func (r *Router) ApplyProxyNodeToGlobal(p *ProxyNodeInfo) (err error) {
 	return
 }
 
-// UpdateSubscribeInfo 更新订阅信息
+// UpdateSubscribeInfo 更新订阅信息, 其余订阅设置使用 DefaultSubscribeOptions
 func (r *Router) UpdateSubscribeInfo(urls ...string) (err error) {
-	if r.cookieSysAuth == "" {
-		if err = r.Login(); err != nil {
-			return
-		}
-	}
+	return r.UpdateSubscribeInfoContext(context.Background(), urls...)
+}
 
-	// 构建订阅列表
+// UpdateSubscribeInfoContext 更新订阅信息, 其余订阅设置使用 DefaultSubscribeOptions
+func (r *Router) UpdateSubscribeInfoContext(ctx context.Context, urls ...string) (err error) {
 	if len(urls) == 0 {
-		return err
-	}
-	var s string
-	for idx, url := range urls {
-		s = fmt.Sprintf(`"%s"`, url)
-		if idx != len(urls)-1 {
-			s = s + `,`
-		}
-	}
-	s = fmt.Sprintf(`[%s]`, s)
-
-	// 构建请求
-	path := fmt.Sprintf("http://%s%s", r.addr, UpdateSubscribePath)
-	req, err := http.NewRequest(http.MethodPost, path, strings.NewReader(fmt.Sprintf(`auto_update=1&auto_update_time=2&subscribe_url=%s&proxy=0&filter_words=过期时间/剩余流量`, s)))
-	if err != nil {
 		return
 	}
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Add("Cookie", r.cookieSysAuth)
-
-	// 服务请求
-	client := http.Client{
-		Timeout: r.timeout,
-	}
-	var rsp *http.Response
-	rsp, err = client.Do(req)
-	if err != nil {
-		return
-	}
-	defer func() {
-		_ = rsp.Close
-	}()
-
-	// 序列化数据
-	body, err := ioutil.ReadAll(rsp.Body)
-	if err != nil {
-		return
-	}
-	data := &struct {
-		Error int `json:"error"`
-	}{}
-	err = json.Unmarshal(body, data)
-	if err != nil {
-		return
-	}
-	if data.Error != 0 {
-		err = fmt.Errorf("error not equal zero %d", data.Error)
-		return
-	}
-
-	return
+	return r.UpdateSubscribeOptionsContext(ctx, DefaultSubscribeOptions(urls...))
 }
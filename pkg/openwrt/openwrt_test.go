@@ -1,65 +1,198 @@
-package openwrt
+package openwrt_test
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"github.com/huge-kumo/net-utils/pkg/openwrt/openwrttest"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestRouter(t *testing.T) (srv *openwrttest.Server, r *openwrt.Router) {
+	srv = openwrttest.NewServer(
+		&openwrt.ProxyNodeInfo{Id: "cfg01", Name: "香港01", Host: "hk.example.com", Port: "8388", Latency: 30},
+		&openwrt.ProxyNodeInfo{Id: "cfg02", Name: "日本01", Host: "jp.example.com", Port: "443", Latency: 80},
+		&openwrt.ProxyNodeInfo{Id: "cfg03", Name: "美国01", Host: "us.example.com", Port: "443", Offline: true},
+	)
+	t.Cleanup(srv.Close)
+	r = openwrt.NewRouterInstance(srv.RouterConfig())
+	return
+}
 
 func TestRouter_Login(t *testing.T) {
-	r := NewRouterInstance(&RouterConfig{
-		Addr:     "192.168.2.1:80",
-		Username: "root",
-		Password: "password",
-	})
+	srv, r := newTestRouter(t)
+	if err := r.Login(); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Logins() != 1 {
+		t.Errorf("got %d logins, want 1", srv.Logins())
+	}
 
-	err := r.Login()
-	if err != nil {
-		panic(err)
+	srv.SetCredential("root", "changed")
+	if err := r.Login(); !errors.Is(err, openwrt.ErrAuthFailed) {
+		t.Errorf("got %v, want ErrAuthFailed", err)
 	}
 }
 
 func TestRouter_ListAllProxyNodeInfo(t *testing.T) {
-	r := NewRouterInstance(&RouterConfig{
-		Addr:     "192.168.2.1:80",
-		Username: "root",
-		Password: "password",
-	})
-
-	_, err := r.ListAllProxyNodeInfo()
+	_, r := newTestRouter(t)
+	pns, err := r.ListAllProxyNodeInfo()
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	if len(pns) != 3 {
+		t.Fatalf("got %d nodes, want 3", len(pns))
+	}
+	want := openwrt.ProxyNodeInfo{Id: "cfg01", Name: "香港01", Host: "hk.example.com", Port: "8388"}
+	if *pns[0] != want {
+		t.Errorf("got %+v, want %+v", *pns[0], want)
 	}
 }
 
 func TestRouter_TestProxyNodeLatency(t *testing.T) {
-	r := NewRouterInstance(&RouterConfig{
-		Addr:     "192.168.2.1:80",
-		Username: "root",
-		Password: "password",
-	})
-
+	srv, r := newTestRouter(t)
 	list, err := r.ListAllProxyNodeInfo()
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+
+	if err = r.TestProxyNodeLatency(list[1]); err != nil {
+		t.Fatal(err)
+	}
+	if list[1].Offline || list[1].Latency != 80 {
+		t.Errorf("got %+v, want online 80ms", *list[1])
+	}
+	if err = r.TestProxyNodeLatency(list[2]); err != nil {
+		t.Fatal(err)
+	}
+	if !list[2].Offline {
+		t.Errorf("got %+v, want offline", *list[2])
 	}
 
-	err = r.TestProxyNodeLatency(list[0])
+	srv.SetLatency("cfg01", 0, true)
+	report, err := r.TestAllProxyNodeLatency(context.Background(), list, nil)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	if len(report.Online) != 1 || report.Online[0].Node.Id != "cfg02" {
+		t.Errorf("got online %v, want cfg02 only", report.Online)
 	}
 }
 
 func TestRouter_ApplyProxyNodeToGlobal(t *testing.T) {
-	r := NewRouterInstance(&RouterConfig{
-		Addr:     "192.168.2.1:80",
-		Username: "root",
-		Password: "password",
-	})
-
+	srv, r := newTestRouter(t)
 	list, err := r.ListAllProxyNodeInfo()
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
 
-	err = r.ApplyProxyNodeToGlobal(list[0])
+	if err = r.ApplyProxyNodeToGlobal(list[1]); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.ProxyServer("global"); got != "cfg02" {
+		t.Errorf("got global server %s, want cfg02", got)
+	}
+	pn, err := r.GetGlobalProxyNode()
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	if pn == nil || pn.Id != "cfg02" || pn.Host != "jp.example.com" {
+		t.Errorf("got global node %+v, want cfg02", pn)
+	}
+	status, err := r.GetProxyStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Enabled || !status.Running || status.Mode != "gfw" {
+		t.Errorf("got status %+v", *status)
+	}
+
+	if err = r.ApplyProxyNodeToGlobal(&openwrt.ProxyNodeInfo{Id: "missing"}); err == nil {
+		t.Error("expected error for unknown node")
+	}
+	if err = r.ApplyProxyNodeToGlobal(nil); err != nil {
+		t.Fatal(err)
+	}
+	if pn, err = r.GetGlobalProxyNode(); err != nil || pn != nil {
+		t.Errorf("got %+v %v, want no global node", pn, err)
+	}
+}
+
+func TestRouter_UpdateSubscribeInfo(t *testing.T) {
+	srv, r := newTestRouter(t)
+	if err := r.UpdateSubscribeInfo("https://a.example.com/sub?token=1&flag=ss"); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Subscriptions() != 1 {
+		t.Errorf("got %d subscriptions, want 1", srv.Subscriptions())
+	}
+	opts, err := r.GetSubscribeInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.URLs) != 1 || opts.URLs[0] != "https://a.example.com/sub?token=1&flag=ss" || opts.AutoUpdateHour != 2 {
+		t.Errorf("got %+v", *opts)
+	}
+}
+
+func TestRouter_SessionExpired(t *testing.T) {
+	modes := map[string]openwrttest.ExpireMode{
+		"forbidden":  openwrttest.ExpireForbidden,
+		"redirect":   openwrttest.ExpireRedirect,
+		"login page": openwrttest.ExpireLoginPage,
+	}
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			srv, r := newTestRouter(t)
+			if _, err := r.ListAllProxyNodeInfo(); err != nil {
+				t.Fatal(err)
+			}
+			srv.ExpireSession(mode)
+			if _, err := r.ListAllProxyNodeInfo(); err != nil {
+				t.Fatal(err)
+			}
+			if srv.Logins() != 2 {
+				t.Errorf("got %d logins, want 2", srv.Logins())
+			}
+		})
+	}
+}
+
+func TestRouter_ServerFailure(t *testing.T) {
+	srv, r := newTestRouter(t)
+	srv.SetFailure(openwrt.TestProxyNodeLatencyPath, http.StatusInternalServerError)
+	err := r.TestProxyNodeLatency(&openwrt.ProxyNodeInfo{Host: "hk.example.com", Port: "8388"})
+	if err == nil {
+		t.Fatal("expected error from failing checkport")
+	}
+
+	srv.SetFailure(openwrt.TestProxyNodeLatencyPath, 0)
+	if err = r.TestProxyNodeLatency(&openwrt.ProxyNodeInfo{Host: "hk.example.com", Port: "8388"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRouter_WaitHealthyProxyCheck(t *testing.T) {
+	srv, r := newTestRouter(t)
+	opts := &openwrt.HealthOptions{Timeout: 50 * time.Millisecond, Interval: 5 * time.Millisecond, Holdoff: time.Millisecond, ProxyCheck: true}
+
+	// 未设置全局节点
+	if _, err := r.WaitHealthy(opts); !errors.Is(err, openwrt.ErrHealthTimeout) {
+		t.Errorf("got %v, want ErrHealthTimeout", err)
+	}
+
+	if err := r.ApplyProxyNodeToGlobal(&openwrt.ProxyNodeInfo{Id: "cfg01"}); err != nil {
+		t.Fatal(err)
+	}
+	srv.SetLatency("cfg01", 0, true)
+	if _, err := r.WaitHealthy(opts); !errors.Is(err, openwrt.ErrHealthTimeout) {
+		t.Errorf("got %v, want ErrHealthTimeout", err)
+	}
+
+	srv.SetLatency("cfg01", 30, false)
+	if _, err := r.WaitHealthy(opts); err != nil {
+		t.Fatal(err)
 	}
 }
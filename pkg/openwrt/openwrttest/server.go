@@ -0,0 +1,404 @@
+// Package openwrttest 提供模拟LuCI及vssr接口的测试服务器, 用于在没有真实路由器时测试 openwrt.Router
+package openwrttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultUsername = "root"     // 默认登录账号
+	DefaultPassword = "password" // 默认登录密码
+)
+
+// ExpireMode 会话过期时的响应方式
+type ExpireMode int
+
+const (
+	ExpireForbidden ExpireMode = iota // 返回403
+	ExpireRedirect                    // 重定向到登录页面
+	ExpireLoginPage                   // 返回200及登录表单
+)
+
+// Server 模拟路由器, 所有导出方法均可并发调用
+type Server struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	username      string
+	password      string
+	nodes         []*openwrt.ProxyNodeInfo
+	servers       map[string]string // 各用途使用的节点编号, 键为change接口的server参数
+	runMode       string
+	running       bool
+	subscribe     map[string]string
+	failures      map[string]int
+	expireMode    ExpireMode
+	session       string
+	logins        int
+	subscriptions int
+	requests      map[string]int
+}
+
+// NewServer 启动模拟路由器, 账号密码为 DefaultUsername/DefaultPassword, 调用方负责Close
+func NewServer(nodes ...*openwrt.ProxyNodeInfo) (s *Server) {
+	s = &Server{
+		username: DefaultUsername,
+		password: DefaultPassword,
+		servers:  map[string]string{"global": "nil", "udp_relay": "same"},
+		runMode:  "gfw",
+		subscribe: map[string]string{
+			"auto_update":      "1",
+			"auto_update_time": "2",
+			"proxy":            "0",
+			"subscribe_url":    "[]",
+			"filter_words":     "",
+			"save_words":       "",
+		},
+		failures: map[string]int{},
+		requests: map[string]int{},
+	}
+	s.SetNodes(nodes...)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return
+}
+
+// Addr 路由器地址, 可直接用作 openwrt.RouterConfig 的Addr
+func (s *Server) Addr() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// RouterConfig 使用正确账号密码访问模拟路由器的配置
+func (s *Server) RouterConfig() *openwrt.RouterConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &openwrt.RouterConfig{
+		Addr:     s.Addr(),
+		Username: s.username,
+		Password: s.password,
+	}
+}
+
+// SetCredential 修改登录账号密码
+func (s *Server) SetCredential(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.username, s.password = username, password
+}
+
+// SetNodes 替换节点列表, 节点的Latency和Offline决定checkport的测速结果
+func (s *Server) SetNodes(nodes ...*openwrt.ProxyNodeInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes = s.nodes[:0]
+	for _, pn := range nodes {
+		node := *pn
+		s.nodes = append(s.nodes, &node)
+	}
+}
+
+// SetLatency 修改节点的测速结果, 返回节点是否存在
+func (s *Server) SetLatency(id string, latency int, offline bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, pn := range s.nodes {
+		if pn.Id == id {
+			pn.Latency, pn.Offline = latency, offline
+			return true
+		}
+	}
+	return false
+}
+
+// SetFailure 使指定路径返回HTTP状态码, code为0时恢复正常
+func (s *Server) SetFailure(path string, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if code == 0 {
+		delete(s.failures, path)
+		return
+	}
+	s.failures[path] = code
+}
+
+// SetRunning 设置vssr进程是否运行
+func (s *Server) SetRunning(running bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = running
+}
+
+// ExpireSession 使当前会话过期, 之后携带旧凭证的请求按mode响应
+func (s *Server) ExpireSession(mode ExpireMode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.session = ""
+	s.expireMode = mode
+}
+
+// ProxyServer 返回指定用途当前使用的节点编号, 用途与change接口的server参数一致, 如global/udp_relay
+func (s *Server) ProxyServer(role string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.servers[role]
+}
+
+// SubscribeOption 返回最近一次提交的订阅设置选项
+func (s *Server) SubscribeOption(option string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subscribe[option]
+}
+
+// Logins 成功登录的次数
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// Subscriptions 触发订阅更新的次数
+func (s *Server) Subscriptions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subscriptions
+}
+
+// Requests 指定路径收到的已登录请求次数
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if strings.TrimSuffix(req.URL.Path, "/") == strings.TrimSuffix(openwrt.LoginPath, "/") {
+		s.serveLogin(w, req)
+		return
+	}
+
+	// 校验会话
+	if s.session == "" || req.Header.Get("Cookie") != s.session {
+		switch s.expireMode {
+		case ExpireRedirect:
+			http.Redirect(w, req, openwrt.LoginPath, http.StatusFound)
+		case ExpireLoginPage:
+			writeLoginPage(w, http.StatusOK)
+		default:
+			writeLoginPage(w, http.StatusForbidden)
+		}
+		return
+	}
+	s.requests[req.URL.Path]++
+	if code := s.failures[req.URL.Path]; code != 0 {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	switch req.URL.Path {
+	case openwrt.ListAllProxyNodeInfoPath:
+		s.serveServers(w)
+	case openwrt.TestProxyNodeLatencyPath:
+		s.serveCheckPort(w, req)
+	case openwrt.ApplyProxyNodeToGlobalPath:
+		s.serveChange(w, req)
+	case openwrt.UpdateSubscribePath:
+		s.serveSubscribe(w, req)
+	case openwrt.SubscriptionPagePath:
+		s.serveSubscriptionPage(w)
+	case openwrt.ClientPagePath:
+		s.serveClientPage(w)
+	case openwrt.RunStatusPath:
+		writeJSON(w, map[string]bool{"global": s.running, "game": false, "socks5": false, "pdnsd": s.running})
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// serveLogin 账号密码正确时签发新的sysauth并重定向
+func (s *Server) serveLogin(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeLoginPage(w, http.StatusOK)
+		return
+	}
+	if code := s.failures[openwrt.LoginPath]; code != 0 {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	if req.FormValue("luci_username") != s.username || req.FormValue("luci_password") != s.password {
+		writeLoginPage(w, http.StatusForbidden)
+		return
+	}
+	s.logins++
+	s.session = fmt.Sprintf("sysauth=%032x", s.logins)
+	w.Header().Set("Set-Cookie", s.session+"; path=/cgi-bin/luci/; HttpOnly")
+	http.Redirect(w, req, "/cgi-bin/luci/admin/status/overview", http.StatusFound)
+}
+
+// serveServers 节点列表页面, 结构与vssr的servers页面一致
+func (s *Server) serveServers(w http.ResponseWriter) {
+	b := &strings.Builder{}
+	b.WriteString(`<html><body><table class="cbi-section-table">`)
+	for _, pn := range s.nodes {
+		fmt.Fprintf(b, `<tr class="cbi-section-table-row" id="cbi-vssr-%[1]s" server="%[2]s" server_port="%[3]s">
+<td><div class="incon" data-setction="%[1]s"></div><span class="alias">
+  %[4]s
+</span></td></tr>
+`, html.EscapeString(pn.Id), html.EscapeString(pn.Host), html.EscapeString(pn.Port), html.EscapeString(pn.Name))
+	}
+	b.WriteString(`</table></body></html>`)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// serveCheckPort 按节点预设的延迟返回测速结果, 未知节点视为离线
+func (s *Server) serveCheckPort(w http.ResponseWriter, req *http.Request) {
+	host, port := req.URL.Query().Get("host"), req.URL.Query().Get("port")
+	ret, used := "0", 0
+	for _, pn := range s.nodes {
+		if pn.Host == host && pn.Port == port && !pn.Offline {
+			ret, used = "1", pn.Latency
+			break
+		}
+	}
+	writeJSON(w, map[string]interface{}{"ret": ret, "used": used})
+}
+
+// serveChange 切换节点, set为nil时关闭该用途
+func (s *Server) serveChange(w http.ResponseWriter, req *http.Request) {
+	server, id := req.URL.Query().Get("server"), req.URL.Query().Get("set")
+	if server == "" {
+		writeJSON(w, map[string]interface{}{"status": false, "sid": "missing server"})
+		return
+	}
+	if id != "nil" && !(server == "udp_relay" && id == "same") && s.node(id) == nil {
+		writeJSON(w, map[string]interface{}{"status": false, "sid": id})
+		return
+	}
+	s.servers[server] = id
+	if server == "global" {
+		s.running = id != "nil"
+	}
+	writeJSON(w, map[string]interface{}{"status": true, "sid": id})
+}
+
+// serveSubscribe 保存订阅设置并记录一次订阅更新
+func (s *Server) serveSubscribe(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		writeJSON(w, map[string]int{"error": 1})
+		return
+	}
+	var urls []string
+	if err := json.Unmarshal([]byte(req.PostForm.Get("subscribe_url")), &urls); err != nil || len(urls) == 0 {
+		writeJSON(w, map[string]int{"error": 1})
+		return
+	}
+	for option := range s.subscribe {
+		s.subscribe[option] = req.PostForm.Get(option)
+	}
+	s.subscriptions++
+	writeJSON(w, map[string]int{"error": 0})
+}
+
+// serveSubscriptionPage 订阅设置页面
+func (s *Server) serveSubscriptionPage(w http.ResponseWriter) {
+	var urls []string
+	_ = json.Unmarshal([]byte(s.subscribe["subscribe_url"]), &urls)
+	b := &strings.Builder{}
+	b.WriteString(`<form method="post">`)
+	writeCheckbox(b, "cfgsub", "auto_update", s.subscribe["auto_update"] == "1")
+	writeSelect(b, "cfgsub", "auto_update_time", s.subscribe["auto_update_time"], hours()...)
+	for _, u := range urls {
+		writeInput(b, "cfgsub", "subscribe_url", u)
+	}
+	writeCheckbox(b, "cfgsub", "proxy", s.subscribe["proxy"] == "1")
+	writeInput(b, "cfgsub", "filter_words", s.subscribe["filter_words"])
+	writeInput(b, "cfgsub", "save_words", s.subscribe["save_words"])
+	b.WriteString(`</form>`)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// serveClientPage 客户端设置页面, 包含各用途节点及运行模式
+func (s *Server) serveClientPage(w http.ResponseWriter) {
+	ids := []string{"nil"}
+	for _, pn := range s.nodes {
+		ids = append(ids, pn.Id)
+	}
+	b := &strings.Builder{}
+	b.WriteString(`<form method="post">`)
+	for _, role := range openwrt.ProxyRoles {
+		options := ids
+		if role == openwrt.RoleUDPRelay {
+			options = append([]string{"same"}, ids...)
+		}
+		selected := s.servers[string(role)]
+		if selected == "" {
+			selected = "nil"
+		}
+		writeSelect(b, "cfgglobal", string(role)+"_server", selected, options...)
+	}
+	writeSelect(b, "cfgglobal", "run_mode", s.runMode, "gfw", "router", "all", "oversea")
+	b.WriteString(`</form>`)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// node 按编号查找节点
+func (s *Server) node(id string) *openwrt.ProxyNodeInfo {
+	for _, pn := range s.nodes {
+		if pn.Id == id {
+			return pn
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeLoginPage(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte(`<form method="post"><input name="luci_username" /><input type="password" name="luci_password" /></form>`))
+}
+
+func writeInput(b *strings.Builder, section, option, value string) {
+	fmt.Fprintf(b, `<input type="text" name="cbid.vssr.%s.%s" value="%s" />`+"\n", section, option, html.EscapeString(value))
+}
+
+func writeCheckbox(b *strings.Builder, section, option string, checked bool) {
+	attr := ""
+	if checked {
+		attr = ` checked="checked"`
+	}
+	fmt.Fprintf(b, `<input type="checkbox" name="cbid.vssr.%s.%s" value="1"%s />`+"\n", section, option, attr)
+}
+
+func writeSelect(b *strings.Builder, section, option, selected string, values ...string) {
+	fmt.Fprintf(b, `<select name="cbid.vssr.%s.%s">`, section, option)
+	for _, v := range values {
+		attr := ""
+		if v == selected {
+			attr = ` selected="selected"`
+		}
+		fmt.Fprintf(b, `<option value="%s"%s>%s</option>`, html.EscapeString(v), attr, html.EscapeString(v))
+	}
+	b.WriteString("</select>\n")
+}
+
+func hours() (values []string) {
+	for i := 0; i < 24; i++ {
+		values = append(values, strconv.Itoa(i))
+	}
+	return
+}
@@ -0,0 +1,297 @@
+package openwrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PluginName 代理插件名称
+type PluginName string
+
+const (
+	PluginVSSR     PluginName = "vssr"     // luci-app-vssr
+	PluginSSRPlus  PluginName = "ssr-plus" // luci-app-ssr-plus, uci配置名称为shadowsocksr
+	PluginPassWall PluginName = "passwall" // luci-app-passwall
+)
+
+const (
+	ssrPlusConfig  = "shadowsocksr" // ssr-plus的uci配置名称
+	passWallConfig = "passwall"     // passwall的uci配置名称
+)
+
+var ErrNoProxyPlugin = errors.New("no supported proxy plugin installed") // 路由器未安装支持的代理插件
+
+// ProxyPlugin 代理插件, 屏蔽不同插件的配置及接口差异
+type ProxyPlugin interface {
+	// Name 插件名称
+	Name() PluginName
+	// ListNodes 列出所有代理节点
+	ListNodes(ctx context.Context) ([]*ProxyNodeInfo, error)
+	// TestNode 测试节点延迟, 结果写回节点的Latency和Offline
+	TestNode(ctx context.Context, pn *ProxyNodeInfo) error
+	// ApplyNode 应用节点为全局代理, 为空时关闭代理
+	ApplyNode(ctx context.Context, pn *ProxyNodeInfo) error
+	// UpdateSubscription 替换订阅地址并触发订阅更新
+	UpdateSubscription(ctx context.Context, urls ...string) error
+	// Status 获取运行状态
+	Status(ctx context.Context) (*ProxyStatus, error)
+}
+
+// Plugin 获取指定的代理插件; vssr沿用路由器配置的接口后端, 其他插件通过ubus读写uci配置
+func (r *Router) Plugin(name PluginName) (p ProxyPlugin, err error) {
+	switch name {
+	case PluginVSSR:
+		p = &vssrPlugin{r: r}
+	case PluginSSRPlus:
+		p = &ssrPlusPlugin{r: r}
+	case PluginPassWall:
+		p = &passWallPlugin{r: r}
+	default:
+		err = fmt.Errorf("unknown proxy plugin %q", name)
+	}
+	return
+}
+
+// DetectPlugin 按 vssr, ssr-plus, passwall 的顺序检测路由器安装的代理插件, 需要ubus读取uci配置的权限
+func (r *Router) DetectPlugin(ctx context.Context) (p ProxyPlugin, err error) {
+	plugins := []struct {
+		name   PluginName
+		config string
+	}{
+		{PluginVSSR, vssrConfig},
+		{PluginSSRPlus, ssrPlusConfig},
+		{PluginPassWall, passWallConfig},
+	}
+	for _, plugin := range plugins {
+		var sections []uciSection
+		sections, err = r.uciSections(ctx, plugin.config, "global")
+		if isUbusNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if len(sections) != 0 {
+			return r.Plugin(plugin.name)
+		}
+	}
+	err = ErrNoProxyPlugin
+	return
+}
+
+// vssrPlugin vssr插件, 直接使用 Router 的vssr接口
+type vssrPlugin struct {
+	r *Router
+}
+
+func (p *vssrPlugin) Name() PluginName {
+	return PluginVSSR
+}
+
+func (p *vssrPlugin) ListNodes(ctx context.Context) ([]*ProxyNodeInfo, error) {
+	return p.r.ListAllProxyNodeInfoContext(ctx)
+}
+
+func (p *vssrPlugin) TestNode(ctx context.Context, pn *ProxyNodeInfo) error {
+	return p.r.TestProxyNodeLatencyContext(ctx, pn)
+}
+
+func (p *vssrPlugin) ApplyNode(ctx context.Context, pn *ProxyNodeInfo) error {
+	return p.r.ApplyProxyNodeToGlobalContext(ctx, pn)
+}
+
+func (p *vssrPlugin) UpdateSubscription(ctx context.Context, urls ...string) error {
+	return p.r.UpdateSubscribeInfoContext(ctx, urls...)
+}
+
+func (p *vssrPlugin) Status(ctx context.Context) (*ProxyStatus, error) {
+	return p.r.GetProxyStatusContext(ctx)
+}
+
+// ssrPlusPlugin ShadowSocksR Plus+插件, 配置结构与vssr相同
+type ssrPlusPlugin struct {
+	r *Router
+}
+
+func (p *ssrPlusPlugin) Name() PluginName {
+	return PluginSSRPlus
+}
+
+func (p *ssrPlusPlugin) ListNodes(ctx context.Context) ([]*ProxyNodeInfo, error) {
+	return p.r.uciProxyNodes(ctx, ssrPlusConfig, "servers", "alias", "server", "server_port")
+}
+
+func (p *ssrPlusPlugin) TestNode(ctx context.Context, pn *ProxyNodeInfo) error {
+	return p.r.ubusTestProxyNodeLatency(ctx, pn)
+}
+
+func (p *ssrPlusPlugin) ApplyNode(ctx context.Context, pn *ProxyNodeInfo) (err error) {
+	id := "nil"
+	if pn != nil {
+		id = pn.Id
+	}
+	name, err := p.r.uciFirstSectionName(ctx, ssrPlusConfig, "global")
+	if err != nil {
+		return
+	}
+	if err = p.r.uciSet(ctx, ssrPlusConfig, name, map[string]interface{}{"global_server": id}); err != nil {
+		return
+	}
+	if err = p.r.uciCommit(ctx, ssrPlusConfig); err != nil {
+		return
+	}
+	return p.r.initScript(ctx, ssrPlusConfig, "restart")
+}
+
+func (p *ssrPlusPlugin) UpdateSubscription(ctx context.Context, urls ...string) (err error) {
+	if len(urls) == 0 {
+		return
+	}
+	name, err := p.r.uciFirstSectionName(ctx, ssrPlusConfig, "server_subscribe")
+	if err != nil {
+		return
+	}
+	if err = p.r.uciSet(ctx, ssrPlusConfig, name, map[string]interface{}{"subscribe_url": urls}); err != nil {
+		return
+	}
+	if err = p.r.uciCommit(ctx, ssrPlusConfig); err != nil {
+		return
+	}
+	return p.r.backgroundExec(ctx, "/usr/bin/lua /usr/share/shadowsocksr/subscribe.lua >>/var/log/ssrplus.log 2>&1 &")
+}
+
+func (p *ssrPlusPlugin) Status(ctx context.Context) (status *ProxyStatus, err error) {
+	sections, err := p.r.uciSections(ctx, ssrPlusConfig, "global")
+	if err != nil {
+		return
+	}
+	if len(sections) == 0 {
+		err = fmt.Errorf("uci section %s.@global[0] not found", ssrPlusConfig)
+		return
+	}
+	global := sections[0].str("global_server")
+	status = &ProxyStatus{
+		Enabled: global != "" && global != "nil",
+		Mode:    sections[0].str("run_mode"),
+	}
+
+	// 进程配置文件位于 /var/etc/ssrplus, 名称形如 tcp-only-ssr-retcp.json
+	lines, err := p.r.processList(ctx)
+	if err != nil {
+		return
+	}
+	for _, line := range lines {
+		switch {
+		case strings.Contains(line, "retcp"):
+			status.Running = true
+		case strings.Contains(line, "reudp"):
+			status.UDPRelayRunning = true
+		case strings.Contains(line, "ssrplus") && strings.Contains(line, "socks"):
+			status.Socks5Running = true
+		case strings.Contains(line, "dns2socks"), strings.Contains(line, "dns2tcp"), strings.Contains(line, "mosdns"):
+			status.DNSRunning = true
+		}
+	}
+	return
+}
+
+// passWallPlugin PassWall插件, 节点配置节类型为nodes, 订阅地址为独立的subscribe_list配置节
+type passWallPlugin struct {
+	r *Router
+}
+
+func (p *passWallPlugin) Name() PluginName {
+	return PluginPassWall
+}
+
+func (p *passWallPlugin) ListNodes(ctx context.Context) ([]*ProxyNodeInfo, error) {
+	return p.r.uciProxyNodes(ctx, passWallConfig, "nodes", "remarks", "address", "port")
+}
+
+func (p *passWallPlugin) TestNode(ctx context.Context, pn *ProxyNodeInfo) error {
+	return p.r.ubusTestProxyNodeLatency(ctx, pn)
+}
+
+func (p *passWallPlugin) ApplyNode(ctx context.Context, pn *ProxyNodeInfo) (err error) {
+	values := map[string]interface{}{"enabled": "0", "tcp_node": "nil"}
+	if pn != nil {
+		values = map[string]interface{}{"enabled": "1", "tcp_node": pn.Id}
+	}
+	name, err := p.r.uciFirstSectionName(ctx, passWallConfig, "global")
+	if err != nil {
+		return
+	}
+	if err = p.r.uciSet(ctx, passWallConfig, name, values); err != nil {
+		return
+	}
+	if err = p.r.uciCommit(ctx, passWallConfig); err != nil {
+		return
+	}
+	return p.r.initScript(ctx, passWallConfig, "restart")
+}
+
+func (p *passWallPlugin) UpdateSubscription(ctx context.Context, urls ...string) (err error) {
+	if len(urls) == 0 {
+		return
+	}
+
+	// 替换所有订阅配置节
+	sections, err := p.r.uciSections(ctx, passWallConfig, "subscribe_list")
+	if err != nil {
+		return
+	}
+	for _, section := range sections {
+		if err = p.r.uciDelete(ctx, passWallConfig, section.name()); err != nil {
+			return
+		}
+	}
+	for i, u := range urls {
+		values := map[string]interface{}{
+			"remark": fmt.Sprintf("subscribe%d", i+1),
+			"url":    u,
+		}
+		if _, err = p.r.uciAdd(ctx, passWallConfig, "subscribe_list", values); err != nil {
+			return
+		}
+	}
+	if err = p.r.uciCommit(ctx, passWallConfig); err != nil {
+		return
+	}
+	return p.r.backgroundExec(ctx, "/usr/bin/lua /usr/share/passwall/subscribe.lua start >/dev/null 2>&1 &")
+}
+
+func (p *passWallPlugin) Status(ctx context.Context) (status *ProxyStatus, err error) {
+	sections, err := p.r.uciSections(ctx, passWallConfig, "global")
+	if err != nil {
+		return
+	}
+	if len(sections) == 0 {
+		err = fmt.Errorf("uci section %s.@global[0] not found", passWallConfig)
+		return
+	}
+	status = &ProxyStatus{
+		Enabled: sections[0].str("enabled") == "1",
+		Mode:    sections[0].str("tcp_proxy_mode"),
+	}
+
+	// 进程配置文件位于 /tmp/etc/passwall, 名称形如 TCP.json/UDP.json/SOCKS_1.json
+	lines, err := p.r.processList(ctx)
+	if err != nil {
+		return
+	}
+	for _, line := range lines {
+		switch {
+		case strings.Contains(line, "passwall/TCP"):
+			status.Running = true
+		case strings.Contains(line, "passwall/UDP"):
+			status.UDPRelayRunning = true
+		case strings.Contains(line, "passwall/SOCKS"):
+			status.Socks5Running = true
+		case strings.Contains(line, "dns2socks"), strings.Contains(line, "chinadns-ng"):
+			status.DNSRunning = true
+		}
+	}
+	return
+}
@@ -0,0 +1,130 @@
+package openwrt
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newPluginTestStore() uciTestStore {
+	return uciTestStore{
+		"shadowsocksr": {
+			"cfg00": {".name": "cfg00", ".type": "global", ".index": float64(0), "global_server": "nil", "run_mode": "gfw"},
+			"cfg01": {".name": "cfg01", ".type": "servers", ".index": float64(1), "alias": "香港 01", "server": "hk.example.com", "server_port": "8388"},
+			"cfg02": {".name": "cfg02", ".type": "server_subscribe", ".index": float64(2), "subscribe_url": []interface{}{"https://old.example.com"}},
+		},
+		"passwall": {
+			"cfg10": {".name": "cfg10", ".type": "global", ".index": float64(0), "enabled": "0", "tcp_node": "nil", "tcp_proxy_mode": "chnroute"},
+			"cfg11": {".name": "cfg11", ".type": "nodes", ".index": float64(1), "remarks": "日本 01", "address": "jp.example.com", "port": "443"},
+			"cfg12": {".name": "cfg12", ".type": "subscribe_list", ".index": float64(2), "remark": "old", "url": "https://old.example.com"},
+		},
+	}
+}
+
+func TestRouter_DetectPlugin(t *testing.T) {
+	store := newPluginTestStore()
+	srv := newUciTestServer(t, store, func(command string, params []string) string { return "" })
+	defer srv.Close()
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+	ctx := context.Background()
+
+	p, err := r.DetectPlugin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name() != PluginSSRPlus {
+		t.Errorf("got %s, want %s", p.Name(), PluginSSRPlus)
+	}
+
+	delete(store, "shadowsocksr")
+	if p, err = r.DetectPlugin(ctx); err != nil || p.Name() != PluginPassWall {
+		t.Errorf("got %v %v, want %s", p, err, PluginPassWall)
+	}
+
+	delete(store, "passwall")
+	if _, err = r.DetectPlugin(ctx); !errors.Is(err, ErrNoProxyPlugin) {
+		t.Errorf("got %v, want ErrNoProxyPlugin", err)
+	}
+	if _, err = r.Plugin("openclash"); err == nil {
+		t.Error("expected unknown plugin error")
+	}
+}
+
+func TestProxyPlugin(t *testing.T) {
+	store := newPluginTestStore()
+	var commands []string
+	srv := newUciTestServer(t, store, func(command string, params []string) string {
+		commands = append(commands, strings.Join(append([]string{command}, params...), " "))
+		if command == "/bin/busybox" {
+			return "1 root /usr/bin/ssr-redir -c /var/etc/ssrplus/tcp-only-ssr-retcp.json\n2 root /usr/bin/xray run -c /tmp/etc/passwall/TCP.json\n"
+		}
+		return ""
+	})
+	defer srv.Close()
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+	ctx := context.Background()
+
+	cases := []struct {
+		name      PluginName
+		config    string
+		global    string
+		option    string
+		restart   string
+		subscribe string
+		mode      string
+	}{
+		{PluginSSRPlus, "shadowsocksr", "cfg00", "global_server", "/etc/init.d/shadowsocksr restart", "/usr/share/shadowsocksr/subscribe.lua", "gfw"},
+		{PluginPassWall, "passwall", "cfg10", "tcp_node", "/etc/init.d/passwall restart", "/usr/share/passwall/subscribe.lua", "chnroute"},
+	}
+	for _, c := range cases {
+		t.Run(string(c.name), func(t *testing.T) {
+			commands = nil
+			p, err := r.Plugin(c.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pns, err := p.ListNodes(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pns) != 1 {
+				t.Fatalf("got %d nodes, want 1", len(pns))
+			}
+			if err = p.ApplyNode(ctx, pns[0]); err != nil {
+				t.Fatal(err)
+			}
+			if got := store[c.config][c.global][c.option]; got != pns[0].Id {
+				t.Errorf("got %s %v, want %s", c.option, got, pns[0].Id)
+			}
+
+			if err = p.UpdateSubscription(ctx, "https://a.example.com/sub", "https://b.example.com/sub"); err != nil {
+				t.Fatal(err)
+			}
+			var urls []string
+			for _, section := range store[c.config] {
+				urls = append(urls, section.list("subscribe_url")...)
+				urls = append(urls, section.list("url")...)
+			}
+			if len(urls) != 2 || strings.Contains(strings.Join(urls, " "), "old") {
+				t.Errorf("got subscribe urls %v", urls)
+			}
+
+			status, err := p.Status(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := ProxyStatus{Enabled: true, Mode: c.mode, Running: true}
+			if !reflect.DeepEqual(*status, want) {
+				t.Errorf("got status %+v, want %+v", *status, want)
+			}
+
+			all := strings.Join(commands, "\n")
+			if !strings.Contains(all, c.restart) || !strings.Contains(all, c.subscribe) {
+				t.Errorf("got commands %v", commands)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package openwrt
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newSessionTestServer 模拟LuCI登录, 每次登录签发新的sysauth, expire决定当前会话的过期方式
+func newSessionTestServer(password string, expire func(w http.ResponseWriter, req *http.Request) bool) (srv *httptest.Server, logins *int) {
+	logins = new(int)
+	var current string
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == LoginPath && req.Method == http.MethodPost {
+			if req.FormValue("luci_password") != password {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`<input type="password" name="luci_password" />`))
+				return
+			}
+			*logins++
+			current = fmt.Sprintf("sysauth=session%d", *logins)
+			w.Header().Set("Set-Cookie", current+"; path=/cgi-bin/luci/")
+			w.Header().Set("Location", LoginPath)
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+		if req.Header.Get("Cookie") != current || expire(w, req) {
+			return
+		}
+		_, _ = w.Write([]byte(`{"ret":"1","used":12}`))
+	}))
+	return
+}
+
+func TestRouter_SessionRenewal(t *testing.T) {
+	cases := map[string]func(w http.ResponseWriter, req *http.Request){
+		"forbidden": func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		},
+		"redirect": func(w http.ResponseWriter, req *http.Request) {
+			http.Redirect(w, req, LoginPath, http.StatusFound)
+		},
+		"login form": func(w http.ResponseWriter, req *http.Request) {
+			_, _ = w.Write([]byte(`<form method="post"><input type="password" name="luci_password" /></form>`))
+		},
+	}
+	for name, expireWith := range cases {
+		t.Run(name, func(t *testing.T) {
+			expired := false
+			srv, logins := newSessionTestServer("password", func(w http.ResponseWriter, req *http.Request) bool {
+				if !expired {
+					expired = true
+					expireWith(w, req)
+					return true
+				}
+				return false
+			})
+			defer srv.Close()
+
+			r := NewRouterInstance(&RouterConfig{
+				Addr:     strings.TrimPrefix(srv.URL, "http://"),
+				Username: "root",
+				Password: "password",
+			})
+			pn := &ProxyNodeInfo{Host: "hk.example.com", Port: "443"}
+			if err := r.TestProxyNodeLatency(pn); err != nil {
+				t.Fatal(err)
+			}
+			if !expired || *logins != 2 {
+				t.Errorf("got expired=%v logins=%d, want one re-login", expired, *logins)
+			}
+			if pn.Latency != 12 {
+				t.Errorf("got latency %d, want 12", pn.Latency)
+			}
+		})
+	}
+}
+
+func TestRouter_SessionRenewalFailed(t *testing.T) {
+	srv, _ := newSessionTestServer("password", func(w http.ResponseWriter, req *http.Request) bool {
+		w.WriteHeader(http.StatusForbidden)
+		return true
+	})
+	defer srv.Close()
+
+	r := NewRouterInstance(&RouterConfig{
+		Addr:     strings.TrimPrefix(srv.URL, "http://"),
+		Username: "root",
+		Password: "password",
+	})
+	if err := r.TestProxyNodeLatency(&ProxyNodeInfo{}); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("got %v, want ErrSessionExpired", err)
+	}
+
+	r = NewRouterInstance(&RouterConfig{
+		Addr:     strings.TrimPrefix(srv.URL, "http://"),
+		Username: "root",
+		Password: "wrong",
+	})
+	if err := r.TestProxyNodeLatency(&ProxyNodeInfo{}); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("got %v, want ErrAuthFailed", err)
+	}
+}
+
+func TestRouter_UbusSessionRenewal(t *testing.T) {
+	logins := 0
+	srv := newUbusTestServer(t, func(object, method string, args map[string]interface{}) []interface{} {
+		if object == "session" {
+			logins++
+			return []interface{}{0, map[string]interface{}{"ubus_rpc_session": "test-session"}}
+		}
+		return []interface{}{0, map[string]interface{}{"code": 0, "stdout": "0 3000"}}
+	})
+	defer srv.Close()
+
+	r := NewRouterInstance(&RouterConfig{
+		Addr:    strings.TrimPrefix(srv.URL, "http://"),
+		Backend: BackendUbus,
+	})
+	r.ubusSession = "expired-session"
+	pn := &ProxyNodeInfo{Host: "hk.example.com", Port: "443"}
+	if err := r.TestProxyNodeLatency(pn); err != nil {
+		t.Fatal(err)
+	}
+	if logins != 1 || !pn.Offline {
+		t.Errorf("got logins=%d offline=%v, want one re-login and offline node", logins, pn.Offline)
+	}
+}
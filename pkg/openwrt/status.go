@@ -0,0 +1,201 @@
+package openwrt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/PuerkitoBio/goquery"
+	"net/http"
+	"strings"
+)
+
+const (
+	ClientPagePath = "/cgi-bin/luci/admin/services/vssr/client" // 客户端设置页面, 包含全局节点及运行模式
+	RunStatusPath  = "/cgi-bin/luci/admin/services/vssr/run"    // 运行状态
+)
+
+// ProxyRole 代理节点用途, 对应vssr全局配置中的 <role>_server 选项
+type ProxyRole string
+
+const (
+	RoleGlobal   ProxyRole = "global"    // 全局节点
+	RoleUDPRelay ProxyRole = "udp_relay" // UDP中继节点, 值为same时与全局节点相同
+	RoleYoutube  ProxyRole = "youtube"   // YouTube分流节点
+	RoleTWVideo  ProxyRole = "tw_video"  // 台湾视频分流节点
+	RoleNetflix  ProxyRole = "netflix"   // Netflix分流节点
+	RoleDisney   ProxyRole = "disney"    // Disney+分流节点
+	RolePrime    ProxyRole = "prime"     // Prime Video分流节点
+	RoleTVB      ProxyRole = "tvb"       // TVB分流节点
+	RoleCustom   ProxyRole = "custom"    // 自定义分流节点
+)
+
+// ProxyRoles vssr支持的所有节点用途
+var ProxyRoles = []ProxyRole{RoleGlobal, RoleUDPRelay, RoleYoutube, RoleTWVideo, RoleNetflix, RoleDisney, RolePrime, RoleTVB, RoleCustom}
+
+// ProxyStatus vssr运行状态
+type ProxyStatus struct {
+	Enabled         bool   // 是否设置了全局节点
+	Mode            string // 运行模式, 如 gfw/router/all/oversea
+	Running         bool   // 全局代理进程是否运行
+	UDPRelayRunning bool   // UDP中继进程是否运行
+	Socks5Running   bool   // Socks5代理进程是否运行
+	DNSRunning      bool   // DNS解析进程是否运行
+}
+
+// GetGlobalProxyNode 获取当前全局节点, 未启用时返回空
+func (r *Router) GetGlobalProxyNode() (pn *ProxyNodeInfo, err error) {
+	return r.GetGlobalProxyNodeContext(context.Background())
+}
+
+// GetGlobalProxyNodeContext 获取当前全局节点, 未启用时返回空
+func (r *Router) GetGlobalProxyNodeContext(ctx context.Context) (pn *ProxyNodeInfo, err error) {
+	nodes, err := r.GetProxyNodesContext(ctx)
+	if err != nil {
+		return
+	}
+	pn = nodes[RoleGlobal]
+	return
+}
+
+// GetProxyNodes 获取各用途当前使用的节点, 未设置的用途不在结果中
+func (r *Router) GetProxyNodes() (nodes map[ProxyRole]*ProxyNodeInfo, err error) {
+	return r.GetProxyNodesContext(context.Background())
+}
+
+// GetProxyNodesContext 获取各用途当前使用的节点, 未设置的用途不在结果中
+func (r *Router) GetProxyNodesContext(ctx context.Context) (nodes map[ProxyRole]*ProxyNodeInfo, err error) {
+	options, err := r.vssrGlobalOptions(ctx)
+	if err != nil {
+		return
+	}
+	pns, err := r.ListAllProxyNodeInfoContext(ctx)
+	if err != nil {
+		return
+	}
+
+	nodes = make(map[ProxyRole]*ProxyNodeInfo)
+	for _, role := range ProxyRoles {
+		id := options[string(role)+"_server"]
+		if role == RoleUDPRelay && id == "same" {
+			id = options["global_server"]
+		}
+		if id == "" || id == "nil" {
+			continue
+		}
+		nodes[role] = &ProxyNodeInfo{Id: id}
+		for _, pn := range pns {
+			if pn.Id == id {
+				nodes[role] = pn
+				break
+			}
+		}
+	}
+	return
+}
+
+// GetProxyStatus 获取vssr运行状态
+func (r *Router) GetProxyStatus() (status *ProxyStatus, err error) {
+	return r.GetProxyStatusContext(context.Background())
+}
+
+// GetProxyStatusContext 获取vssr运行状态
+func (r *Router) GetProxyStatusContext(ctx context.Context) (status *ProxyStatus, err error) {
+	options, err := r.vssrGlobalOptions(ctx)
+	if err != nil {
+		return
+	}
+	status = &ProxyStatus{
+		Enabled: options["global_server"] != "" && options["global_server"] != "nil",
+		Mode:    options["run_mode"],
+	}
+
+	if r.backend == BackendUbus {
+		err = r.ubusProcessStatus(ctx, status)
+		return
+	}
+
+	// 服务请求
+	body, err := r.luciRequest(ctx, http.MethodGet, RunStatusPath, nil)
+	if err != nil {
+		return
+	}
+
+	// 序列化数据
+	data := &struct {
+		Global bool `json:"global"`
+		Game   bool `json:"game"`
+		Socks5 bool `json:"socks5"`
+		Pdnsd  bool `json:"pdnsd"`
+	}{}
+	if err = json.Unmarshal(body, data); err != nil {
+		return
+	}
+	status.Running = data.Global
+	status.UDPRelayRunning = data.Game
+	status.Socks5Running = data.Socks5
+	status.DNSRunning = data.Pdnsd
+	return
+}
+
+// vssrGlobalOptions 读取vssr全局配置选项
+func (r *Router) vssrGlobalOptions(ctx context.Context) (options map[string]string, err error) {
+	options = make(map[string]string)
+	if r.backend == BackendUbus {
+		var sections []uciSection
+		if sections, err = r.uciSections(ctx, vssrConfig, "global"); err != nil {
+			return
+		}
+		if len(sections) == 0 {
+			err = errors.New("uci section vssr.@global[0] not found")
+			return
+		}
+		for option := range sections[0] {
+			options[option] = sections[0].str(option)
+		}
+		return
+	}
+
+	// 服务请求
+	body, err := r.luciRequest(ctx, http.MethodGet, ClientPagePath, nil)
+	if err != nil {
+		return
+	}
+
+	// 解析html
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	values := parseCBIForm(doc, vssrConfig)
+	section := values.sectionWith("global_server")
+	if section == "" {
+		err = errors.New("global settings not found in client page")
+		return
+	}
+	for option := range values[section] {
+		options[option] = values.first(section, option)
+	}
+	return
+}
+
+// ubusProcessStatus 根据进程列表判断vssr各组件是否运行, 进程名称与vssr的run接口一致
+func (r *Router) ubusProcessStatus(ctx context.Context, status *ProxyStatus) (err error) {
+	lines, err := r.processList(ctx)
+	if err != nil {
+		return
+	}
+	for _, line := range lines {
+		switch {
+		case strings.Contains(line, "vssr_t"):
+			status.Running = true
+		case strings.Contains(line, "vssr_u"):
+			status.UDPRelayRunning = true
+		case strings.Contains(line, "vssr_s"):
+			status.Socks5Running = true
+		case strings.Contains(line, "pdnsd"):
+			status.DNSRunning = true
+		}
+	}
+	return
+}
@@ -0,0 +1,67 @@
+package openwrt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRouter_UbusProxyStatus(t *testing.T) {
+	srv := newUbusTestServer(t, func(object, method string, args map[string]interface{}) []interface{} {
+		switch object + "." + method {
+		case "session.login":
+			return []interface{}{0, map[string]interface{}{"ubus_rpc_session": "test-session"}}
+		case "uci.get":
+			switch args["type"] {
+			case "global":
+				return []interface{}{0, map[string]interface{}{"values": map[string]interface{}{
+					"cfg00": map[string]interface{}{".name": "cfg00", ".index": 0, "global_server": "cfg01", "udp_relay_server": "same", "netflix_server": "cfg02", "youtube_server": "nil", "run_mode": "gfw"},
+				}}}
+			case "servers":
+				return []interface{}{0, map[string]interface{}{"values": map[string]interface{}{
+					"cfg01": map[string]interface{}{".name": "cfg01", ".index": 1, "alias": "香港 01", "server": "hk.example.com", "server_port": "8388"},
+					"cfg02": map[string]interface{}{".name": "cfg02", ".index": 2, "alias": "日本 01", "server": "jp.example.com", "server_port": "443"},
+				}}}
+			}
+			return []interface{}{4}
+		case "file.exec":
+			return []interface{}{0, map[string]interface{}{"code": 0, "stdout": "  PID USER       VSZ STAT COMMAND\n 1234 root      5000 S    /var/etc/vssr_t.json\n 1300 root      2000 S    /usr/sbin/pdnsd\n"}}
+		}
+		return []interface{}{3}
+	})
+	defer srv.Close()
+
+	r := NewRouterInstance(&RouterConfig{
+		Addr:     strings.TrimPrefix(srv.URL, "http://"),
+		Username: "root",
+		Password: "password",
+		Backend:  BackendUbus,
+	})
+
+	pn, err := r.GetGlobalProxyNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pn == nil || pn.Id != "cfg01" || pn.Host != "hk.example.com" {
+		t.Fatalf("got global node %+v, want cfg01", pn)
+	}
+
+	nodes, err := r.GetProxyNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 3 || nodes[RoleUDPRelay].Id != "cfg01" || nodes[RoleNetflix].Id != "cfg02" {
+		t.Errorf("got nodes %v", nodes)
+	}
+	if _, ok := nodes[RoleYoutube]; ok {
+		t.Error("got youtube node, want unset")
+	}
+
+	status, err := r.GetProxyStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ProxyStatus{Enabled: true, Mode: "gfw", Running: true, DNSRunning: true}
+	if *status != want {
+		t.Errorf("got %+v, want %+v", *status, want)
+	}
+}
@@ -0,0 +1,252 @@
+package openwrt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const SubscriptionPagePath = "/cgi-bin/luci/admin/services/vssr/subscription" // 订阅设置页面
+
+// SubscribeOptions vssr订阅设置
+type SubscribeOptions struct {
+	URLs           []string // 订阅地址列表
+	AutoUpdate     bool     // 是否自动更新订阅
+	AutoUpdateHour int      // 每天自动更新订阅的时间(0-23时)
+	Proxy          bool     // 是否通过代理更新订阅
+	FilterWords    []string // 名称包含任一关键字的节点会被丢弃
+	SaveWords      []string // 不为空时只保留名称包含任一关键字的节点
+}
+
+// DefaultSubscribeOptions 默认订阅设置: 每天2点自动更新, 不通过代理, 过滤过期时间和剩余流量节点
+func DefaultSubscribeOptions(urls ...string) *SubscribeOptions {
+	return &SubscribeOptions{
+		URLs:           urls,
+		AutoUpdate:     true,
+		AutoUpdateHour: 2,
+		FilterWords:    []string{"过期时间", "剩余流量"},
+	}
+}
+
+// Diff 列出与目标设置不同的字段, 用于应用前确认修改内容; 列表为nil与为空视为相同
+func (o *SubscribeOptions) Diff(target *SubscribeOptions) (diffs []string) {
+	check := func(name string, changed bool, from, to interface{}) {
+		if changed {
+			diffs = append(diffs, fmt.Sprintf("%s: %v -> %v", name, from, to))
+		}
+	}
+	check("urls", !equalStrings(o.URLs, target.URLs), o.URLs, target.URLs)
+	check("auto_update", o.AutoUpdate != target.AutoUpdate, o.AutoUpdate, target.AutoUpdate)
+	check("auto_update_time", o.AutoUpdateHour != target.AutoUpdateHour, o.AutoUpdateHour, target.AutoUpdateHour)
+	check("proxy", o.Proxy != target.Proxy, o.Proxy, target.Proxy)
+	check("filter_words", !equalStrings(o.FilterWords, target.FilterWords), o.FilterWords, target.FilterWords)
+	check("save_words", !equalStrings(o.SaveWords, target.SaveWords), o.SaveWords, target.SaveWords)
+	return
+}
+
+// equalStrings 按长度及元素比较字符串列表
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// validate 校验订阅设置
+func (o *SubscribeOptions) validate() error {
+	if o.AutoUpdateHour < 0 || o.AutoUpdateHour > 23 {
+		return fmt.Errorf("invalid auto update hour %d", o.AutoUpdateHour)
+	}
+	return nil
+}
+
+// UpdateSubscribeOptions 保存订阅设置并触发订阅更新
+func (r *Router) UpdateSubscribeOptions(opts *SubscribeOptions) (err error) {
+	return r.UpdateSubscribeOptionsContext(context.Background(), opts)
+}
+
+// UpdateSubscribeOptionsContext 保存订阅设置并触发订阅更新;
+// LuCI后端通过vssr的subscribe接口提交, 该接口不接受空的订阅列表, 旧版本vssr会忽略save_words
+func (r *Router) UpdateSubscribeOptionsContext(ctx context.Context, opts *SubscribeOptions) (err error) {
+	if err = opts.validate(); err != nil {
+		return
+	}
+	if r.backend == BackendUbus {
+		return r.ubusUpdateSubscribeOptions(ctx, opts)
+	}
+	if len(opts.URLs) == 0 {
+		err = errors.New("subscribe url list is empty")
+		return
+	}
+
+	// 构建订阅列表, 保留地址中的&等字符原样提交
+	urls := &bytes.Buffer{}
+	encoder := json.NewEncoder(urls)
+	encoder.SetEscapeHTML(false)
+	if err = encoder.Encode(opts.URLs); err != nil {
+		return
+	}
+
+	// 服务请求
+	params := url.Values{}
+	params.Set("auto_update", boolOption(opts.AutoUpdate))
+	params.Set("auto_update_time", strconv.Itoa(opts.AutoUpdateHour))
+	params.Set("subscribe_url", strings.TrimSpace(urls.String()))
+	params.Set("proxy", boolOption(opts.Proxy))
+	params.Set("filter_words", strings.Join(opts.FilterWords, "/"))
+	params.Set("save_words", strings.Join(opts.SaveWords, "/"))
+	body, err := r.luciRequest(ctx, http.MethodPost, UpdateSubscribePath, params)
+	if err != nil {
+		return
+	}
+
+	// 序列化数据
+	data := &struct {
+		Error int `json:"error"`
+	}{}
+	err = json.Unmarshal(body, data)
+	if err != nil {
+		return
+	}
+	if data.Error != 0 {
+		err = fmt.Errorf("error not equal zero %d", data.Error)
+		return
+	}
+	return
+}
+
+// GetSubscribeInfo 读取路由器当前的订阅设置
+func (r *Router) GetSubscribeInfo() (opts *SubscribeOptions, err error) {
+	return r.GetSubscribeInfoContext(context.Background())
+}
+
+// GetSubscribeInfoContext 读取路由器当前的订阅设置
+func (r *Router) GetSubscribeInfoContext(ctx context.Context) (opts *SubscribeOptions, err error) {
+	if r.backend == BackendUbus {
+		return r.ubusGetSubscribeInfo(ctx)
+	}
+
+	// 服务请求
+	body, err := r.luciRequest(ctx, http.MethodGet, SubscriptionPagePath, nil)
+	if err != nil {
+		return
+	}
+
+	return parseSubscribePage(body)
+}
+
+// parseSubscribePage 解析订阅设置页面中的表单
+func parseSubscribePage(body []byte) (opts *SubscribeOptions, err error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	values := parseCBIForm(doc, vssrConfig)
+	section := values.sectionWith("subscribe_url")
+	if section == "" {
+		err = errors.New("subscribe settings not found in subscription page")
+		return
+	}
+	return newSubscribeOptions(values[section])
+}
+
+// ubusUpdateSubscribeOptions 修改vssr订阅配置并在后台执行订阅更新
+func (r *Router) ubusUpdateSubscribeOptions(ctx context.Context, opts *SubscribeOptions) (err error) {
+	name, err := r.uciFirstSectionName(ctx, vssrConfig, "server_subscribe")
+	if err != nil {
+		return
+	}
+	values := map[string]interface{}{
+		"auto_update":      boolOption(opts.AutoUpdate),
+		"auto_update_time": strconv.Itoa(opts.AutoUpdateHour),
+		"proxy":            boolOption(opts.Proxy),
+		"filter_words":     strings.Join(opts.FilterWords, "/"),
+		"save_words":       strings.Join(opts.SaveWords, "/"),
+		"subscribe_url":    append([]string{}, opts.URLs...),
+	}
+	if err = r.uciSet(ctx, vssrConfig, name, values); err != nil {
+		return
+	}
+	if err = r.uciCommit(ctx, vssrConfig); err != nil {
+		return
+	}
+	if len(opts.URLs) == 0 {
+		return
+	}
+
+	return r.backgroundExec(ctx, "/usr/bin/lua /usr/share/vssr/subscribe.lua >/www/check_update.htm 2>/dev/null &")
+}
+
+// ubusGetSubscribeInfo 从vssr的uci配置读取订阅设置
+func (r *Router) ubusGetSubscribeInfo(ctx context.Context) (opts *SubscribeOptions, err error) {
+	sections, err := r.uciSections(ctx, vssrConfig, "server_subscribe")
+	if err != nil {
+		return
+	}
+	if len(sections) == 0 {
+		err = errors.New("uci section vssr.@server_subscribe[0] not found")
+		return
+	}
+	options := map[string][]string{}
+	for option := range sections[0] {
+		options[option] = sections[0].list(option)
+	}
+	return newSubscribeOptions(options)
+}
+
+// newSubscribeOptions 根据配置选项构建订阅设置
+func newSubscribeOptions(options map[string][]string) (opts *SubscribeOptions, err error) {
+	get := func(option string) string {
+		if items := options[option]; len(items) != 0 {
+			return items[0]
+		}
+		return ""
+	}
+	opts = &SubscribeOptions{
+		AutoUpdate:  get("auto_update") == "1",
+		Proxy:       get("proxy") == "1",
+		FilterWords: splitWords(get("filter_words")),
+		SaveWords:   splitWords(get("save_words")),
+	}
+	for _, item := range options["subscribe_url"] {
+		if item != "" {
+			opts.URLs = append(opts.URLs, item)
+		}
+	}
+	if hour := get("auto_update_time"); hour != "" {
+		if opts.AutoUpdateHour, err = strconv.Atoi(hour); err != nil {
+			err = fmt.Errorf("invalid auto_update_time %q", hour)
+		}
+	}
+	return
+}
+
+// splitWords 切分以'/'分隔的关键字
+func splitWords(s string) (words []string) {
+	for _, word := range strings.Split(s, "/") {
+		if word = strings.TrimSpace(word); word != "" {
+			words = append(words, word)
+		}
+	}
+	return
+}
+
+// boolOption uci布尔选项值
+func boolOption(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,91 @@
+package openwrt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSubscriptionPage = `<form method="post">
+<input type="hidden" name="cbi.cbe.vssr.cfg0a8fd1.auto_update" value="1" />
+<input type="checkbox" name="cbid.vssr.cfg0a8fd1.auto_update" value="1" checked="checked" />
+<select name="cbid.vssr.cfg0a8fd1.auto_update_time">
+  <option value="1">1:00</option>
+  <option value="4" selected="selected">4:00</option>
+</select>
+<input type="text" name="cbid.vssr.cfg0a8fd1.subscribe_url" value="https://a.example.com/sub?token=1" />
+<input type="text" name="cbid.vssr.cfg0a8fd1.subscribe_url" value="https://b.example.com/sub" />
+<input type="checkbox" name="cbid.vssr.cfg0a8fd1.proxy" value="1" />
+<input type="text" name="cbid.vssr.cfg0a8fd1.filter_words" value="过期时间/剩余流量/官网" />
+<input type="text" name="cbid.vssr.cfg0a8fd1.save_words" value="" />
+</form>`
+
+func TestRouter_SubscribeOptions(t *testing.T) {
+	var posted url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case LoginPath:
+			w.Header().Set("Set-Cookie", "sysauth=abc; path=/cgi-bin/luci/")
+			w.WriteHeader(http.StatusFound)
+		case SubscriptionPagePath:
+			_, _ = w.Write([]byte(testSubscriptionPage))
+		case UpdateSubscribePath:
+			_ = req.ParseForm()
+			posted = req.PostForm
+			_, _ = w.Write([]byte(`{"error":0}`))
+		}
+	}))
+	defer srv.Close()
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://")})
+
+	current, err := r.GetSubscribeInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &SubscribeOptions{
+		URLs:           []string{"https://a.example.com/sub?token=1", "https://b.example.com/sub"},
+		AutoUpdate:     true,
+		AutoUpdateHour: 4,
+		FilterWords:    []string{"过期时间", "剩余流量", "官网"},
+	}
+	if !reflect.DeepEqual(current, want) {
+		t.Fatalf("got %+v, want %+v", current, want)
+	}
+
+	target := DefaultSubscribeOptions("https://a.example.com/sub?token=1&flag=ss", "https://c.example.com/sub")
+	target.SaveWords = []string{"香港", "日本"}
+	if diffs := current.Diff(target); len(diffs) != 4 {
+		t.Errorf("got diffs %v", diffs)
+	}
+	if diffs := (&SubscribeOptions{SaveWords: []string{}}).Diff(&SubscribeOptions{}); len(diffs) != 0 {
+		t.Errorf("nil and empty lists should be equal, got diffs %v", diffs)
+	}
+	if err = r.UpdateSubscribeOptions(target); err != nil {
+		t.Fatal(err)
+	}
+	if got := posted.Get("subscribe_url"); got != `["https://a.example.com/sub?token=1&flag=ss","https://c.example.com/sub"]` {
+		t.Errorf("got subscribe_url %s", got)
+	}
+	if posted.Get("auto_update_time") != "2" || posted.Get("save_words") != "香港/日本" || posted.Get("filter_words") != "过期时间/剩余流量" {
+		t.Errorf("got form %v", posted)
+	}
+
+	if err = r.UpdateSubscribeOptions(&SubscribeOptions{URLs: []string{"x"}, AutoUpdateHour: 24}); err == nil {
+		t.Error("expected invalid hour error")
+	}
+}
+
+func TestParseCBIForm_DynamicList(t *testing.T) {
+	page := `<div class="cbi-dynlist" data-prefix="cbid.vssr.cfg01.subscribe_url" data-values='["https://a.example.com","https://b.example.com"]'></div>
+<input type="checkbox" name="cbid.vssr.cfg01.auto_update" value="1" />`
+	opts, err := parseSubscribePage([]byte(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.URLs) != 2 || opts.AutoUpdate {
+		t.Errorf("got %+v", opts)
+	}
+}
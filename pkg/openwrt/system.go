@@ -0,0 +1,174 @@
+package openwrt
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SystemInfo 路由器系统状态, 容量单位均为字节
+type SystemInfo struct {
+	Hostname  string // 主机名称
+	BoardName string // 主板名称, 如 xiaomi,redmi-router-ax6000
+	Model     string // 设备型号
+	Firmware  string // 固件描述, 如 OpenWrt 22.03.2 r19803-9a599fee93
+	Version   string // 固件版本
+	Target    string // 固件平台, 如 mediatek/filogic
+	Kernel    string // 内核版本
+
+	LocalTime time.Time     // 路由器本地时间
+	Uptime    time.Duration // 运行时间
+	Load      [3]float64    // 1/5/15分钟平均负载
+
+	MemTotal     uint64 // 内存总量
+	MemFree      uint64 // 空闲内存
+	MemAvailable uint64 // 可用内存, 包含可回收的缓存
+	MemBuffered  uint64 // 缓冲区
+	MemCached    uint64 // 页缓存
+	SwapTotal    uint64 // 交换空间总量
+	SwapFree     uint64 // 空闲交换空间
+
+	OverlayTotal uint64 // 可写分区(overlay)总量
+	OverlayUsed  uint64 // 可写分区已用
+	OverlayFree  uint64 // 可写分区可用
+}
+
+// MemUsedPercent 内存使用率百分比, 以可用内存计算
+func (s *SystemInfo) MemUsedPercent() float64 {
+	if s.MemTotal == 0 {
+		return 0
+	}
+	return float64(s.MemTotal-s.MemAvailable) * 100 / float64(s.MemTotal)
+}
+
+// OverlayUsedPercent 可写分区使用率百分比
+func (s *SystemInfo) OverlayUsedPercent() float64 {
+	if s.OverlayTotal == 0 {
+		return 0
+	}
+	return float64(s.OverlayUsed) * 100 / float64(s.OverlayTotal)
+}
+
+// ubusDiskInfo system.info中的分区容量, 单位为KB
+type ubusDiskInfo struct {
+	Total uint64 `json:"total"`
+	Free  uint64 `json:"free"`
+	Used  uint64 `json:"used"`
+	Avail uint64 `json:"avail"`
+}
+
+// GetSystemInfo 获取路由器系统状态
+func (r *Router) GetSystemInfo() (info *SystemInfo, err error) {
+	return r.GetSystemInfoContext(context.Background())
+}
+
+// GetSystemInfoContext 获取路由器系统状态, 通过ubus的system对象读取, 旧版本固件缺少分区信息时执行df获取
+func (r *Router) GetSystemInfoContext(ctx context.Context) (info *SystemInfo, err error) {
+	board := &struct {
+		Kernel    string `json:"kernel"`
+		Hostname  string `json:"hostname"`
+		Model     string `json:"model"`
+		BoardName string `json:"board_name"`
+		Release   struct {
+			Version     string `json:"version"`
+			Target      string `json:"target"`
+			Description string `json:"description"`
+		} `json:"release"`
+	}{}
+	if err = r.ubusCall(ctx, "system", "board", nil, board); err != nil {
+		return
+	}
+	data := &struct {
+		LocalTime int64     `json:"localtime"`
+		Uptime    int64     `json:"uptime"`
+		Load      [3]uint64 `json:"load"`
+		Memory    struct {
+			Total     uint64 `json:"total"`
+			Free      uint64 `json:"free"`
+			Buffered  uint64 `json:"buffered"`
+			Available uint64 `json:"available"`
+			Cached    uint64 `json:"cached"`
+		} `json:"memory"`
+		Swap struct {
+			Total uint64 `json:"total"`
+			Free  uint64 `json:"free"`
+		} `json:"swap"`
+		Root *ubusDiskInfo `json:"root"`
+	}{}
+	if err = r.ubusCall(ctx, "system", "info", nil, data); err != nil {
+		return
+	}
+
+	info = &SystemInfo{
+		Hostname:     board.Hostname,
+		BoardName:    board.BoardName,
+		Model:        board.Model,
+		Firmware:     board.Release.Description,
+		Version:      board.Release.Version,
+		Target:       board.Release.Target,
+		Kernel:       board.Kernel,
+		LocalTime:    time.Unix(data.LocalTime, 0).UTC(),
+		Uptime:       time.Duration(data.Uptime) * time.Second,
+		MemTotal:     data.Memory.Total,
+		MemFree:      data.Memory.Free,
+		MemAvailable: data.Memory.Available,
+		MemBuffered:  data.Memory.Buffered,
+		MemCached:    data.Memory.Cached,
+		SwapTotal:    data.Swap.Total,
+		SwapFree:     data.Swap.Free,
+	}
+	// 平均负载以65536为基数
+	for i, load := range data.Load {
+		info.Load[i] = float64(load) / 65536
+	}
+	// 旧版本内核没有available字段
+	if info.MemAvailable == 0 {
+		info.MemAvailable = info.MemFree + info.MemBuffered + info.MemCached
+	}
+
+	disk := data.Root
+	if disk == nil {
+		if disk, err = r.overlayDiskInfo(ctx); err != nil {
+			return
+		}
+	}
+	info.OverlayTotal = disk.Total * 1024
+	info.OverlayUsed = disk.Used * 1024
+	info.OverlayFree = disk.Avail * 1024
+	return
+}
+
+// overlayDiskInfo 通过df获取可写分区容量, 没有overlay分区时使用根分区
+func (r *Router) overlayDiskInfo(ctx context.Context) (disk *ubusDiskInfo, err error) {
+	res, err := r.fileExec(ctx, "/bin/df", "-k", "/overlay", "/")
+	if err != nil {
+		return
+	}
+
+	// Filesystem 1K-blocks Used Available Use% Mounted on
+	for _, line := range strings.Split(res.Stdout, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 || (fields[5] != "/overlay" && fields[5] != "/") {
+			continue
+		}
+		item := &ubusDiskInfo{}
+		if item.Total, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			return
+		}
+		if item.Used, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+			return
+		}
+		if item.Avail, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
+			return
+		}
+		if disk == nil || fields[5] == "/overlay" {
+			disk = item
+		}
+	}
+	if disk == nil {
+		err = fmt.Errorf("unexpected df output %q", res.Stdout)
+	}
+	return
+}
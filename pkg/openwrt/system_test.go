@@ -0,0 +1,55 @@
+package openwrt
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouter_GetSystemInfo(t *testing.T) {
+	for _, withRoot := range []bool{true, false} {
+		srv := newUbusTestServer(t, func(object, method string, args map[string]interface{}) []interface{} {
+			switch object + "." + method {
+			case "session.login":
+				return []interface{}{0, map[string]interface{}{"ubus_rpc_session": "test-session"}}
+			case "system.board":
+				return []interface{}{0, map[string]interface{}{
+					"kernel": "5.15.74", "hostname": "OpenWrt", "model": "Xiaomi Redmi Router AX6000", "board_name": "xiaomi,redmi-router-ax6000",
+					"release": map[string]interface{}{"version": "22.03.2", "target": "mediatek/filogic", "description": "OpenWrt 22.03.2 r19803-9a599fee93"},
+				}}
+			case "system.info":
+				data := map[string]interface{}{
+					"localtime": 1660000000, "uptime": 3600, "load": []int{65536, 32768, 0},
+					"memory": map[string]interface{}{"total": 1000, "free": 200, "buffered": 50, "available": 600, "cached": 100},
+					"swap":   map[string]interface{}{"total": 0, "free": 0},
+				}
+				if withRoot {
+					data["root"] = map[string]interface{}{"total": 100, "free": 60, "used": 40, "avail": 60}
+				}
+				return []interface{}{0, data}
+			case "file.exec":
+				return []interface{}{0, map[string]interface{}{"code": 0, "stdout": "Filesystem 1K-blocks Used Available Use% Mounted on\n/dev/root 4096 4096 0 100% /\noverlayfs:/overlay 100 40 60 40% /overlay\n"}}
+			}
+			return []interface{}{3}
+		})
+
+		r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+		info, err := r.GetSystemInfo()
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.BoardName != "xiaomi,redmi-router-ax6000" || info.Version != "22.03.2" || info.Kernel != "5.15.74" {
+			t.Errorf("got board %+v", info)
+		}
+		if info.Uptime != time.Hour || info.Load != [3]float64{1, 0.5, 0} {
+			t.Errorf("got uptime %s load %v", info.Uptime, info.Load)
+		}
+		if info.MemUsedPercent() != 40 {
+			t.Errorf("got memory used %.1f%%, want 40%%", info.MemUsedPercent())
+		}
+		if info.OverlayTotal != 100*1024 || info.OverlayUsed != 40*1024 || info.OverlayUsedPercent() != 40 {
+			t.Errorf("root=%v: got overlay %d/%d", withRoot, info.OverlayUsed, info.OverlayTotal)
+		}
+	}
+}
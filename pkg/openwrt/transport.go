@@ -0,0 +1,97 @@
+package openwrt
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const DefaultTimeout = 10 * time.Second // 默认请求超时时间
+
+// newHTTPClients 根据路由配置创建共享同一传输层的HTTP客户端:
+// client 跟随重定向但在重定向到登录页面时停止, loginClient 不跟随任何重定向以便读取登录后的Cookie;
+// 传输层优先使用HTTPClient的传输层, 其次为Transport, 都为空时才按TLS选项创建
+func newHTTPClients(conf *RouterConfig) (client, loginClient *http.Client) {
+	base := &http.Client{}
+	if conf.HTTPClient != nil {
+		*base = *conf.HTTPClient
+	}
+	if base.Transport == nil {
+		if conf.Transport != nil {
+			base.Transport = conf.Transport
+		} else {
+			base.Transport = newTransport(conf)
+		}
+	}
+	if base.Timeout == 0 {
+		base.Timeout = conf.Timeout
+	}
+	if base.Timeout == 0 {
+		base.Timeout = DefaultTimeout
+	}
+
+	client = &http.Client{}
+	*client = *base
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if isLoginPath(req.URL.Path) {
+			return http.ErrUseLastResponse
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+
+	loginClient = &http.Client{}
+	*loginClient = *base
+	loginClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return
+}
+
+// newTransport 创建支持长连接复用的传输层, 并按配置校验uhttpd的TLS证书
+func newTransport(conf *RouterConfig) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 4
+	if !conf.HTTPS {
+		return transport
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs:    conf.RootCAs,
+		ServerName: conf.ServerName,
+	}
+	if len(conf.PinnedCertSHA256) != 0 {
+		// uhttpd默认使用自签名证书, 固定证书指纹时不再校验证书链和主机名
+		pins := make(map[string]bool, len(conf.PinnedCertSHA256))
+		for _, pin := range conf.PinnedCertSHA256 {
+			pins[normalizeFingerprint(pin)] = true
+		}
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("router presented no certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			fingerprint := hex.EncodeToString(sum[:])
+			if !pins[fingerprint] {
+				return fmt.Errorf("router certificate fingerprint %s is not pinned", fingerprint)
+			}
+			return nil
+		}
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport
+}
+
+// normalizeFingerprint 统一证书指纹格式, 兼容 "AB:CD:..." 形式
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+}
@@ -0,0 +1,60 @@
+package openwrt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouter_PinnedCertificate(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Set-Cookie", "sysauth=abc; path=/cgi-bin/luci/")
+		w.WriteHeader(http.StatusFound)
+	}))
+	defer srv.Close()
+	sum := sha256.Sum256(srv.Certificate().Raw)
+
+	r := NewRouterInstance(&RouterConfig{
+		Addr:             strings.TrimPrefix(srv.URL, "https://"),
+		HTTPS:            true,
+		PinnedCertSHA256: []string{strings.ToUpper(hex.EncodeToString(sum[:]))},
+	})
+	if err := r.Login(); err != nil {
+		t.Fatal(err)
+	}
+
+	r = NewRouterInstance(&RouterConfig{
+		Addr:             strings.TrimPrefix(srv.URL, "https://"),
+		HTTPS:            true,
+		PinnedCertSHA256: []string{"00"},
+	})
+	if err := r.Login(); err == nil {
+		t.Error("expected fingerprint mismatch error")
+	}
+}
+
+func TestRouter_Context(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	r := NewRouterInstance(&RouterConfig{
+		Addr:    strings.TrimPrefix(srv.URL, "http://"),
+		Timeout: time.Minute,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := r.LoginContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,458 @@
+package openwrt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	UbusPath                   = "/ubus"                            // ubus JSON-RPC接口路径
+	ubusNullSession            = "00000000000000000000000000000000" // 登录前使用的空会话
+	ubusAccessDenied           = -32002                             // 会话无效或无权限时的JSON-RPC错误码
+	ubusStatusNotFound         = 4                                  // 对象或配置不存在的ubus状态码
+	ubusStatusNoData           = 5                                  // 没有数据的ubus状态码
+	ubusStatusPermissionDenied = 6                                  // 权限不足的ubus状态码
+)
+
+const vssrConfig = "vssr" // vssr的uci配置名称
+
+// vssrCheckPortScript 与vssr的checkport接口相同的TCP连接测速脚本, 输出 "<连通状态> <耗时毫秒>"
+const vssrCheckPortScript = `local nixio = require "nixio"
+local host, port = "%s", %s
+local sock = nixio.socket("inet", "stream")
+sock:setopt("socket", "rcvtimeo", 3)
+sock:setopt("socket", "sndtimeo", 3)
+local s0, u0 = nixio.gettimeofday()
+local ret = sock:connect(host, port)
+sock:close()
+local s1, u1 = nixio.gettimeofday()
+io.write(ret and "1" or "0", " ", math.floor((s1 - s0) * 1000 + (u1 - u0) / 1000 + 0.5))`
+
+var (
+	hostPattern = regexp.MustCompile(`^[0-9A-Za-z.:_\-\[\]]+$`)
+	portPattern = regexp.MustCompile(`^[0-9]{1,5}$`)
+)
+
+// ubus状态码描述
+var ubusStatusMessages = map[int]string{
+	1:  "invalid command",
+	2:  "invalid argument",
+	3:  "method not found",
+	4:  "not found",
+	5:  "no data",
+	6:  "permission denied",
+	7:  "timeout",
+	8:  "not supported",
+	9:  "unknown error",
+	10: "connection failed",
+}
+
+// UbusError ubus接口调用错误
+type UbusError struct {
+	Code    int    // 错误码, JSON-RPC错误为负数, ubus状态码为正数
+	Message string // 错误信息
+}
+
+func (e *UbusError) Error() string {
+	return fmt.Sprintf("ubus error %d: %s", e.Code, e.Message)
+}
+
+type ubusRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type ubusResponse struct {
+	Result []json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// uciSection uci配置节, 选项值为字符串或字符串列表
+type uciSection map[string]interface{}
+
+// str 获取字符串选项值, 列表选项以空格连接
+func (s uciSection) str(option string) string {
+	switch val := s[option].(type) {
+	case string:
+		return val
+	case []interface{}:
+		items := make([]string, 0, len(val))
+		for _, item := range val {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, " ")
+	}
+	return ""
+}
+
+// list 获取列表选项值, 字符串选项按单个元素返回
+func (s uciSection) list(option string) (items []string) {
+	switch val := s[option].(type) {
+	case string:
+		items = append(items, val)
+	case []interface{}:
+		for _, item := range val {
+			items = append(items, fmt.Sprint(item))
+		}
+	}
+	return
+}
+
+// name 配置节名称
+func (s uciSection) name() string {
+	return s.str(".name")
+}
+
+// index 配置节在配置文件中的顺序
+func (s uciSection) index() int {
+	idx, _ := s[".index"].(float64)
+	return int(idx)
+}
+
+// execResult file.exec执行结果
+type execResult struct {
+	Code   int    `json:"code"`
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+}
+
+// ubusLogin 通过session对象登录
+func (r *Router) ubusLogin(ctx context.Context) (err error) {
+	args := map[string]interface{}{
+		"username": r.username,
+		"password": r.password,
+	}
+	data := &struct {
+		UbusRPCSession string `json:"ubus_rpc_session"`
+	}{}
+	if err = r.ubusRawCall(ctx, ubusNullSession, "session", "login", args, data); err != nil {
+		var ue *UbusError
+		if errors.As(err, &ue) && ue.Code == ubusStatusPermissionDenied {
+			err = fmt.Errorf("%w: %v", ErrAuthFailed, err)
+		}
+		return
+	}
+	if data.UbusRPCSession == "" {
+		err = errors.New("ubus_rpc_session not found")
+		return
+	}
+	r.setCredential(&r.ubusSession, data.UbusRPCSession)
+	return
+}
+
+// ubusCall 使用当前会话调用ubus对象方法, 会话过期时重新登录一次并重放请求
+func (r *Router) ubusCall(ctx context.Context, object, method string, args interface{}, result interface{}) (err error) {
+	session := r.credential(&r.ubusSession)
+	if session == "" {
+		if session, err = r.renewCredential(ctx, &r.ubusSession, "", r.ubusLogin); err != nil {
+			return
+		}
+	}
+	err = r.ubusRawCall(ctx, session, object, method, args, result)
+	var ue *UbusError
+	if !errors.As(err, &ue) || ue.Code != ubusAccessDenied {
+		return
+	}
+
+	// 会话过期, 重新登录后重放请求; 新会话仍被拒绝说明是权限不足, 直接返回原始错误
+	if session, err = r.renewCredential(ctx, &r.ubusSession, session, r.ubusLogin); err != nil {
+		if !errors.Is(err, ErrAuthFailed) {
+			err = fmt.Errorf("%w: %v", ErrSessionExpired, err)
+		}
+		return
+	}
+	return r.ubusRawCall(ctx, session, object, method, args, result)
+}
+
+// ubusRawCall 使用指定会话调用ubus对象方法, result为空时忽略返回数据
+func (r *Router) ubusRawCall(ctx context.Context, session, object, method string, args interface{}, result interface{}) (err error) {
+	if args == nil {
+		args = struct{}{}
+	}
+
+	// 构建请求
+	payload, err := json.Marshal(&ubusRequest{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  "call",
+		Params:  []interface{}{session, object, method, args},
+	})
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url(UbusPath), bytes.NewReader(payload))
+	if err != nil {
+		return
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	// 服务请求
+	var rsp *http.Response
+	rsp, err = r.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = rsp.Body.Close()
+	}()
+
+	// 序列化数据
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return
+	}
+	data := &ubusResponse{}
+	if err = json.Unmarshal(body, data); err != nil {
+		return
+	}
+	if data.Error != nil {
+		err = &UbusError{Code: data.Error.Code, Message: data.Error.Message}
+		return
+	}
+	if len(data.Result) == 0 {
+		err = fmt.Errorf("ubus %s.%s returned empty result", object, method)
+		return
+	}
+	var status int
+	if err = json.Unmarshal(data.Result[0], &status); err != nil {
+		return
+	}
+	if status != 0 {
+		err = &UbusError{Code: status, Message: ubusStatusMessages[status]}
+		return
+	}
+	if result != nil && len(data.Result) > 1 {
+		err = json.Unmarshal(data.Result[1], result)
+	}
+	return
+}
+
+// uciSections 获取配置中指定类型的所有配置节, 按配置文件中的顺序排列
+func (r *Router) uciSections(ctx context.Context, config, typ string) (sections []uciSection, err error) {
+	args := map[string]interface{}{
+		"config": config,
+		"type":   typ,
+	}
+	data := &struct {
+		Values map[string]uciSection `json:"values"`
+	}{}
+	if err = r.ubusCall(ctx, "uci", "get", args, data); err != nil {
+		return
+	}
+	for _, section := range data.Values {
+		sections = append(sections, section)
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		return sections[i].index() < sections[j].index()
+	})
+	return
+}
+
+// uciFirstSectionName 获取配置中指定类型的第一个配置节名称, 对应uci的 @type[0]
+func (r *Router) uciFirstSectionName(ctx context.Context, config, typ string) (name string, err error) {
+	sections, err := r.uciSections(ctx, config, typ)
+	if err != nil {
+		return
+	}
+	if len(sections) == 0 {
+		err = fmt.Errorf("uci section %s.@%s[0] not found", config, typ)
+		return
+	}
+	name = sections[0].name()
+	return
+}
+
+// uciSet 设置配置节选项, 切片类型的值会写为列表选项
+func (r *Router) uciSet(ctx context.Context, config, section string, values map[string]interface{}) (err error) {
+	args := map[string]interface{}{
+		"config":  config,
+		"section": section,
+		"values":  values,
+	}
+	return r.ubusCall(ctx, "uci", "set", args, nil)
+}
+
+// uciAdd 新增匿名配置节并返回其名称
+func (r *Router) uciAdd(ctx context.Context, config, typ string, values map[string]interface{}) (name string, err error) {
+	args := map[string]interface{}{
+		"config": config,
+		"type":   typ,
+		"values": values,
+	}
+	data := &struct {
+		Section string `json:"section"`
+	}{}
+	if err = r.ubusCall(ctx, "uci", "add", args, data); err != nil {
+		return
+	}
+	name = data.Section
+	return
+}
+
+// uciDelete 删除配置节, 指定选项时只删除这些选项
+func (r *Router) uciDelete(ctx context.Context, config, section string, options ...string) (err error) {
+	args := map[string]interface{}{
+		"config":  config,
+		"section": section,
+	}
+	if len(options) != 0 {
+		args["options"] = options
+	}
+	return r.ubusCall(ctx, "uci", "delete", args, nil)
+}
+
+// uciCommit 提交配置修改
+func (r *Router) uciCommit(ctx context.Context, config string) (err error) {
+	return r.ubusCall(ctx, "uci", "commit", map[string]interface{}{"config": config}, nil)
+}
+
+// fileExec 在路由器上执行命令, 需要会话拥有对应命令的file权限
+func (r *Router) fileExec(ctx context.Context, command string, params ...string) (res *execResult, err error) {
+	args := map[string]interface{}{
+		"command": command,
+	}
+	if len(params) != 0 {
+		args["params"] = params
+	}
+	res = &execResult{}
+	if err = r.ubusCall(ctx, "file", "exec", args, res); err != nil {
+		return
+	}
+	return
+}
+
+// ubusListAllProxyNodeInfo 从vssr的uci配置中列出所有代理节点信息
+func (r *Router) ubusListAllProxyNodeInfo(ctx context.Context) (pns []*ProxyNodeInfo, err error) {
+	return r.uciProxyNodes(ctx, vssrConfig, "servers", "alias", "server", "server_port")
+}
+
+// uciProxyNodes 从代理插件的uci配置中列出节点, 各插件节点配置节类型及选项名称不同
+func (r *Router) uciProxyNodes(ctx context.Context, config, typ, nameOption, hostOption, portOption string) (pns []*ProxyNodeInfo, err error) {
+	sections, err := r.uciSections(ctx, config, typ)
+	if err != nil {
+		return
+	}
+	for _, section := range sections {
+		ins := &ProxyNodeInfo{
+			Id:   section.name(),
+			Host: section.str(hostOption),
+			Port: section.str(portOption),
+		}
+		if ins.Id == "" || ins.Host == "" || ins.Port == "" {
+			continue
+		}
+
+		// 与LuCI页面中的名称保持一致
+		ins.Name = section.str(nameOption)
+		ins.Name = strings.ReplaceAll(ins.Name, "\n", "")
+		ins.Name = strings.ReplaceAll(ins.Name, " ", "")
+		pns = append(pns, ins)
+	}
+	return
+}
+
+// ubusTestProxyNodeLatency 在路由器上执行TCP连接测速
+func (r *Router) ubusTestProxyNodeLatency(ctx context.Context, pn *ProxyNodeInfo) (err error) {
+	if !hostPattern.MatchString(pn.Host) || !portPattern.MatchString(pn.Port) {
+		err = fmt.Errorf("invalid proxy node address %s:%s", pn.Host, pn.Port)
+		return
+	}
+
+	res, err := r.fileExec(ctx, "/usr/bin/lua", "-e", fmt.Sprintf(vssrCheckPortScript, pn.Host, pn.Port))
+	if err != nil {
+		return
+	}
+	if res.Code != 0 {
+		err = fmt.Errorf("check port exited with code %d: %s", res.Code, res.Stderr)
+		return
+	}
+
+	// 序列化数据
+	fields := strings.Fields(res.Stdout)
+	if len(fields) != 2 {
+		err = fmt.Errorf("unexpected check port output %q", res.Stdout)
+		return
+	}
+	used, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return
+	}
+	pn.Offline = fields[0] == "0"
+	pn.Latency = used
+	return
+}
+
+// ubusApplyProxyNodeToGlobal 修改vssr全局节点并重启服务
+func (r *Router) ubusApplyProxyNodeToGlobal(ctx context.Context, p *ProxyNodeInfo) (err error) {
+	// 获取节点编号
+	var id string
+	if p == nil {
+		id = "nil"
+	} else {
+		id = p.Id
+	}
+
+	name, err := r.uciFirstSectionName(ctx, vssrConfig, "global")
+	if err != nil {
+		return
+	}
+	if err = r.uciSet(ctx, vssrConfig, name, map[string]interface{}{"global_server": id}); err != nil {
+		return
+	}
+	if err = r.uciCommit(ctx, vssrConfig); err != nil {
+		return
+	}
+
+	return r.initScript(ctx, vssrConfig, "restart")
+}
+
+// initScript 执行 /etc/init.d/<service> <action>
+func (r *Router) initScript(ctx context.Context, service, action string) (err error) {
+	res, err := r.fileExec(ctx, "/etc/init.d/"+service, action)
+	if err != nil {
+		return
+	}
+	if res.Code != 0 {
+		err = fmt.Errorf("%s %s exited with code %d: %s", action, service, res.Code, res.Stderr)
+	}
+	return
+}
+
+// backgroundExec 通过sh在后台执行脚本, 只等待脚本启动
+func (r *Router) backgroundExec(ctx context.Context, script string) (err error) {
+	res, err := r.fileExec(ctx, "/bin/sh", "-c", script)
+	if err != nil {
+		return
+	}
+	if res.Code != 0 {
+		err = fmt.Errorf("%q exited with code %d: %s", script, res.Code, res.Stderr)
+	}
+	return
+}
+
+// processList 获取路由器上所有进程的命令行
+func (r *Router) processList(ctx context.Context) (lines []string, err error) {
+	res, err := r.fileExec(ctx, "/bin/busybox", "ps", "-w")
+	if err != nil {
+		return
+	}
+	if res.Code != 0 {
+		err = fmt.Errorf("ps exited with code %d: %s", res.Code, res.Stderr)
+		return
+	}
+	return strings.Split(res.Stdout, "\n"), nil
+}
@@ -0,0 +1,218 @@
+package openwrt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newUbusTestServer(t *testing.T, handler func(object, method string, args map[string]interface{}) []interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != UbusPath {
+			http.NotFound(w, req)
+			return
+		}
+		data := &struct {
+			Params []json.RawMessage `json:"params"`
+		}{}
+		if err := json.NewDecoder(req.Body).Decode(data); err != nil || len(data.Params) != 4 {
+			t.Errorf("invalid ubus request %v", err)
+			return
+		}
+		var session, object, method string
+		args := map[string]interface{}{}
+		_ = json.Unmarshal(data.Params[0], &session)
+		_ = json.Unmarshal(data.Params[1], &object)
+		_ = json.Unmarshal(data.Params[2], &method)
+		_ = json.Unmarshal(data.Params[3], &args)
+		if object != "session" && session != "test-session" {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "error": map[string]interface{}{"code": -32002, "message": "Access denied"}})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": handler(object, method, args)})
+	}))
+}
+
+func TestRouter_UbusListAllProxyNodeInfo(t *testing.T) {
+	srv := newUbusTestServer(t, func(object, method string, args map[string]interface{}) []interface{} {
+		switch object + "." + method {
+		case "session.login":
+			return []interface{}{0, map[string]interface{}{"ubus_rpc_session": "test-session"}}
+		case "uci.get":
+			if args["config"] != "vssr" || args["type"] != "servers" {
+				return []interface{}{4}
+			}
+			return []interface{}{0, map[string]interface{}{"values": map[string]interface{}{
+				"cfg02": map[string]interface{}{".name": "cfg02", ".index": 2, "alias": "日本 01", "server": "jp.example.com", "server_port": "443"},
+				"cfg01": map[string]interface{}{".name": "cfg01", ".index": 1, "alias": "香港 01", "server": "hk.example.com", "server_port": "8388"},
+				"cfg03": map[string]interface{}{".name": "cfg03", ".index": 3, "alias": "broken"},
+			}}}
+		}
+		return []interface{}{3}
+	})
+	defer srv.Close()
+
+	r := NewRouterInstance(&RouterConfig{
+		Addr:     strings.TrimPrefix(srv.URL, "http://"),
+		Username: "root",
+		Password: "password",
+		Backend:  BackendUbus,
+	})
+	pns, err := r.ListAllProxyNodeInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pns) != 2 {
+		t.Fatalf("got %d nodes, want 2", len(pns))
+	}
+	want := ProxyNodeInfo{Name: "香港01", Id: "cfg01", Host: "hk.example.com", Port: "8388"}
+	if *pns[0] != want {
+		t.Errorf("got %+v, want %+v", *pns[0], want)
+	}
+	if pns[1].Id != "cfg02" {
+		t.Errorf("got %s, want cfg02", pns[1].Id)
+	}
+}
+
+func TestRouter_UbusTestProxyNodeLatency(t *testing.T) {
+	srv := newUbusTestServer(t, func(object, method string, args map[string]interface{}) []interface{} {
+		switch object + "." + method {
+		case "session.login":
+			return []interface{}{0, map[string]interface{}{"ubus_rpc_session": "test-session"}}
+		case "file.exec":
+			return []interface{}{0, map[string]interface{}{"code": 0, "stdout": "1 42"}}
+		}
+		return []interface{}{3}
+	})
+	defer srv.Close()
+
+	r := NewRouterInstance(&RouterConfig{
+		Addr:    strings.TrimPrefix(srv.URL, "http://"),
+		Backend: BackendUbus,
+	})
+	pn := &ProxyNodeInfo{Host: "hk.example.com", Port: "8388"}
+	if err := r.TestProxyNodeLatency(pn); err != nil {
+		t.Fatal(err)
+	}
+	if pn.Offline || pn.Latency != 42 {
+		t.Errorf("got offline=%v latency=%d, want online 42", pn.Offline, pn.Latency)
+	}
+
+	if err := r.TestProxyNodeLatency(&ProxyNodeInfo{Host: `x"; os.execute("reboot`, Port: "1"}); err == nil {
+		t.Error("expected invalid address error")
+	}
+}
+
+// uciTestStore 内存中的uci配置, 按 配置 -> 配置节名称 -> 配置节 组织, 配置节需要包含 .type
+type uciTestStore map[string]map[string]uciSection
+
+// newUciTestServer 模拟rpcd的uci及file对象, exec返回命令的标准输出
+func newUciTestServer(t *testing.T, store uciTestStore, exec func(command string, params []string) string) *httptest.Server {
+	return newUbusTestServer(t, uciTestHandler(store, exec))
+}
+
+// uciTestHandler newUciTestServer使用的ubus处理函数, 便于在其他测试中组合
+func uciTestHandler(store uciTestStore, exec func(command string, params []string) string) func(object, method string, args map[string]interface{}) []interface{} {
+	var mu sync.Mutex
+	added := 0
+	return func(object, method string, args map[string]interface{}) []interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		config, _ := args["config"].(string)
+		sectionName, _ := args["section"].(string)
+		switch object + "." + method {
+		case "session.login":
+			return []interface{}{0, map[string]interface{}{"ubus_rpc_session": "test-session"}}
+		case "uci.get":
+			sections, ok := store[config]
+			if !ok {
+				return []interface{}{4}
+			}
+			if sectionName != "" {
+				section, ok := sections[sectionName]
+				if !ok {
+					return []interface{}{4}
+				}
+				return []interface{}{0, map[string]interface{}{"values": section}}
+			}
+			values := map[string]interface{}{}
+			for name, section := range sections {
+				if typ, _ := args["type"].(string); typ == "" || section[".type"] == typ {
+					values[name] = section
+				}
+			}
+			return []interface{}{0, map[string]interface{}{"values": values}}
+		case "uci.set":
+			section, ok := store[config][sectionName]
+			if !ok {
+				return []interface{}{4}
+			}
+			values, _ := args["values"].(map[string]interface{})
+			for option, val := range values {
+				section[option] = val
+			}
+			return []interface{}{0}
+		case "uci.add":
+			if store[config] == nil {
+				return []interface{}{4}
+			}
+			added++
+			name := fmt.Sprintf("cfgadd%02d", added)
+			section := uciSection{".name": name, ".type": args["type"], ".anonymous": true, ".index": float64(len(store[config]))}
+			values, _ := args["values"].(map[string]interface{})
+			for option, val := range values {
+				section[option] = val
+			}
+			store[config][name] = section
+			return []interface{}{0, map[string]interface{}{"section": name}}
+		case "uci.delete":
+			section, ok := store[config][sectionName]
+			if !ok {
+				return []interface{}{4}
+			}
+			options, ok := args["options"].([]interface{})
+			if !ok {
+				delete(store[config], sectionName)
+				return []interface{}{0}
+			}
+			for _, option := range options {
+				delete(section, fmt.Sprint(option))
+			}
+			return []interface{}{0}
+		case "uci.commit", "uci.apply", "uci.confirm":
+			return []interface{}{0}
+		case "network.wireless.status":
+			// 根据无线配置生成运行状态, 系统接口名称与配置节名称相同
+			status := map[string]interface{}{}
+			for name, section := range store["wireless"] {
+				if section[".type"] == "wifi-device" {
+					status[name] = map[string]interface{}{"up": section["disabled"] != "1", "interfaces": []interface{}{}}
+				}
+			}
+			for name, section := range store["wireless"] {
+				radio, ok := status[fmt.Sprint(section["device"])].(map[string]interface{})
+				if section[".type"] != "wifi-iface" || !ok || section["disabled"] == "1" {
+					continue
+				}
+				radio["interfaces"] = append(radio["interfaces"].([]interface{}), map[string]interface{}{"section": name, "ifname": name})
+			}
+			return []interface{}{0, status}
+		case "iwinfo.assoclist":
+			return []interface{}{0, map[string]interface{}{"results": []interface{}{map[string]interface{}{"mac": "aa:bb:cc:00:00:01", "signal": -60}}}}
+		case "file.exec":
+			command, _ := args["command"].(string)
+			var params []string
+			if items, ok := args["params"].([]interface{}); ok {
+				for _, item := range items {
+					params = append(params, fmt.Sprint(item))
+				}
+			}
+			return []interface{}{0, map[string]interface{}{"code": 0, "stdout": exec(command, params)}}
+		}
+		return []interface{}{3}
+	}
+}
@@ -0,0 +1,406 @@
+package openwrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultUCIApplyTimeout = 90 * time.Second // 默认回滚超时时间, 与LuCI的apply_rollback一致
+	DefaultUCIApplyHoldoff = 4 * time.Second  // 默认应用后等待多久开始确认, 与LuCI的apply_holdoff一致
+	uciConfirmInterval     = time.Second      // 确认失败后的重试间隔
+)
+
+var ErrUCIRolledBack = errors.New("uci changes rolled back") // 超时未能确认, 修改已被路由器回滚
+
+// UCISection uci配置节
+type UCISection struct {
+	Name      string                 // 配置节名称, 匿名配置节为自动生成的cfgXXXXXX
+	Type      string                 // 配置节类型
+	Anonymous bool                   // 是否为匿名配置节
+	Index     int                    // 在配置文件中的顺序
+	Options   map[string]interface{} // 选项值, 类型为string或[]string
+}
+
+// Get 获取选项值, 列表选项以空格连接, 与uci get的输出一致
+func (s *UCISection) Get(option string) string {
+	switch val := s.Options[option].(type) {
+	case string:
+		return val
+	case []string:
+		return strings.Join(val, " ")
+	}
+	return ""
+}
+
+// List 获取列表选项值, 字符串选项按单个元素返回
+func (s *UCISection) List(option string) []string {
+	switch val := s.Options[option].(type) {
+	case string:
+		return []string{val}
+	case []string:
+		return append([]string(nil), val...)
+	}
+	return nil
+}
+
+// Decode 将配置节解码到结构体, 字段通过 `uci:"option"` 标签指定选项名称, 未指定时使用小写的字段名称;
+// 标签 ".name" 和 ".type" 对应配置节名称和类型, "-" 表示忽略; 支持string, bool, 整数及[]string字段
+func (s *UCISection) Decode(v interface{}) (err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("decode uci section into non-struct pointer %T", v)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		option := field.Tag.Get("uci")
+		switch option {
+		case "-":
+			continue
+		case "":
+			option = strings.ToLower(field.Name)
+		}
+
+		var items []string
+		switch option {
+		case ".name":
+			items = []string{s.Name}
+		case ".type":
+			items = []string{s.Type}
+		default:
+			items = s.List(option)
+		}
+		if len(items) == 0 {
+			continue
+		}
+		if err = setUCIField(rv.Field(i), items); err != nil {
+			return fmt.Errorf("decode %s.%s: %w", s.Name, option, err)
+		}
+	}
+	return
+}
+
+// setUCIField 按字段类型设置选项值
+func setUCIField(fv reflect.Value, items []string) (err error) {
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(strings.Join(items, " "))
+	case reflect.Bool:
+		switch items[0] {
+		case "1", "on", "yes", "true", "enabled":
+			fv.SetBool(true)
+		case "0", "off", "no", "false", "disabled":
+			fv.SetBool(false)
+		default:
+			err = fmt.Errorf("invalid boolean %q", items[0])
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(items[0], 10, fv.Type().Bits()); err == nil {
+			fv.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		if n, err = strconv.ParseUint(items[0], 10, fv.Type().Bits()); err == nil {
+			fv.SetUint(n)
+		}
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type %s", fv.Type())
+		}
+		fv.Set(reflect.ValueOf(append([]string(nil), items...)).Convert(fv.Type()))
+	default:
+		err = fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+	return
+}
+
+// newUCISection 转换ubus返回的配置节
+func newUCISection(s uciSection) *UCISection {
+	section := &UCISection{
+		Name:    s.name(),
+		Type:    s.str(".type"),
+		Index:   s.index(),
+		Options: map[string]interface{}{},
+	}
+	section.Anonymous, _ = s[".anonymous"].(bool)
+	for option, val := range s {
+		if strings.HasPrefix(option, ".") {
+			continue
+		}
+		if _, ok := val.([]interface{}); ok {
+			section.Options[option] = s.list(option)
+		} else {
+			section.Options[option] = s.str(option)
+		}
+	}
+	return section
+}
+
+// UCIChange 尚未提交的uci修改
+type UCIChange struct {
+	Config  string // 配置名称
+	Op      string // 操作类型, 如 set/add/remove/list-add/list-del/rename/order
+	Section string // 配置节名称
+	Option  string // 选项名称, 对配置节本身操作时为空
+	Value   string // 选项值; 新增配置节时为配置节类型
+}
+
+func (c *UCIChange) String() string {
+	target := c.Config + "." + c.Section
+	if c.Option != "" {
+		target += "." + c.Option
+	}
+	switch c.Op {
+	case "remove":
+		return "-" + target
+	case "list-add":
+		return target + "+='" + c.Value + "'"
+	case "list-del":
+		return target + "-='" + c.Value + "'"
+	}
+	return target + "='" + c.Value + "'"
+}
+
+// UCIApplyOptions 应用修改的参数, 为空的字段使用默认值
+type UCIApplyOptions struct {
+	Timeout time.Duration // 超时未确认时路由器回滚修改
+	Holdoff time.Duration // 应用后等待多久开始确认, 需要足够网络等服务重新加载
+}
+
+// GetUCIConfig 读取配置中的所有配置节, 按配置文件中的顺序排列
+func (r *Router) GetUCIConfig(config string) (sections []*UCISection, err error) {
+	return r.GetUCIConfigContext(context.Background(), config)
+}
+
+// GetUCIConfigContext 读取配置中的所有配置节, 按配置文件中的顺序排列
+func (r *Router) GetUCIConfigContext(ctx context.Context, config string) (sections []*UCISection, err error) {
+	return r.GetUCISectionsContext(ctx, config, "")
+}
+
+// GetUCISections 读取配置中指定类型的配置节, 类型为空时读取所有配置节
+func (r *Router) GetUCISections(config, typ string) (sections []*UCISection, err error) {
+	return r.GetUCISectionsContext(context.Background(), config, typ)
+}
+
+// GetUCISectionsContext 读取配置中指定类型的配置节, 类型为空时读取所有配置节
+func (r *Router) GetUCISectionsContext(ctx context.Context, config, typ string) (sections []*UCISection, err error) {
+	args := map[string]interface{}{
+		"config": config,
+	}
+	if typ != "" {
+		args["type"] = typ
+	}
+	data := &struct {
+		Values map[string]uciSection `json:"values"`
+	}{}
+	if err = r.ubusCall(ctx, "uci", "get", args, data); err != nil {
+		return
+	}
+	for _, section := range data.Values {
+		sections = append(sections, newUCISection(section))
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		return sections[i].Index < sections[j].Index
+	})
+	return
+}
+
+// GetUCISection 读取配置节, 名称支持 @type[index] 形式
+func (r *Router) GetUCISection(config, section string) (s *UCISection, err error) {
+	return r.GetUCISectionContext(context.Background(), config, section)
+}
+
+// GetUCISectionContext 读取配置节, 名称支持 @type[index] 形式
+func (r *Router) GetUCISectionContext(ctx context.Context, config, section string) (s *UCISection, err error) {
+	args := map[string]interface{}{
+		"config":  config,
+		"section": section,
+	}
+	data := &struct {
+		Values uciSection `json:"values"`
+	}{}
+	if err = r.ubusCall(ctx, "uci", "get", args, data); err != nil {
+		return
+	}
+	s = newUCISection(data.Values)
+	return
+}
+
+// GetUCIOption 读取选项值, 列表选项以空格连接
+func (r *Router) GetUCIOption(config, section, option string) (value string, err error) {
+	return r.GetUCIOptionContext(context.Background(), config, section, option)
+}
+
+// GetUCIOptionContext 读取选项值, 列表选项以空格连接
+func (r *Router) GetUCIOptionContext(ctx context.Context, config, section, option string) (value string, err error) {
+	s, err := r.GetUCISectionContext(ctx, config, section)
+	if err != nil {
+		return
+	}
+	if _, ok := s.Options[option]; !ok {
+		err = fmt.Errorf("uci option %s.%s.%s not found", config, section, option)
+		return
+	}
+	value = s.Get(option)
+	return
+}
+
+// SetUCI 暂存配置节选项修改, 切片类型的值会写为列表选项; 修改保存在ubus会话中, 提交或应用前重新登录会丢失
+func (r *Router) SetUCI(config, section string, values map[string]interface{}) (err error) {
+	return r.SetUCIContext(context.Background(), config, section, values)
+}
+
+// SetUCIContext 暂存配置节选项修改, 切片类型的值会写为列表选项; 修改保存在ubus会话中, 提交或应用前重新登录会丢失
+func (r *Router) SetUCIContext(ctx context.Context, config, section string, values map[string]interface{}) (err error) {
+	return r.uciSet(ctx, config, section, values)
+}
+
+// AddUCISection 暂存新增的匿名配置节, 返回其名称
+func (r *Router) AddUCISection(config, typ string, values map[string]interface{}) (name string, err error) {
+	return r.AddUCISectionContext(context.Background(), config, typ, values)
+}
+
+// AddUCISectionContext 暂存新增的匿名配置节, 返回其名称
+func (r *Router) AddUCISectionContext(ctx context.Context, config, typ string, values map[string]interface{}) (name string, err error) {
+	return r.uciAdd(ctx, config, typ, values)
+}
+
+// DeleteUCI 暂存删除操作, 指定选项时只删除这些选项, 否则删除整个配置节
+func (r *Router) DeleteUCI(config, section string, options ...string) (err error) {
+	return r.DeleteUCIContext(context.Background(), config, section, options...)
+}
+
+// DeleteUCIContext 暂存删除操作, 指定选项时只删除这些选项, 否则删除整个配置节
+func (r *Router) DeleteUCIContext(ctx context.Context, config, section string, options ...string) (err error) {
+	return r.uciDelete(ctx, config, section, options...)
+}
+
+// GetUCIChanges 列出当前会话中尚未提交的修改
+func (r *Router) GetUCIChanges() (changes []*UCIChange, err error) {
+	return r.GetUCIChangesContext(context.Background())
+}
+
+// GetUCIChangesContext 列出当前会话中尚未提交的修改
+func (r *Router) GetUCIChangesContext(ctx context.Context) (changes []*UCIChange, err error) {
+	data := &struct {
+		Changes map[string][][]string `json:"changes"`
+	}{}
+	if err = r.ubusCall(ctx, "uci", "changes", nil, data); err != nil {
+		return
+	}
+	configs := make([]string, 0, len(data.Changes))
+	for config := range data.Changes {
+		configs = append(configs, config)
+	}
+	sort.Strings(configs)
+
+	for _, config := range configs {
+		for _, item := range data.Changes[config] {
+			if len(item) < 2 {
+				continue
+			}
+			change := &UCIChange{Config: config, Op: item[0], Section: item[1]}
+			switch {
+			case len(item) >= 4:
+				change.Option, change.Value = item[2], item[3]
+			case len(item) == 3 && change.Op == "remove":
+				change.Option = item[2]
+			case len(item) == 3:
+				change.Value = item[2]
+			}
+			changes = append(changes, change)
+		}
+	}
+	return
+}
+
+// RevertUCI 丢弃配置中尚未提交的修改
+func (r *Router) RevertUCI(config string) (err error) {
+	return r.RevertUCIContext(context.Background(), config)
+}
+
+// RevertUCIContext 丢弃配置中尚未提交的修改
+func (r *Router) RevertUCIContext(ctx context.Context, config string) (err error) {
+	return r.ubusCall(ctx, "uci", "revert", map[string]interface{}{"config": config}, nil)
+}
+
+// CommitUCI 直接提交配置修改, 不会重新加载服务也不会回滚
+func (r *Router) CommitUCI(config string) (err error) {
+	return r.CommitUCIContext(context.Background(), config)
+}
+
+// CommitUCIContext 直接提交配置修改, 不会重新加载服务也不会回滚
+func (r *Router) CommitUCIContext(ctx context.Context, config string) (err error) {
+	return r.uciCommit(ctx, config)
+}
+
+// ApplyUCI 以LuCI的确认或回滚方式应用所有暂存修改
+func (r *Router) ApplyUCI(opts *UCIApplyOptions) (err error) {
+	return r.ApplyUCIContext(context.Background(), opts)
+}
+
+// ApplyUCIContext 以LuCI的确认或回滚方式应用所有暂存修改: 提交并重新加载服务后,
+// 在超时时间内重新连接路由器确认修改; 无法确认时路由器自动回滚并返回 ErrUCIRolledBack
+func (r *Router) ApplyUCIContext(ctx context.Context, opts *UCIApplyOptions) (err error) {
+	timeout, holdoff := DefaultUCIApplyTimeout, DefaultUCIApplyHoldoff
+	if opts != nil && opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+	if opts != nil && opts.Holdoff > 0 {
+		holdoff = opts.Holdoff
+	}
+
+	// 确认必须使用发起应用的会话, 因此之后不能重新登录
+	args := map[string]interface{}{
+		"rollback": true,
+		"timeout":  int(timeout / time.Second),
+	}
+	if err = r.ubusCall(ctx, "uci", "apply", args, nil); err != nil {
+		return
+	}
+	session := r.credential(&r.ubusSession)
+	deadline := time.Now().Add(timeout)
+
+	wait := holdoff
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait = uciConfirmInterval
+
+		confirmCtx, cancel := context.WithDeadline(ctx, deadline)
+		err = r.ubusRawCall(confirmCtx, session, "uci", "confirm", nil, nil)
+		cancel()
+		if err == nil {
+			return
+		}
+		var ue *UbusError
+		if errors.As(err, &ue) {
+			// 没有待确认的修改说明已经回滚
+			if ue.Code == ubusStatusNoData {
+				return fmt.Errorf("%w: %v", ErrUCIRolledBack, err)
+			}
+			return
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("%w: %v", ErrUCIRolledBack, err)
+		}
+	}
+}
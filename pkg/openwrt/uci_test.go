@@ -0,0 +1,147 @@
+package openwrt
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouter_GetUCI(t *testing.T) {
+	srv := newUciTestServer(t, uciTestStore{
+		"network": {
+			"lan": {".name": "lan", ".type": "interface", ".index": float64(1), "proto": "static", "ipaddr": "192.168.2.1", "dns": []interface{}{"1.1.1.1", "8.8.8.8"}, "delegate": "0", "mtu": "1500"},
+			"wan": {".name": "wan", ".type": "interface", ".index": float64(2), "proto": "dhcp"},
+			"cfg": {".name": "cfg", ".type": "globals", ".index": float64(0), ".anonymous": true, "ula_prefix": "fd00::/48"},
+		},
+	}, nil)
+	defer srv.Close()
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+
+	sections, err := r.GetUCIConfig("network")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sections) != 3 || sections[0].Name != "cfg" || !sections[0].Anonymous || sections[1].Name != "lan" {
+		t.Fatalf("got sections %+v", sections)
+	}
+	if sections, err = r.GetUCISections("network", "interface"); err != nil || len(sections) != 2 {
+		t.Fatalf("got %d interfaces %v", len(sections), err)
+	}
+
+	lan, err := r.GetUCISection("network", "lan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface := &struct {
+		Name     string   `uci:".name"`
+		Proto    string   `uci:"proto"`
+		IPAddr   string   `uci:"ipaddr"`
+		DNS      []string `uci:"dns"`
+		Delegate bool     `uci:"delegate"`
+		MTU      int
+		Ignored  string `uci:"-"`
+	}{}
+	if err = lan.Decode(iface); err != nil {
+		t.Fatal(err)
+	}
+	if iface.Name != "lan" || iface.IPAddr != "192.168.2.1" || iface.Delegate || iface.MTU != 1500 || !reflect.DeepEqual(iface.DNS, []string{"1.1.1.1", "8.8.8.8"}) {
+		t.Errorf("got %+v", iface)
+	}
+
+	if dns, err := r.GetUCIOption("network", "lan", "dns"); err != nil || dns != "1.1.1.1 8.8.8.8" {
+		t.Errorf("got %q %v", dns, err)
+	}
+	if _, err = r.GetUCIOption("network", "lan", "gateway"); err == nil {
+		t.Error("expected missing option error")
+	}
+}
+
+// newUCIApplyTestServer 模拟暂存修改及确认回滚流程, confirm决定确认请求的响应
+func newUCIApplyTestServer(t *testing.T, confirm func() []interface{}) (r *Router, applied *[]map[string]interface{}) {
+	applied = &[]map[string]interface{}{}
+	var changes [][]string
+	srv := newUbusTestServer(t, func(object, method string, args map[string]interface{}) []interface{} {
+		switch object + "." + method {
+		case "session.login":
+			return []interface{}{0, map[string]interface{}{"ubus_rpc_session": "test-session"}}
+		case "uci.set":
+			values, _ := args["values"].(map[string]interface{})
+			for option, val := range values {
+				changes = append(changes, []string{"set", args["section"].(string), option, val.(string)})
+			}
+			return []interface{}{0}
+		case "uci.add":
+			changes = append(changes, []string{"add", "cfg0ad2e4", args["type"].(string)})
+			return []interface{}{0, map[string]interface{}{"section": "cfg0ad2e4"}}
+		case "uci.delete":
+			changes = append(changes, []string{"remove", args["section"].(string)})
+			return []interface{}{0}
+		case "uci.changes":
+			return []interface{}{0, map[string]interface{}{"changes": map[string]interface{}{"firewall": changes}}}
+		case "uci.apply":
+			*applied = append(*applied, args)
+			return []interface{}{0}
+		case "uci.confirm":
+			return confirm()
+		}
+		return []interface{}{3}
+	})
+	t.Cleanup(srv.Close)
+	r = NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+	return
+}
+
+func TestRouter_ApplyUCI(t *testing.T) {
+	r, applied := newUCIApplyTestServer(t, func() []interface{} { return []interface{}{0} })
+
+	if err := r.SetUCI("firewall", "wan", map[string]interface{}{"input": "DROP"}); err != nil {
+		t.Fatal(err)
+	}
+	name, err := r.AddUCISection("firewall", "rule", map[string]interface{}{})
+	if err != nil || name != "cfg0ad2e4" {
+		t.Fatalf("got %q %v", name, err)
+	}
+	if err = r.DeleteUCI("firewall", "cfg01"); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := r.GetUCIChanges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, change := range changes {
+		got = append(got, change.String())
+	}
+	want := []string{"firewall.wan.input='DROP'", "firewall.cfg0ad2e4='rule'", "-firewall.cfg01"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got changes %v, want %v", got, want)
+	}
+
+	if err = r.ApplyUCI(&UCIApplyOptions{Timeout: 30 * time.Second, Holdoff: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*applied) != 1 || (*applied)[0]["rollback"] != true || (*applied)[0]["timeout"] != float64(30) {
+		t.Errorf("got apply args %v", *applied)
+	}
+}
+
+func TestRouter_ApplyUCIRolledBack(t *testing.T) {
+	cases := map[string]func() []interface{}{
+		// 路由器已经回滚, 没有待确认的修改
+		"no data": func() []interface{} { return []interface{}{5} },
+		// 修改导致无法连接路由器
+		"unreachable": func() []interface{} { panic(http.ErrAbortHandler) },
+	}
+	for name, confirm := range cases {
+		t.Run(name, func(t *testing.T) {
+			r, _ := newUCIApplyTestServer(t, confirm)
+			err := r.ApplyUCI(&UCIApplyOptions{Timeout: time.Second, Holdoff: time.Millisecond})
+			if !errors.Is(err, ErrUCIRolledBack) {
+				t.Errorf("got %v, want ErrUCIRolledBack", err)
+			}
+		})
+	}
+}
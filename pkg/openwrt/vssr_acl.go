@@ -0,0 +1,401 @@
+package openwrt
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// RunMode vssr运行模式
+type RunMode string
+
+const (
+	RunModeGFW     RunMode = "gfw"     // GFW列表模式, 只代理列表中的域名
+	RunModeRouter  RunMode = "router"  // 绕过中国大陆IP
+	RunModeGlobal  RunMode = "all"     // 全局代理
+	RunModeOversea RunMode = "oversea" // 海外用户回国
+)
+
+// RunModes vssr支持的所有运行模式
+var RunModes = []RunMode{RunModeGFW, RunModeRouter, RunModeGlobal, RunModeOversea}
+
+// DomainList vssr的自定义域名列表
+type DomainList string
+
+const (
+	DomainWhitelist DomainList = "white" // 不走代理的域名
+	DomainBlacklist DomainList = "black" // 强制走代理的域名
+)
+
+// path 域名列表在路由器上的文件路径
+func (l DomainList) path() string {
+	return "/etc/vssr/" + string(l) + ".list"
+}
+
+// ACLList vssr访问控制中的IP列表, 取值为access_control配置节中的选项名称
+type ACLList string
+
+const (
+	ACLLANClients    ACLList = "lan_ac_ips" // 局域网访问控制的设备IP, 按 LANAccessMode 代理或绕过
+	ACLLANForceProxy ACLList = "lan_fp_ips" // 始终走全局代理的局域网设备IP
+	ACLWANBypass     ACLList = "wan_bp_ips" // 不走代理的外网IP
+	ACLWANForceProxy ACLList = "wan_fw_ips" // 强制走代理的外网IP
+)
+
+// 局域网访问控制模式
+const (
+	LANAccessDisabled  = "0" // 所有设备按运行模式代理
+	LANAccessWhitelist = "w" // 只代理 ACLLANClients 中的设备
+	LANAccessBlacklist = "b" // ACLLANClients 中的设备不走代理
+)
+
+const vssrACLType = "access_control" // vssr访问控制的配置节类型
+
+var domainPattern = regexp.MustCompile(`^([0-9A-Za-z_-]+\.)*[0-9A-Za-z_-]+\.?$`)
+
+// VssrAccessControl vssr的访问控制设置
+type VssrAccessControl struct {
+	LANAccessMode string   // 局域网访问控制模式, 取值为 LANAccess* 常量
+	LANClients    []string // 局域网访问控制的设备IP
+	LANForceProxy []string // 始终走全局代理的局域网设备IP
+	WANBypass     []string // 不走代理的外网IP或网段
+	WANForceProxy []string // 强制走代理的外网IP或网段
+}
+
+// GetVssrRunMode 获取vssr运行模式
+func (r *Router) GetVssrRunMode() (mode RunMode, err error) {
+	return r.GetVssrRunModeContext(context.Background())
+}
+
+// GetVssrRunModeContext 获取vssr运行模式
+func (r *Router) GetVssrRunModeContext(ctx context.Context) (mode RunMode, err error) {
+	options, err := r.vssrGlobalOptions(ctx)
+	if err != nil {
+		return
+	}
+	mode = RunMode(options["run_mode"])
+	return
+}
+
+// SetVssrRunMode 修改vssr运行模式
+func (r *Router) SetVssrRunMode(mode RunMode) (err error) {
+	return r.SetVssrRunModeContext(context.Background(), mode)
+}
+
+// SetVssrRunModeContext 修改vssr运行模式并重启vssr
+func (r *Router) SetVssrRunModeContext(ctx context.Context, mode RunMode) (err error) {
+	valid := false
+	for _, m := range RunModes {
+		valid = valid || m == mode
+	}
+	if !valid {
+		return fmt.Errorf("unsupported run mode %q", mode)
+	}
+	name, err := r.uciFirstSectionName(ctx, vssrConfig, "global")
+	if err != nil {
+		return
+	}
+	if err = r.uciSet(ctx, vssrConfig, name, map[string]interface{}{"run_mode": string(mode)}); err != nil {
+		return
+	}
+	if err = r.uciCommit(ctx, vssrConfig); err != nil {
+		return
+	}
+	return r.initScript(ctx, vssrConfig, "restart")
+}
+
+// ListVssrDomains 列出自定义域名列表
+func (r *Router) ListVssrDomains(list DomainList) (domains []string, err error) {
+	return r.ListVssrDomainsContext(context.Background(), list)
+}
+
+// ListVssrDomainsContext 列出自定义域名列表, 忽略空行及注释, 文件不存在时为空
+func (r *Router) ListVssrDomainsContext(ctx context.Context, list DomainList) (domains []string, err error) {
+	lines, err := r.vssrDomainLines(ctx, list)
+	if err != nil {
+		return
+	}
+	for _, line := range lines {
+		if domain := domainLine(line); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return
+}
+
+// AddVssrDomains 向自定义域名列表添加域名
+func (r *Router) AddVssrDomains(list DomainList, domains ...string) (added int, err error) {
+	return r.AddVssrDomainsContext(context.Background(), list, domains...)
+}
+
+// AddVssrDomainsContext 向自定义域名列表末尾添加域名, 已存在的域名(不区分大小写)跳过;
+// 有新增时写回文件并重启vssr, 返回新增的数量
+func (r *Router) AddVssrDomainsContext(ctx context.Context, list DomainList, domains ...string) (added int, err error) {
+	for _, domain := range domains {
+		if !domainPattern.MatchString(domain) {
+			return 0, fmt.Errorf("invalid domain %q", domain)
+		}
+	}
+	lines, err := r.vssrDomainLines(ctx, list)
+	if err != nil {
+		return
+	}
+	exists := map[string]bool{}
+	for _, line := range lines {
+		exists[strings.ToLower(domainLine(line))] = true
+	}
+	for _, domain := range domains {
+		if key := strings.ToLower(domain); !exists[key] {
+			exists[key] = true
+			lines = append(lines, domain)
+			added++
+		}
+	}
+	if added == 0 {
+		return
+	}
+	if err = r.writeVssrDomainLines(ctx, list, lines); err != nil {
+		return 0, err
+	}
+	return
+}
+
+// RemoveVssrDomains 从自定义域名列表删除域名
+func (r *Router) RemoveVssrDomains(list DomainList, domains ...string) (removed int, err error) {
+	return r.RemoveVssrDomainsContext(context.Background(), list, domains...)
+}
+
+// RemoveVssrDomainsContext 从自定义域名列表删除域名(不区分大小写), 注释保持不变;
+// 有删除时写回文件并重启vssr, 返回删除的行数
+func (r *Router) RemoveVssrDomainsContext(ctx context.Context, list DomainList, domains ...string) (removed int, err error) {
+	lines, err := r.vssrDomainLines(ctx, list)
+	if err != nil {
+		return
+	}
+	drop := map[string]bool{}
+	for _, domain := range domains {
+		drop[strings.ToLower(domain)] = true
+	}
+	kept := lines[:0]
+	for _, line := range lines {
+		if domain := domainLine(line); domain != "" && drop[strings.ToLower(domain)] {
+			removed++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if removed == 0 {
+		return
+	}
+	if err = r.writeVssrDomainLines(ctx, list, kept); err != nil {
+		return 0, err
+	}
+	return
+}
+
+// vssrDomainLines 读取域名列表文件的所有行, 不含末尾的空行
+func (r *Router) vssrDomainLines(ctx context.Context, list DomainList) (lines []string, err error) {
+	if list != DomainWhitelist && list != DomainBlacklist {
+		return nil, fmt.Errorf("unknown domain list %q", list)
+	}
+	data, err := r.fileRead(ctx, list.path())
+	if isUbusNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	data = strings.TrimRight(data, "\n")
+	if data == "" {
+		return
+	}
+	return strings.Split(data, "\n"), nil
+}
+
+// writeVssrDomainLines 写回域名列表文件并重启vssr使dnsmasq规则生效
+func (r *Router) writeVssrDomainLines(ctx context.Context, list DomainList, lines []string) (err error) {
+	data := strings.Join(lines, "\n")
+	if data != "" {
+		data += "\n"
+	}
+	if err = r.fileWrite(ctx, list.path(), data); err != nil {
+		return
+	}
+	return r.initScript(ctx, vssrConfig, "restart")
+}
+
+// domainLine 域名列表文件中一行的域名, 空行及注释为空
+func domainLine(line string) string {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "#") {
+		return ""
+	}
+	return line
+}
+
+// GetVssrAccessControl 获取vssr访问控制设置
+func (r *Router) GetVssrAccessControl() (acl *VssrAccessControl, err error) {
+	return r.GetVssrAccessControlContext(context.Background())
+}
+
+// GetVssrAccessControlContext 获取vssr访问控制设置, 没有访问控制配置节时返回默认值
+func (r *Router) GetVssrAccessControlContext(ctx context.Context) (acl *VssrAccessControl, err error) {
+	sections, err := r.uciSections(ctx, vssrConfig, vssrACLType)
+	if err != nil {
+		return
+	}
+	acl = &VssrAccessControl{LANAccessMode: LANAccessDisabled}
+	if len(sections) == 0 {
+		return
+	}
+	s := sections[0]
+	if mode := s.str("lan_ac_mode"); mode != "" {
+		acl.LANAccessMode = mode
+	}
+	acl.LANClients = s.list(string(ACLLANClients))
+	acl.LANForceProxy = s.list(string(ACLLANForceProxy))
+	acl.WANBypass = s.list(string(ACLWANBypass))
+	acl.WANForceProxy = s.list(string(ACLWANForceProxy))
+	return
+}
+
+// SetVssrLANAccessMode 修改局域网访问控制模式
+func (r *Router) SetVssrLANAccessMode(mode string) (err error) {
+	return r.SetVssrLANAccessModeContext(context.Background(), mode)
+}
+
+// SetVssrLANAccessModeContext 修改局域网访问控制模式并重启vssr
+func (r *Router) SetVssrLANAccessModeContext(ctx context.Context, mode string) (err error) {
+	switch mode {
+	case LANAccessDisabled, LANAccessWhitelist, LANAccessBlacklist:
+	default:
+		return fmt.Errorf("unsupported lan access mode %q", mode)
+	}
+	return r.updateVssrACL(ctx, func(s uciSection) (map[string]interface{}, []string) {
+		return map[string]interface{}{"lan_ac_mode": mode}, nil
+	})
+}
+
+// AddVssrACLEntries 向访问控制列表添加IP
+func (r *Router) AddVssrACLEntries(list ACLList, entries ...string) (added int, err error) {
+	return r.AddVssrACLEntriesContext(context.Background(), list, entries...)
+}
+
+// AddVssrACLEntriesContext 向访问控制列表添加IP或网段, 已存在的跳过; 有新增时重启vssr, 返回新增的数量
+func (r *Router) AddVssrACLEntriesContext(ctx context.Context, list ACLList, entries ...string) (added int, err error) {
+	if err = validateACLList(list); err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if _, _, cidrErr := net.ParseCIDR(entry); cidrErr != nil && net.ParseIP(entry) == nil {
+			return 0, fmt.Errorf("invalid ip or network %q", entry)
+		}
+	}
+	err = r.updateVssrACL(ctx, func(s uciSection) (map[string]interface{}, []string) {
+		items := s.list(string(list))
+		exists := map[string]bool{}
+		for _, item := range items {
+			exists[item] = true
+		}
+		for _, entry := range entries {
+			if !exists[entry] {
+				exists[entry] = true
+				items = append(items, entry)
+				added++
+			}
+		}
+		if added == 0 {
+			return nil, nil
+		}
+		return map[string]interface{}{string(list): items}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return
+}
+
+// RemoveVssrACLEntries 从访问控制列表删除IP
+func (r *Router) RemoveVssrACLEntries(list ACLList, entries ...string) (removed int, err error) {
+	return r.RemoveVssrACLEntriesContext(context.Background(), list, entries...)
+}
+
+// RemoveVssrACLEntriesContext 从访问控制列表删除IP或网段; 有删除时重启vssr, 返回删除的数量
+func (r *Router) RemoveVssrACLEntriesContext(ctx context.Context, list ACLList, entries ...string) (removed int, err error) {
+	if err = validateACLList(list); err != nil {
+		return
+	}
+	drop := map[string]bool{}
+	for _, entry := range entries {
+		drop[entry] = true
+	}
+	err = r.updateVssrACL(ctx, func(s uciSection) (map[string]interface{}, []string) {
+		var kept []string
+		for _, item := range s.list(string(list)) {
+			if drop[item] {
+				removed++
+				continue
+			}
+			kept = append(kept, item)
+		}
+		switch {
+		case removed == 0:
+			return nil, nil
+		case len(kept) == 0:
+			return nil, []string{string(list)}
+		}
+		return map[string]interface{}{string(list): kept}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return
+}
+
+// updateVssrACL 按update返回的选项修改访问控制配置节(不存在时新建), 两者均为空时不做修改;
+// 修改后提交并重启vssr
+func (r *Router) updateVssrACL(ctx context.Context, update func(s uciSection) (set map[string]interface{}, unset []string)) (err error) {
+	sections, err := r.uciSections(ctx, vssrConfig, vssrACLType)
+	if err != nil {
+		return
+	}
+	section := uciSection{}
+	if len(sections) != 0 {
+		section = sections[0]
+	}
+	set, unset := update(section)
+	if len(set) == 0 && len(unset) == 0 {
+		return
+	}
+
+	name := section.name()
+	if name == "" {
+		if name, err = r.uciAdd(ctx, vssrConfig, vssrACLType, set); err != nil {
+			return
+		}
+	} else if len(set) != 0 {
+		if err = r.uciSet(ctx, vssrConfig, name, set); err != nil {
+			return
+		}
+	}
+	if len(unset) != 0 && section.name() != "" {
+		if err = r.uciDelete(ctx, vssrConfig, name, unset...); err != nil {
+			return
+		}
+	}
+	if err = r.uciCommit(ctx, vssrConfig); err != nil {
+		return
+	}
+	return r.initScript(ctx, vssrConfig, "restart")
+}
+
+// validateACLList 校验访问控制列表名称
+func validateACLList(list ACLList) error {
+	switch list {
+	case ACLLANClients, ACLLANForceProxy, ACLWANBypass, ACLWANForceProxy:
+		return nil
+	}
+	return fmt.Errorf("unknown access control list %q", list)
+}
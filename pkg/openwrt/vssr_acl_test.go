@@ -0,0 +1,123 @@
+package openwrt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRouter_VssrRunModeAndACL(t *testing.T) {
+	store := uciTestStore{
+		"vssr": {
+			"cfg01": {".name": "cfg01", ".type": "global", ".index": float64(0), "global_server": "cfg02", "run_mode": "gfw"},
+		},
+	}
+	files := map[string]string{"/etc/vssr/white.list": "# 直连域名\nexample.cn\n\nqq.com\n"}
+	var restarts int
+	uci := uciTestHandler(store, func(command string, params []string) string {
+		if command == "/etc/init.d/vssr" && reflect.DeepEqual(params, []string{"restart"}) {
+			restarts++
+		}
+		return ""
+	})
+	srv := newUbusTestServer(t, func(object, method string, args map[string]interface{}) []interface{} {
+		path, _ := args["path"].(string)
+		switch object + "." + method {
+		case "file.read":
+			data, ok := files[path]
+			if !ok {
+				return []interface{}{4}
+			}
+			return []interface{}{0, map[string]interface{}{"data": data}}
+		case "file.write":
+			files[path], _ = args["data"].(string)
+			return []interface{}{0}
+		}
+		return uci(object, method, args)
+	})
+	defer srv.Close()
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+
+	// 运行模式
+	if mode, err := r.GetVssrRunMode(); err != nil || mode != RunModeGFW {
+		t.Fatalf("got mode %q, %v", mode, err)
+	}
+	if err := r.SetVssrRunMode("pac"); err == nil {
+		t.Error("expected unsupported run mode error")
+	}
+	if err := r.SetVssrRunMode(RunModeRouter); err != nil {
+		t.Fatal(err)
+	}
+	if store["vssr"]["cfg01"]["run_mode"] != "router" || restarts != 1 {
+		t.Errorf("got run_mode %v restarts %d", store["vssr"]["cfg01"]["run_mode"], restarts)
+	}
+
+	// 域名列表
+	domains, err := r.ListVssrDomains(DomainWhitelist)
+	if err != nil || !reflect.DeepEqual(domains, []string{"example.cn", "qq.com"}) {
+		t.Fatalf("got domains %v, %v", domains, err)
+	}
+	if domains, err = r.ListVssrDomains(DomainBlacklist); err != nil || len(domains) != 0 {
+		t.Fatalf("missing list should be empty, got %v, %v", domains, err)
+	}
+	if _, err = r.AddVssrDomains(DomainWhitelist, "bad domain"); err == nil {
+		t.Error("expected invalid domain error")
+	}
+	if added, err := r.AddVssrDomains(DomainWhitelist, "QQ.com", "baidu.com"); err != nil || added != 1 {
+		t.Fatalf("got added %d, %v", added, err)
+	}
+	if removed, err := r.RemoveVssrDomains(DomainWhitelist, "example.cn", "none.com"); err != nil || removed != 1 {
+		t.Fatalf("got removed %d, %v", removed, err)
+	}
+	if got := files["/etc/vssr/white.list"]; got != "# 直连域名\n\nqq.com\nbaidu.com\n" || restarts != 3 {
+		t.Errorf("got white.list %q restarts %d", got, restarts)
+	}
+	if added, err := r.AddVssrDomains(DomainBlacklist, "google.com"); err != nil || added != 1 || files["/etc/vssr/black.list"] != "google.com\n" {
+		t.Fatalf("got added %d, %v, black.list %q", added, err, files["/etc/vssr/black.list"])
+	}
+	restarts = 0
+
+	// 访问控制, 配置节不存在时新建
+	acl, err := r.GetVssrAccessControl()
+	if err != nil || acl.LANAccessMode != LANAccessDisabled || len(acl.LANClients) != 0 {
+		t.Fatalf("got acl %+v, %v", acl, err)
+	}
+	if _, err = r.AddVssrACLEntries(ACLLANClients, "192.168.1.300"); err == nil {
+		t.Error("expected invalid ip error")
+	}
+	if _, err = r.AddVssrACLEntries("lan_ips", "192.168.1.10"); err == nil {
+		t.Error("expected unknown list error")
+	}
+	if added, err := r.AddVssrACLEntries(ACLLANClients, "192.168.1.10", "192.168.1.11"); err != nil || added != 2 {
+		t.Fatalf("got added %d, %v", added, err)
+	}
+	if added, err := r.AddVssrACLEntries(ACLLANClients, "192.168.1.11", "192.168.1.12"); err != nil || added != 1 {
+		t.Fatalf("got added %d, %v", added, err)
+	}
+	if added, err := r.AddVssrACLEntries(ACLWANBypass, "1.1.1.0/24"); err != nil || added != 1 {
+		t.Fatalf("got added %d, %v", added, err)
+	}
+	if err = r.SetVssrLANAccessMode(LANAccessWhitelist); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.SetVssrLANAccessMode("x"); err == nil {
+		t.Error("expected unsupported lan access mode error")
+	}
+	if removed, err := r.RemoveVssrACLEntries(ACLLANClients, "192.168.1.10"); err != nil || removed != 1 {
+		t.Fatalf("got removed %d, %v", removed, err)
+	}
+	if removed, err := r.RemoveVssrACLEntries(ACLWANBypass, "1.1.1.0/24"); err != nil || removed != 1 {
+		t.Fatalf("got removed %d, %v", removed, err)
+	}
+	acl, err = r.GetVssrAccessControl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &VssrAccessControl{LANAccessMode: LANAccessWhitelist, LANClients: []string{"192.168.1.11", "192.168.1.12"}}
+	if !reflect.DeepEqual(acl, want) || restarts != 6 {
+		t.Errorf("got acl %+v restarts %d", acl, restarts)
+	}
+	if removed, err := r.RemoveVssrACLEntries(ACLWANForceProxy, "8.8.8.8"); err != nil || removed != 0 || restarts != 6 {
+		t.Errorf("got removed %d, %v, restarts %d", removed, err, restarts)
+	}
+}
@@ -0,0 +1,370 @@
+package openwrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// vssr节点类型
+const (
+	NodeTypeSSR    = "ssr"
+	NodeTypeSS     = "ss"
+	NodeTypeV2Ray  = "v2ray"
+	NodeTypeXray   = "xray"
+	NodeTypeTrojan = "trojan"
+)
+
+const vssrServerType = "servers" // vssr节点的配置节类型
+
+var ErrNodeInUse = errors.New("proxy node in use") // 节点正被全局或分流规则使用
+
+// VssrNode vssr的代理节点配置, 订阅节点与手动添加的节点使用相同的配置节
+type VssrNode struct {
+	Id            string // 配置节名称
+	Type          string // 节点类型, 取值为 NodeType* 常量
+	Alias         string // 节点名称
+	Group         string // 订阅分组, 手动添加的节点为空
+	Host          string // 服务器地址
+	Port          string // 服务器端口
+	Password      string // 密码, v2ray/xray为用户ID
+	Cipher        string // 加密方式
+	Protocol      string // ssr协议
+	ProtocolParam string // ssr协议参数
+	Obfs          string // ssr混淆
+	ObfsParam     string // ssr混淆参数
+	Plugin        string // ss插件
+	PluginOpts    string // ss插件参数
+	V2RayProtocol string // xray节点的协议, vmess或vless, 为空时为vmess
+	AlterId       string // vmess的额外ID
+	Transport     string // v2ray/xray的传输方式, 如 tcp/ws/h2/kcp/quic
+	WSPath        string // websocket路径
+	WSHost        string // websocket的Host头
+	H2Path        string // http/2路径
+	H2Host        string // http/2的域名
+	TLS           bool   // 是否启用TLS
+	TLSHost       string // TLS的服务器名称
+}
+
+// nodeOptions 节点类型对应的密码及加密方式选项名称
+func nodeOptions(typ string) (password, cipher string) {
+	switch typ {
+	case NodeTypeSS:
+		return "password", "encrypt_method_ss"
+	case NodeTypeV2Ray, NodeTypeXray:
+		return "vmess_id", "security"
+	case NodeTypeTrojan:
+		return "password", ""
+	}
+	return "password", "encrypt_method"
+}
+
+// newVssrNode 转换uci配置节
+func newVssrNode(s uciSection) (node *VssrNode) {
+	node = &VssrNode{
+		Id:            s.name(),
+		Type:          s.str("type"),
+		Alias:         s.str("alias"),
+		Group:         s.str("group"),
+		Host:          s.str("server"),
+		Port:          s.str("server_port"),
+		Protocol:      s.str("protocol"),
+		ProtocolParam: s.str("protocol_param"),
+		Obfs:          s.str("obfs"),
+		ObfsParam:     s.str("obfs_param"),
+		Plugin:        s.str("plugin"),
+		PluginOpts:    s.str("plugin_opts"),
+		V2RayProtocol: s.str("v2ray_protocol"),
+		AlterId:       s.str("alter_id"),
+		Transport:     s.str("transport"),
+		WSPath:        s.str("ws_path"),
+		WSHost:        s.str("ws_host"),
+		H2Path:        s.str("h2_path"),
+		H2Host:        s.str("h2_host"),
+		TLS:           s.str("tls") == "1",
+		TLSHost:       s.str("tls_host"),
+	}
+	passwordOption, cipherOption := nodeOptions(node.Type)
+	node.Password = s.str(passwordOption)
+	if cipherOption != "" {
+		node.Cipher = s.str(cipherOption)
+	}
+	return
+}
+
+// validate 校验节点配置
+func (node *VssrNode) validate() error {
+	switch node.Type {
+	case NodeTypeSSR, NodeTypeSS, NodeTypeV2Ray, NodeTypeXray, NodeTypeTrojan:
+	default:
+		return fmt.Errorf("unsupported node type %q", node.Type)
+	}
+	if !hostPattern.MatchString(node.Host) || !portPattern.MatchString(node.Port) {
+		return fmt.Errorf("invalid proxy node address %s:%s", node.Host, node.Port)
+	}
+	if node.Password == "" {
+		return errors.New("proxy node password is empty")
+	}
+	return nil
+}
+
+// values 转换为uci选项, 空值表示删除该选项
+func (node *VssrNode) values() map[string]string {
+	values := map[string]string{
+		"type":           node.Type,
+		"alias":          node.Alias,
+		"group":          node.Group,
+		"server":         node.Host,
+		"server_port":    node.Port,
+		"protocol":       node.Protocol,
+		"protocol_param": node.ProtocolParam,
+		"obfs":           node.Obfs,
+		"obfs_param":     node.ObfsParam,
+		"plugin":         node.Plugin,
+		"plugin_opts":    node.PluginOpts,
+		"v2ray_protocol": node.V2RayProtocol,
+		"alter_id":       node.AlterId,
+		"transport":      node.Transport,
+		"ws_path":        node.WSPath,
+		"ws_host":        node.WSHost,
+		"h2_path":        node.H2Path,
+		"h2_host":        node.H2Host,
+		"tls":            "",
+		"tls_host":       node.TLSHost,
+	}
+	if node.TLS {
+		values["tls"] = "1"
+	}
+	passwordOption, cipherOption := nodeOptions(node.Type)
+	values[passwordOption] = node.Password
+	if cipherOption != "" {
+		values[cipherOption] = node.Cipher
+	}
+	return values
+}
+
+// ListVssrNodes 列出vssr的所有节点
+func (r *Router) ListVssrNodes() (nodes []*VssrNode, err error) {
+	return r.ListVssrNodesContext(context.Background())
+}
+
+// ListVssrNodesContext 列出vssr的所有节点, 按配置文件中的顺序排列
+func (r *Router) ListVssrNodesContext(ctx context.Context) (nodes []*VssrNode, err error) {
+	sections, err := r.uciSections(ctx, vssrConfig, vssrServerType)
+	if err != nil {
+		return
+	}
+	for _, section := range sections {
+		nodes = append(nodes, newVssrNode(section))
+	}
+	return
+}
+
+// AddVssrNode 新增vssr节点
+func (r *Router) AddVssrNode(node *VssrNode) (err error) {
+	return r.AddVssrNodeContext(context.Background(), node)
+}
+
+// AddVssrNodeContext 新增vssr节点, 成功后node.Id为新配置节名称
+func (r *Router) AddVssrNodeContext(ctx context.Context, node *VssrNode) (err error) {
+	if err = node.validate(); err != nil {
+		return
+	}
+	values := map[string]interface{}{}
+	for option, val := range node.values() {
+		if val != "" {
+			values[option] = val
+		}
+	}
+	id, err := r.uciAdd(ctx, vssrConfig, vssrServerType, values)
+	if err != nil {
+		return
+	}
+	if err = r.uciCommit(ctx, vssrConfig); err != nil {
+		return
+	}
+	node.Id = id
+	return
+}
+
+// UpdateVssrNode 修改vssr节点
+func (r *Router) UpdateVssrNode(node *VssrNode) (err error) {
+	return r.UpdateVssrNodeContext(context.Background(), node)
+}
+
+// UpdateVssrNodeContext 按node.Id修改vssr节点, 未列出的选项保持不变, 修改节点类型时删除原类型的密码及加密方式选项;
+// 修改的是正在使用的节点时重启vssr
+func (r *Router) UpdateVssrNodeContext(ctx context.Context, node *VssrNode) (err error) {
+	if err = node.validate(); err != nil {
+		return
+	}
+	section, err := r.vssrNodeSection(ctx, node.Id)
+	if err != nil {
+		return
+	}
+	values := node.values()
+	if prevType := section.str("type"); prevType != node.Type {
+		passwordOption, cipherOption := nodeOptions(prevType)
+		for _, option := range []string{passwordOption, cipherOption} {
+			if _, ok := values[option]; option != "" && !ok {
+				values[option] = ""
+			}
+		}
+	}
+	set := map[string]interface{}{}
+	var unset []string
+	for option, val := range values {
+		if val == "" {
+			unset = append(unset, option)
+		} else {
+			set[option] = val
+		}
+	}
+	if err = r.uciSet(ctx, vssrConfig, node.Id, set); err != nil {
+		return
+	}
+	if len(unset) != 0 {
+		if err = r.uciDelete(ctx, vssrConfig, node.Id, unset...); err != nil && !isUbusNotFound(err) {
+			return
+		}
+	}
+	if err = r.uciCommit(ctx, vssrConfig); err != nil {
+		return
+	}
+	roles, err := r.vssrNodeRoles(ctx, node.Id)
+	if err != nil || len(roles) == 0 {
+		return
+	}
+	return r.initScript(ctx, vssrConfig, "restart")
+}
+
+// DuplicateVssrNode 复制vssr节点, 返回新节点
+func (r *Router) DuplicateVssrNode(id, alias string) (node *VssrNode, err error) {
+	return r.DuplicateVssrNodeContext(context.Background(), id, alias)
+}
+
+// DuplicateVssrNodeContext 复制vssr节点的所有选项, alias为新节点名称, 为空时在原名称后加 -copy;
+// 新节点不属于任何订阅分组, 更新订阅时不会被删除
+func (r *Router) DuplicateVssrNodeContext(ctx context.Context, id, alias string) (node *VssrNode, err error) {
+	section, err := r.vssrNodeSection(ctx, id)
+	if err != nil {
+		return
+	}
+	values := map[string]interface{}{}
+	for option, val := range section {
+		if !strings.HasPrefix(option, ".") && option != "group" {
+			values[option] = val
+		}
+	}
+	if alias == "" {
+		alias = section.str("alias") + "-copy"
+	}
+	values["alias"] = alias
+	name, err := r.uciAdd(ctx, vssrConfig, vssrServerType, values)
+	if err != nil {
+		return
+	}
+	if err = r.uciCommit(ctx, vssrConfig); err != nil {
+		return
+	}
+	section[".name"] = name
+	section["alias"] = alias
+	delete(section, "group")
+	node = newVssrNode(section)
+	return
+}
+
+// DeleteVssrNode 删除vssr节点
+func (r *Router) DeleteVssrNode(ids ...string) (err error) {
+	return r.DeleteVssrNodeContext(context.Background(), ids...)
+}
+
+// DeleteVssrNodeContext 删除vssr节点, 任一节点正被使用时返回 ErrNodeInUse 且不删除任何节点
+func (r *Router) DeleteVssrNodeContext(ctx context.Context, ids ...string) (err error) {
+	for _, id := range ids {
+		if _, err = r.vssrNodeSection(ctx, id); err != nil {
+			return
+		}
+	}
+	return r.deleteVssrNodes(ctx, ids)
+}
+
+// DeleteVssrGroup 删除订阅分组中的所有节点
+func (r *Router) DeleteVssrGroup(group string) (deleted int, err error) {
+	return r.DeleteVssrGroupContext(context.Background(), group)
+}
+
+// DeleteVssrGroupContext 删除订阅分组中的所有节点, 返回删除的数量; 分组中有节点正被使用时返回 ErrNodeInUse
+func (r *Router) DeleteVssrGroupContext(ctx context.Context, group string) (deleted int, err error) {
+	if group == "" {
+		return 0, errors.New("group is empty")
+	}
+	nodes, err := r.ListVssrNodesContext(ctx)
+	if err != nil {
+		return
+	}
+	var ids []string
+	for _, node := range nodes {
+		if node.Group == group {
+			ids = append(ids, node.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	if err = r.deleteVssrNodes(ctx, ids); err != nil {
+		return
+	}
+	deleted = len(ids)
+	return
+}
+
+// deleteVssrNodes 检查节点未被使用后删除并提交
+func (r *Router) deleteVssrNodes(ctx context.Context, ids []string) (err error) {
+	for _, id := range ids {
+		var roles []ProxyRole
+		if roles, err = r.vssrNodeRoles(ctx, id); err != nil {
+			return
+		}
+		if len(roles) != 0 {
+			return fmt.Errorf("%w: %s is used as %v", ErrNodeInUse, id, roles)
+		}
+	}
+	for _, id := range ids {
+		if err = r.uciDelete(ctx, vssrConfig, id); err != nil {
+			return
+		}
+	}
+	return r.uciCommit(ctx, vssrConfig)
+}
+
+// vssrNodeSection 读取节点配置节, 配置节不是vssr节点时返回错误
+func (r *Router) vssrNodeSection(ctx context.Context, id string) (section uciSection, err error) {
+	data := &struct {
+		Values uciSection `json:"values"`
+	}{}
+	if err = r.ubusCall(ctx, "uci", "get", map[string]interface{}{"config": vssrConfig, "section": id}, data); err != nil {
+		return
+	}
+	if data.Values[".type"] != vssrServerType {
+		err = fmt.Errorf("uci section vssr.%s is not a proxy node", id)
+		return
+	}
+	section = data.Values
+	return
+}
+
+// vssrNodeRoles 节点当前被哪些用途使用
+func (r *Router) vssrNodeRoles(ctx context.Context, id string) (roles []ProxyRole, err error) {
+	sections, err := r.uciSections(ctx, vssrConfig, "global")
+	if err != nil || len(sections) == 0 {
+		return
+	}
+	for _, role := range ProxyRoles {
+		if sections[0].str(string(role)+"_server") == id {
+			roles = append(roles, role)
+		}
+	}
+	return
+}
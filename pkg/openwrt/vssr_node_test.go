@@ -0,0 +1,110 @@
+package openwrt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newVssrNodeTestStore() uciTestStore {
+	return uciTestStore{
+		"vssr": {
+			"cfg01": {".name": "cfg01", ".type": "global", ".index": float64(0), "global_server": "cfg02", "udp_relay_server": "same"},
+			"cfg02": {".name": "cfg02", ".type": "servers", ".index": float64(1), "type": "ssr", "alias": "香港01", "group": "sub.example.com", "server": "hk.example.com", "server_port": "8388", "password": "secret", "encrypt_method": "aes-256-cfb", "protocol": "origin", "obfs": "plain"},
+			"cfg03": {".name": "cfg03", ".type": "servers", ".index": float64(2), "type": "v2ray", "alias": "日本01", "group": "sub.example.com", "server": "jp.example.com", "server_port": "443", "vmess_id": "uuid", "security": "auto", "alter_id": "0", "transport": "ws", "ws_path": "/ray", "ws_host": "cdn.example.com", "tls": "1", "tls_host": "jp.example.com"},
+			"cfg04": {".name": "cfg04", ".type": "servers", ".index": float64(3), "type": "ss", "alias": "自建", "server": "home.example.com", "server_port": "8388", "password": "secret", "encrypt_method_ss": "aes-128-gcm"},
+		},
+	}
+}
+
+func TestRouter_VssrNodeCRUD(t *testing.T) {
+	store := newVssrNodeTestStore()
+	var restarts int
+	srv := newUciTestServer(t, store, func(command string, params []string) string {
+		if command == "/etc/init.d/vssr" {
+			restarts++
+		}
+		return ""
+	})
+	defer srv.Close()
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+
+	nodes, err := r.ListVssrNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 3 {
+		t.Fatalf("got %d nodes, want 3", len(nodes))
+	}
+	if jp := nodes[1]; jp.Password != "uuid" || jp.Cipher != "auto" || jp.Group != "sub.example.com" || jp.Transport != "ws" || jp.WSPath != "/ray" || !jp.TLS || jp.TLSHost != "jp.example.com" {
+		t.Errorf("got jp %+v", jp)
+	}
+	if home := nodes[2]; home.Cipher != "aes-128-gcm" || home.Group != "" {
+		t.Errorf("got home %+v", home)
+	}
+
+	if err = r.AddVssrNode(&VssrNode{Type: NodeTypeTrojan, Host: "bad host", Port: "443", Password: "x"}); err == nil {
+		t.Error("expected invalid address error")
+	}
+	node := &VssrNode{Type: NodeTypeTrojan, Alias: "自建trojan", Host: "vps.example.com", Port: "443", Password: "secret"}
+	if err = r.AddVssrNode(node); err != nil {
+		t.Fatal(err)
+	}
+	if added := store["vssr"][node.Id]; added["server"] != "vps.example.com" || added["password"] != "secret" || added[".type"] != "servers" {
+		t.Errorf("got added section %v", added)
+	}
+
+	// 修改正在使用的节点时重启vssr, 未列出的选项保持不变
+	hk := nodes[0]
+	hk.Port, hk.Obfs = "8389", ""
+	if err = r.UpdateVssrNode(hk); err != nil {
+		t.Fatal(err)
+	}
+	if section := store["vssr"]["cfg02"]; section["server_port"] != "8389" || section["obfs"] != nil || section["protocol"] != "origin" || restarts != 1 {
+		t.Errorf("got section %v restarts %d", section, restarts)
+	}
+	if err = r.UpdateVssrNode(&VssrNode{Id: "cfg01", Type: NodeTypeSS, Host: "a.com", Port: "1", Password: "x"}); err == nil {
+		t.Error("expected not a proxy node error")
+	}
+
+	// 修改节点类型时删除原类型的密码及加密方式选项
+	home := nodes[2]
+	home.Type, home.Password, home.Cipher = NodeTypeV2Ray, "uuid2", "auto"
+	home.Transport, home.H2Path, home.TLS = "h2", "/h2", true
+	if err = r.UpdateVssrNode(home); err != nil {
+		t.Fatal(err)
+	}
+	if section := store["vssr"]["cfg04"]; section["password"] != nil || section["encrypt_method_ss"] != nil || section["vmess_id"] != "uuid2" || section["h2_path"] != "/h2" || section["tls"] != "1" {
+		t.Errorf("got section %v", section)
+	}
+
+	copied, err := r.DuplicateVssrNode("cfg03", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if section := store["vssr"][copied.Id]; copied.Alias != "日本01-copy" || copied.Group != "" || section["transport"] != "ws" || section["group"] != nil {
+		t.Errorf("got copy %+v section %v", copied, section)
+	}
+
+	if err = r.DeleteVssrNode("cfg04", "cfg02"); !errors.Is(err, ErrNodeInUse) {
+		t.Errorf("got %v, want ErrNodeInUse", err)
+	}
+	if _, ok := store["vssr"]["cfg04"]; !ok {
+		t.Error("node deleted although another node is in use")
+	}
+	if err = r.DeleteVssrNode("cfg04"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = r.DeleteVssrGroup("sub.example.com"); !errors.Is(err, ErrNodeInUse) {
+		t.Errorf("got %v, want ErrNodeInUse", err)
+	}
+	store["vssr"]["cfg01"]["global_server"] = node.Id
+	deleted, err := r.DeleteVssrGroup("sub.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 || len(store["vssr"]) != 3 {
+		t.Errorf("got deleted %d, remaining %v", deleted, store["vssr"])
+	}
+}
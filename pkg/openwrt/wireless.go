@@ -0,0 +1,273 @@
+package openwrt
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+const wirelessConfig = "wireless" // 无线的uci配置名称
+
+// 无线加密方式
+const (
+	EncryptionNone     = "none"      // 不加密
+	EncryptionPSK      = "psk"       // WPA-PSK
+	EncryptionPSK2     = "psk2"      // WPA2-PSK
+	EncryptionPSKMixed = "psk-mixed" // WPA/WPA2-PSK混合
+	EncryptionSAE      = "sae"       // WPA3-SAE
+	EncryptionSAEMixed = "sae-mixed" // WPA2/WPA3混合
+	EncryptionOWE      = "owe"       // 增强型开放网络
+)
+
+// keyEncryptions 需要预共享密钥的加密方式
+var keyEncryptions = map[string]bool{
+	EncryptionPSK:      true,
+	EncryptionPSK2:     true,
+	EncryptionPSKMixed: true,
+	EncryptionSAE:      true,
+	EncryptionSAEMixed: true,
+}
+
+// keyAlphabet 生成无线密码使用的字符, 去掉了容易混淆的字符
+const keyAlphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Radio 无线射频设备
+type Radio struct {
+	Name       string           `uci:".name"`   // 配置节名称, 如 radio0
+	Band       string           `uci:"band"`    // 频段, 2g/5g/6g
+	HWMode     string           `uci:"hwmode"`  // 旧版本固件的频段配置, 如 11g/11a
+	Channel    string           `uci:"channel"` // 信道, auto表示自动
+	HTMode     string           `uci:"htmode"`  // 频宽, 如 HE80
+	Country    string           `uci:"country"` // 国家代码
+	Disabled   bool             `uci:"disabled"`
+	Up         bool             `uci:"-"` // 运行状态
+	Interfaces []*WifiInterface `uci:"-"` // 属于该射频的无线接口
+}
+
+// WifiInterface 无线接口(SSID)
+type WifiInterface struct {
+	Name       string `uci:".name"`      // 配置节名称, 如 default_radio0
+	Device     string `uci:"device"`     // 所属射频
+	Network    string `uci:"network"`    // 桥接的网络接口
+	Mode       string `uci:"mode"`       // 模式, 如 ap/sta
+	SSID       string `uci:"ssid"`       // 无线名称
+	Encryption string `uci:"encryption"` // 加密方式, 取值为 Encryption* 常量
+	Key        string `uci:"key"`        // 预共享密钥
+	Hidden     bool   `uci:"hidden"`     // 是否隐藏SSID
+	Disabled   bool   `uci:"disabled"`
+	Ifname     string `uci:"-"` // 运行时的系统接口名称, 未启动时为空
+	Clients    int    `uci:"-"` // 已关联的设备数量
+}
+
+// validate 校验无线接口配置
+func (w *WifiInterface) validate() error {
+	if l := len(w.SSID); l == 0 || l > 32 {
+		return fmt.Errorf("invalid ssid length %d", l)
+	}
+	switch {
+	case w.Encryption == EncryptionNone || w.Encryption == EncryptionOWE:
+	case keyEncryptions[w.Encryption]:
+		if l := len(w.Key); l < 8 || l > 63 {
+			return fmt.Errorf("invalid key length %d for %s", l, w.Encryption)
+		}
+	default:
+		return fmt.Errorf("unsupported encryption %q", w.Encryption)
+	}
+	return nil
+}
+
+// band 射频频段, 兼容只有hwmode的旧版本配置
+func (radio *Radio) band() string {
+	if radio.Band != "" {
+		return radio.Band
+	}
+	switch radio.HWMode {
+	case "11a":
+		return "5g"
+	case "11b", "11g":
+		return "2g"
+	}
+	return ""
+}
+
+// GenerateWifiKey 生成指定长度的随机无线密码, 长度不足8时按8生成
+func GenerateWifiKey(length int) (key string, err error) {
+	if length < 8 {
+		length = 8
+	}
+	buf := make([]byte, length)
+	max := big.NewInt(int64(len(keyAlphabet)))
+	for i := range buf {
+		var n *big.Int
+		if n, err = rand.Int(rand.Reader, max); err != nil {
+			return
+		}
+		buf[i] = keyAlphabet[n.Int64()]
+	}
+	key = string(buf)
+	return
+}
+
+// ListRadios 列出射频设备及其无线接口
+func (r *Router) ListRadios() (radios []*Radio, err error) {
+	return r.ListRadiosContext(context.Background())
+}
+
+// ListRadiosContext 列出射频设备及其无线接口, 同时读取运行状态及已关联设备数量
+func (r *Router) ListRadiosContext(ctx context.Context) (radios []*Radio, err error) {
+	sections, err := r.GetUCIConfigContext(ctx, wirelessConfig)
+	if err != nil {
+		return
+	}
+	byName := map[string]*Radio{}
+	var ifaces []*WifiInterface
+	for _, section := range sections {
+		switch section.Type {
+		case "wifi-device":
+			radio := &Radio{}
+			if err = section.Decode(radio); err != nil {
+				return
+			}
+			radio.Band = radio.band()
+			byName[radio.Name] = radio
+			radios = append(radios, radio)
+		case "wifi-iface":
+			iface := &WifiInterface{}
+			if err = section.Decode(iface); err != nil {
+				return
+			}
+			if iface.Encryption == "" {
+				iface.Encryption = EncryptionNone
+			}
+			ifaces = append(ifaces, iface)
+		}
+	}
+	for _, iface := range ifaces {
+		if radio, ok := byName[iface.Device]; ok {
+			radio.Interfaces = append(radio.Interfaces, iface)
+		}
+	}
+
+	// 运行状态
+	status := map[string]struct {
+		Up         bool `json:"up"`
+		Interfaces []struct {
+			Section string `json:"section"`
+			Ifname  string `json:"ifname"`
+		} `json:"interfaces"`
+	}{}
+	if err = r.ubusCall(ctx, "network.wireless", "status", nil, &status); err != nil {
+		return
+	}
+	for _, radio := range radios {
+		state, ok := status[radio.Name]
+		if !ok {
+			continue
+		}
+		radio.Up = state.Up
+		for _, item := range state.Interfaces {
+			for _, iface := range radio.Interfaces {
+				if iface.Name != item.Section || item.Ifname == "" {
+					continue
+				}
+				iface.Ifname = item.Ifname
+				assoc := &struct {
+					Results []interface{} `json:"results"`
+				}{}
+				if err = r.ubusCall(ctx, "iwinfo", "assoclist", map[string]interface{}{"device": item.Ifname}, assoc); err != nil {
+					return
+				}
+				iface.Clients = len(assoc.Results)
+			}
+		}
+	}
+	return
+}
+
+// UpdateWifiInterface 修改无线接口的SSID, 加密方式, 密钥, 隐藏及禁用状态
+func (r *Router) UpdateWifiInterface(iface *WifiInterface, opts *UCIApplyOptions) (err error) {
+	return r.UpdateWifiInterfaceContext(context.Background(), iface, opts)
+}
+
+// UpdateWifiInterfaceContext 修改无线接口的SSID, 加密方式, 密钥, 隐藏及禁用状态;
+// 修改以确认或回滚方式应用, 无法重新连接路由器时自动恢复
+func (r *Router) UpdateWifiInterfaceContext(ctx context.Context, iface *WifiInterface, opts *UCIApplyOptions) (err error) {
+	if err = iface.validate(); err != nil {
+		return
+	}
+	values := map[string]interface{}{
+		"ssid":       iface.SSID,
+		"encryption": iface.Encryption,
+		"hidden":     boolOption(iface.Hidden),
+		"disabled":   boolOption(iface.Disabled),
+	}
+	if keyEncryptions[iface.Encryption] {
+		values["key"] = iface.Key
+	}
+	if err = r.SetUCIContext(ctx, wirelessConfig, iface.Name, values); err != nil {
+		return
+	}
+	if !keyEncryptions[iface.Encryption] {
+		if err = r.DeleteUCIContext(ctx, wirelessConfig, iface.Name, "key"); err != nil && !isUbusNotFound(err) {
+			return
+		}
+	}
+	return r.ApplyUCIContext(ctx, opts)
+}
+
+// SetWifiInterfaceDisabled 启用或禁用无线接口
+func (r *Router) SetWifiInterfaceDisabled(name string, disabled bool, opts *UCIApplyOptions) (err error) {
+	return r.SetWifiInterfaceDisabledContext(context.Background(), name, disabled, opts)
+}
+
+// SetWifiInterfaceDisabledContext 启用或禁用无线接口
+func (r *Router) SetWifiInterfaceDisabledContext(ctx context.Context, name string, disabled bool, opts *UCIApplyOptions) (err error) {
+	return r.setWirelessDisabled(ctx, name, disabled, opts)
+}
+
+// SetRadioDisabled 启用或禁用射频设备
+func (r *Router) SetRadioDisabled(name string, disabled bool, opts *UCIApplyOptions) (err error) {
+	return r.SetRadioDisabledContext(context.Background(), name, disabled, opts)
+}
+
+// SetRadioDisabledContext 启用或禁用射频设备
+func (r *Router) SetRadioDisabledContext(ctx context.Context, name string, disabled bool, opts *UCIApplyOptions) (err error) {
+	return r.setWirelessDisabled(ctx, name, disabled, opts)
+}
+
+// setWirelessDisabled 修改无线配置节的disabled选项并应用
+func (r *Router) setWirelessDisabled(ctx context.Context, section string, disabled bool, opts *UCIApplyOptions) (err error) {
+	if err = r.SetUCIContext(ctx, wirelessConfig, section, map[string]interface{}{"disabled": boolOption(disabled)}); err != nil {
+		return
+	}
+	return r.ApplyUCIContext(ctx, opts)
+}
+
+// RotateWifiKey 为无线接口生成新的随机密码并应用, 返回新密码
+func (r *Router) RotateWifiKey(name string, opts *UCIApplyOptions) (key string, err error) {
+	return r.RotateWifiKeyContext(context.Background(), name, opts)
+}
+
+// RotateWifiKeyContext 为无线接口生成新的随机密码并应用, 返回新密码; 未加密的接口改为WPA2-PSK
+func (r *Router) RotateWifiKeyContext(ctx context.Context, name string, opts *UCIApplyOptions) (key string, err error) {
+	section, err := r.GetUCISectionContext(ctx, wirelessConfig, name)
+	if err != nil {
+		return
+	}
+	iface := &WifiInterface{}
+	if err = section.Decode(iface); err != nil {
+		return
+	}
+	if !keyEncryptions[iface.Encryption] {
+		iface.Encryption = EncryptionPSK2
+	}
+	if key, err = GenerateWifiKey(12); err != nil {
+		return
+	}
+	iface.Key = key
+	if err = r.UpdateWifiInterfaceContext(ctx, iface, opts); err != nil {
+		key = ""
+	}
+	return
+}
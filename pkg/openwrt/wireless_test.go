@@ -0,0 +1,77 @@
+package openwrt
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func newWirelessTestStore() uciTestStore {
+	return uciTestStore{
+		"wireless": {
+			"radio0":         {".name": "radio0", ".type": "wifi-device", ".index": float64(0), "band": "2g", "channel": "1", "htmode": "HE20"},
+			"radio1":         {".name": "radio1", ".type": "wifi-device", ".index": float64(1), "hwmode": "11a", "channel": "36", "disabled": "1"},
+			"default_radio0": {".name": "default_radio0", ".type": "wifi-iface", ".index": float64(2), "device": "radio0", "mode": "ap", "network": "lan", "ssid": "home", "encryption": "psk2", "key": "password1"},
+			"guest":          {".name": "guest", ".type": "wifi-iface", ".index": float64(3), "device": "radio0", "mode": "ap", "network": "guest", "ssid": "guest", "encryption": "none"},
+		},
+	}
+}
+
+func TestRouter_ListRadios(t *testing.T) {
+	srv := newUciTestServer(t, newWirelessTestStore(), nil)
+	defer srv.Close()
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+
+	radios, err := r.ListRadios()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(radios) != 2 {
+		t.Fatalf("got %d radios, want 2", len(radios))
+	}
+	if radios[0].Band != "2g" || !radios[0].Up || len(radios[0].Interfaces) != 2 {
+		t.Errorf("got radio0 %+v", radios[0])
+	}
+	if radios[1].Band != "5g" || !radios[1].Disabled || radios[1].Up {
+		t.Errorf("got radio1 %+v", radios[1])
+	}
+	home := radios[0].Interfaces[0]
+	if home.SSID != "home" || home.Encryption != EncryptionPSK2 || home.Ifname != "default_radio0" || home.Clients != 1 {
+		t.Errorf("got home %+v", home)
+	}
+}
+
+func TestRouter_UpdateWifiInterface(t *testing.T) {
+	store := newWirelessTestStore()
+	srv := newUciTestServer(t, store, nil)
+	defer srv.Close()
+	r := NewRouterInstance(&RouterConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), Backend: BackendUbus})
+	opts := &UCIApplyOptions{Holdoff: time.Millisecond}
+
+	if err := r.UpdateWifiInterface(&WifiInterface{Name: "guest", SSID: "guest", Encryption: EncryptionPSK2, Key: "short"}, opts); err == nil {
+		t.Error("expected invalid key error")
+	}
+
+	key, err := r.RotateWifiKey("guest", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest := store["wireless"]["guest"]
+	if len(key) != 12 || guest["key"] != key || guest["encryption"] != EncryptionPSK2 || guest["ssid"] != "guest" {
+		t.Errorf("got key %q guest %v", key, guest)
+	}
+
+	if err = r.UpdateWifiInterface(&WifiInterface{Name: "guest", SSID: "guest-open", Encryption: EncryptionNone}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := guest["key"]; ok || guest["ssid"] != "guest-open" {
+		t.Errorf("got guest %v, want open network without key", guest)
+	}
+
+	if err = r.SetRadioDisabled("radio1", false, opts); err != nil {
+		t.Fatal(err)
+	}
+	if store["wireless"]["radio1"]["disabled"] != "0" {
+		t.Errorf("got radio1 %v", store["wireless"]["radio1"])
+	}
+}
@@ -0,0 +1,245 @@
+package quality
+
+import (
+	"context"
+	"fmt"
+	"github.com/huge-kumo/net-utils/pkg/curl"
+	"github.com/huge-kumo/net-utils/pkg/log"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"net"
+	"time"
+)
+
+const (
+	DefaultEgressURL      = "https://api.ipify.org" // 默认查询出口IP的地址, 返回纯文本IP
+	DefaultSettle         = 3 * time.Second         // 默认切换节点后等待生效的时间
+	DefaultRequestTimeout = 15 * time.Second        // 默认单个请求的超时时间
+	restoreTimeout        = time.Minute             // 恢复原节点的超时时间, 不受调用方ctx取消影响
+)
+
+// DefaultTargets 默认测试的目标地址
+var DefaultTargets = []string{
+	"https://www.google.com/generate_204",
+	"https://www.youtube.com",
+	"https://github.com",
+}
+
+// Router 质量测试依赖的路由器接口, *openwrt.Router 实现了该接口
+type Router interface {
+	GetGlobalProxyNodeContext(ctx context.Context) (*openwrt.ProxyNodeInfo, error)
+	ApplyProxyNodeToGlobalContext(ctx context.Context, p *openwrt.ProxyNodeInfo) error
+}
+
+// Config 质量测试配置, 为空的字段使用默认值
+type Config struct {
+	Targets        []string      // 通过代理访问的目标地址
+	EgressURL      string        // 查询出口IP的地址, 需要在代理规则中走代理
+	DirectIP       string        // 不经过代理时的出口IP, 为空时测试前关闭代理查询一次
+	Settle         time.Duration // 切换节点后等待生效的时间
+	RequestTimeout time.Duration // 单个请求的超时时间
+}
+
+// TargetResult 访问一个目标地址的结果
+type TargetResult struct {
+	URL   string                  // 目标地址
+	Info  *curl.AccessLatencyInfo // DNS/连接/首字节耗时及下载速度, 请求失败时为空
+	Error string                  // 请求失败的原因
+}
+
+// OK 访问是否成功
+func (t *TargetResult) OK() bool {
+	return t.Error == "" && t.Info != nil && t.Info.Succeeded()
+}
+
+// Report 单个节点的质量报告
+type Report struct {
+	Node          *openwrt.ProxyNodeInfo // 被测节点
+	Time          time.Time              // 测试时间
+	EgressIP      string                 // 使用节点时的出口IP
+	EgressChanged bool                   // 出口IP是否与直连时不同, 即流量确实经过代理
+	Targets       []*TargetResult        // 各目标地址的结果
+	Error         string                 // 切换节点等导致整个测试失败的原因
+}
+
+// Passed 流量经过代理且所有目标均访问成功
+func (r *Report) Passed() bool {
+	if r.Error != "" || !r.EgressChanged {
+		return false
+	}
+	for _, target := range r.Targets {
+		if !target.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// Succeeded 访问成功的目标数量
+func (r *Report) Succeeded() (n int) {
+	for _, target := range r.Targets {
+		if target.OK() {
+			n++
+		}
+	}
+	return
+}
+
+// AvgStartTransfer 访问成功的目标的平均首字节耗时
+func (r *Report) AvgStartTransfer() time.Duration {
+	var total time.Duration
+	n := 0
+	for _, target := range r.Targets {
+		if target.OK() {
+			total += target.Info.TimeStartTransfer
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return total / time.Duration(n)
+}
+
+// AvgSpeed 访问成功的目标的平均下载速度, 字节每秒
+func (r *Report) AvgSpeed() float64 {
+	var total float64
+	n := 0
+	for _, target := range r.Targets {
+		if target.OK() {
+			total += target.Info.SpeedDownload
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return total / float64(n)
+}
+
+// Tester 端到端节点质量测试: 依次把节点应用为全局节点, 从本机经路由器访问目标地址,
+// 要求运行在路由器下的设备上
+type Tester struct {
+	router Router
+	conf   Config
+	trace  func(ctx context.Context, url string) (*curl.AccessLatencyInfo, error)
+	fetch  func(ctx context.Context, url string) (string, error)
+	sleep  func(ctx context.Context, d time.Duration) error
+}
+
+// NewTester 创建节点质量测试
+func NewTester(router Router, conf *Config) (t *Tester) {
+	t = &Tester{
+		router: router,
+		trace:  curl.Tracing,
+		fetch:  curl.Fetch,
+		sleep:  sleep,
+	}
+	if conf != nil {
+		t.conf = *conf
+	}
+	if len(t.conf.Targets) == 0 {
+		t.conf.Targets = DefaultTargets
+	}
+	if t.conf.EgressURL == "" {
+		t.conf.EgressURL = DefaultEgressURL
+	}
+	if t.conf.Settle <= 0 {
+		t.conf.Settle = DefaultSettle
+	}
+	if t.conf.RequestTimeout <= 0 {
+		t.conf.RequestTimeout = DefaultRequestTimeout
+	}
+	return
+}
+
+// Test 依次测试节点并返回每个节点的报告, 结束后(包括出错或ctx取消)恢复测试前的全局节点;
+// 单个节点切换失败只记录在报告中, 恢复原节点失败时返回错误
+func (t *Tester) Test(ctx context.Context, nodes ...*openwrt.ProxyNodeInfo) (reports []*Report, err error) {
+	original, err := t.router.GetGlobalProxyNodeContext(ctx)
+	if err != nil {
+		return
+	}
+	defer func() {
+		// 调用方ctx可能已取消, 恢复使用独立的超时
+		restoreCtx, cancel := context.WithTimeout(context.Background(), restoreTimeout)
+		defer cancel()
+		if restoreErr := t.router.ApplyProxyNodeToGlobalContext(restoreCtx, original); restoreErr != nil {
+			log.GetInstance().Errorf("[质量测试] 恢复原节点失败 %s", restoreErr.Error())
+			if err == nil {
+				err = fmt.Errorf("restore original node: %w", restoreErr)
+			}
+		}
+	}()
+
+	directIP := t.conf.DirectIP
+	if directIP == "" {
+		if directIP, err = t.egressIP(ctx, nil); err != nil {
+			err = fmt.Errorf("query direct egress ip: %w", err)
+			return
+		}
+	}
+
+	for _, node := range nodes {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		reports = append(reports, t.testNode(ctx, node, directIP))
+	}
+	return
+}
+
+// testNode 应用节点后查询出口IP并访问所有目标
+func (t *Tester) testNode(ctx context.Context, node *openwrt.ProxyNodeInfo, directIP string) (report *Report) {
+	report = &Report{Node: node, Time: time.Now()}
+	ip, err := t.egressIP(ctx, node)
+	if err != nil {
+		report.Error = err.Error()
+		return
+	}
+	report.EgressIP = ip
+	report.EgressChanged = ip != directIP
+
+	for _, url := range t.conf.Targets {
+		result := &TargetResult{URL: url}
+		reqCtx, cancel := context.WithTimeout(ctx, t.conf.RequestTimeout)
+		if result.Info, err = t.trace(reqCtx, url); err != nil {
+			result.Error = err.Error()
+		} else if !result.Info.Succeeded() {
+			result.Error = "unexpected http code " + result.Info.HttpCode
+		}
+		cancel()
+		report.Targets = append(report.Targets, result)
+	}
+	return
+}
+
+// egressIP 应用节点(为空时关闭代理)并等待生效后查询出口IP
+func (t *Tester) egressIP(ctx context.Context, node *openwrt.ProxyNodeInfo) (ip string, err error) {
+	if err = t.router.ApplyProxyNodeToGlobalContext(ctx, node); err != nil {
+		return
+	}
+	if err = t.sleep(ctx, t.conf.Settle); err != nil {
+		return
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, t.conf.RequestTimeout)
+	defer cancel()
+	if ip, err = t.fetch(reqCtx, t.conf.EgressURL); err != nil {
+		return
+	}
+	if net.ParseIP(ip) == nil {
+		err = fmt.Errorf("unexpected egress ip %q", ip)
+	}
+	return
+}
+
+// sleep 等待d或ctx取消
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,95 @@
+package quality
+
+import (
+	"context"
+	"errors"
+	"github.com/huge-kumo/net-utils/pkg/curl"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"testing"
+	"time"
+)
+
+type fakeRouter struct {
+	global  *openwrt.ProxyNodeInfo
+	applied []string
+	failId  string
+}
+
+func (f *fakeRouter) GetGlobalProxyNodeContext(ctx context.Context) (*openwrt.ProxyNodeInfo, error) {
+	return f.global, nil
+}
+
+func (f *fakeRouter) ApplyProxyNodeToGlobalContext(ctx context.Context, p *openwrt.ProxyNodeInfo) error {
+	id := "nil"
+	if p != nil {
+		id = p.Id
+	}
+	if id == f.failId {
+		return errors.New("apply failed")
+	}
+	f.applied = append(f.applied, id)
+	f.global = p
+	return nil
+}
+
+func newTestTester(router *fakeRouter) *Tester {
+	t := NewTester(router, &Config{Targets: []string{"https://a.example.com", "https://b.example.com"}})
+	t.sleep = func(ctx context.Context, d time.Duration) error { return ctx.Err() }
+	// 出口IP: 直连为1.1.1.1, cfg02 不转发流量
+	t.fetch = func(ctx context.Context, url string) (string, error) {
+		if router.global == nil || router.global.Id == "cfg02" {
+			return "1.1.1.1", nil
+		}
+		return "8.8.8.8", nil
+	}
+	t.trace = func(ctx context.Context, url string) (*curl.AccessLatencyInfo, error) {
+		if router.global != nil && router.global.Id == "cfg02" {
+			return &curl.AccessLatencyInfo{HttpCode: "000"}, nil
+		}
+		return &curl.AccessLatencyInfo{HttpCode: "200", TimeStartTransfer: 100 * time.Millisecond, SpeedDownload: 1024}, nil
+	}
+	return t
+}
+
+func TestTester_Test(t *testing.T) {
+	original := &openwrt.ProxyNodeInfo{Id: "cfg09"}
+	router := &fakeRouter{global: original, failId: "cfg03"}
+	tester := newTestTester(router)
+
+	reports, err := tester.Test(context.Background(),
+		&openwrt.ProxyNodeInfo{Id: "cfg01"}, &openwrt.ProxyNodeInfo{Id: "cfg02"}, &openwrt.ProxyNodeInfo{Id: "cfg03"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("got %d reports, want 3", len(reports))
+	}
+	good, leaking, failed := reports[0], reports[1], reports[2]
+	if !good.Passed() || good.EgressIP != "8.8.8.8" || good.Succeeded() != 2 || good.AvgStartTransfer() != 100*time.Millisecond || good.AvgSpeed() != 1024 {
+		t.Errorf("got good %+v", good)
+	}
+	if leaking.Passed() || leaking.EgressChanged || leaking.Succeeded() != 0 || leaking.Targets[0].Error == "" {
+		t.Errorf("got leaking %+v", leaking)
+	}
+	if failed.Passed() || failed.Error == "" || len(failed.Targets) != 0 {
+		t.Errorf("got failed %+v", failed)
+	}
+	if router.global != original || router.applied[0] != "nil" || router.applied[len(router.applied)-1] != "cfg09" {
+		t.Errorf("got global %+v applied %v", router.global, router.applied)
+	}
+}
+
+func TestTester_TestRestoresOnCancel(t *testing.T) {
+	router := &fakeRouter{}
+	tester := newTestTester(router)
+	tester.conf.DirectIP = "1.1.1.1"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := tester.Test(ctx, &openwrt.ProxyNodeInfo{Id: "cfg01"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if router.global != nil || len(router.applied) != 1 || router.applied[0] != "nil" {
+		t.Errorf("got global %+v applied %v", router.global, router.applied)
+	}
+}
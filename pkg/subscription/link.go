@@ -0,0 +1,316 @@
+package subscription
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// parseSS 解析ss链接, 兼容SIP002及旧版整体base64编码的格式
+func parseSS(link string) (node *Node, err error) {
+	node = &Node{Protocol: ProtocolSS}
+	rest := strings.TrimPrefix(link[len(ProtocolSS):], "://")
+	if rest, node.Remarks, err = cutFragment(rest); err != nil {
+		return
+	}
+	rest, query, _ := strings.Cut(rest, "?")
+	rest = strings.TrimSuffix(rest, "/")
+
+	// 旧版格式: base64(method:password@host:port)
+	if !strings.Contains(rest, "@") {
+		var data []byte
+		if data, err = DecodeBase64(rest); err != nil {
+			err = fmt.Errorf("decode ss link: %w", err)
+			return
+		}
+		rest = string(data)
+	}
+
+	// SIP002格式: base64url(method:password)@host:port 或 method:password@host:port
+	idx := strings.LastIndex(rest, "@")
+	if idx < 0 {
+		err = fmt.Errorf("invalid ss link %q", link)
+		return
+	}
+	userInfo, hostPort := rest[:idx], rest[idx+1:]
+	if !strings.Contains(userInfo, ":") {
+		var data []byte
+		if data, err = DecodeBase64(userInfo); err != nil {
+			err = fmt.Errorf("decode ss user info: %w", err)
+			return
+		}
+		userInfo = string(data)
+	} else if userInfo, err = url.PathUnescape(userInfo); err != nil {
+		return
+	}
+	var ok bool
+	if node.Cipher, node.Password, ok = strings.Cut(userInfo, ":"); !ok {
+		err = fmt.Errorf("invalid ss user info in %q", link)
+		return
+	}
+	if node.Host, node.Port, err = splitHostPort(hostPort); err != nil {
+		return
+	}
+
+	// 插件参数: plugin=obfs-local;obfs=http;obfs-host=example.com
+	if query != "" {
+		var values url.Values
+		if values, err = url.ParseQuery(query); err != nil {
+			return
+		}
+		if plugin := values.Get("plugin"); plugin != "" {
+			node.Plugin, node.PluginOpts, _ = strings.Cut(plugin, ";")
+			for _, opt := range strings.Split(node.PluginOpts, ";") {
+				key, val, _ := strings.Cut(opt, "=")
+				switch key {
+				case "obfs":
+					node.Obfs = val
+				case "obfs-host":
+					node.ObfsParam = val
+				}
+			}
+		}
+	}
+	return
+}
+
+// parseSSR 解析ssr链接: base64url(host:port:protocol:method:obfs:base64url(password)/?params)
+func parseSSR(link string) (node *Node, err error) {
+	data, err := DecodeBase64(strings.TrimPrefix(link[len(ProtocolSSR):], "://"))
+	if err != nil {
+		err = fmt.Errorf("decode ssr link: %w", err)
+		return
+	}
+	main, query, _ := strings.Cut(string(data), "/?")
+	if strings.Contains(main, "?") {
+		main, query, _ = strings.Cut(main, "?")
+	}
+
+	// 从右侧切分以兼容IPv6地址
+	fields := make([]string, 6)
+	for i := 5; i > 0; i-- {
+		idx := strings.LastIndex(main, ":")
+		if idx < 0 {
+			err = fmt.Errorf("invalid ssr link %q", link)
+			return
+		}
+		fields[i], main = main[idx+1:], main[:idx]
+	}
+	fields[0] = strings.Trim(main, "[]")
+
+	node = &Node{
+		Protocol:    ProtocolSSR,
+		Host:        fields[0],
+		SSRProtocol: fields[2],
+		Cipher:      fields[3],
+		Obfs:        fields[4],
+	}
+	if node.Port, err = parsePort(fields[1]); err != nil {
+		return
+	}
+	password, err := DecodeBase64(fields[5])
+	if err != nil {
+		err = fmt.Errorf("decode ssr password: %w", err)
+		return
+	}
+	node.Password = string(password)
+
+	// 参数值均为base64url编码, 不能使用url.ParseQuery以免把'+'当作空格
+	for _, pair := range strings.Split(query, "&") {
+		key, val, _ := strings.Cut(pair, "=")
+		if val == "" {
+			continue
+		}
+		var decoded []byte
+		if decoded, err = DecodeBase64(val); err != nil {
+			err = fmt.Errorf("decode ssr param %s: %w", key, err)
+			return
+		}
+		switch key {
+		case "obfsparam":
+			node.ObfsParam = string(decoded)
+		case "protoparam":
+			node.SSRProtocolParam = string(decoded)
+		case "remarks":
+			node.Remarks = string(decoded)
+		case "group":
+			node.Group = string(decoded)
+		}
+	}
+	return
+}
+
+// flexString 兼容字符串和数字两种写法的JSON字段
+type flexString string
+
+func (f *flexString) UnmarshalJSON(data []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(data, &s); err == nil {
+		*f = flexString(s)
+		return
+	}
+	var n json.Number
+	if err = json.Unmarshal(data, &n); err != nil {
+		return
+	}
+	*f = flexString(n.String())
+	return
+}
+
+// parseVMess 解析vmess链接: base64(json)
+func parseVMess(link string) (node *Node, err error) {
+	data, err := DecodeBase64(strings.TrimPrefix(link[len(ProtocolVMess):], "://"))
+	if err != nil {
+		err = fmt.Errorf("decode vmess link: %w", err)
+		return
+	}
+	conf := &struct {
+		Ps            string     `json:"ps"`
+		Add           string     `json:"add"`
+		Port          flexString `json:"port"`
+		Id            string     `json:"id"`
+		Aid           flexString `json:"aid"`
+		Scy           string     `json:"scy"`
+		Net           string     `json:"net"`
+		Host          string     `json:"host"`
+		Path          string     `json:"path"`
+		Tls           string     `json:"tls"`
+		Sni           string     `json:"sni"`
+		AllowInsecure flexString `json:"allowInsecure"`
+	}{}
+	if err = json.Unmarshal(data, conf); err != nil {
+		err = fmt.Errorf("decode vmess json: %w", err)
+		return
+	}
+
+	node = &Node{
+		Protocol:      ProtocolVMess,
+		Remarks:       conf.Ps,
+		Host:          conf.Add,
+		UUID:          conf.Id,
+		Cipher:        conf.Scy,
+		Network:       conf.Net,
+		Path:          conf.Path,
+		HostHeader:    conf.Host,
+		Security:      conf.Tls,
+		SNI:           conf.Sni,
+		AllowInsecure: isTrue(string(conf.AllowInsecure)),
+	}
+	if node.Cipher == "" {
+		node.Cipher = "auto"
+	}
+	if node.Network == "" {
+		node.Network = "tcp"
+	}
+	if node.Port, err = parsePort(string(conf.Port)); err != nil {
+		return
+	}
+	if conf.Aid != "" {
+		if node.AlterId, err = strconv.Atoi(string(conf.Aid)); err != nil {
+			err = fmt.Errorf("invalid vmess alter id %q", conf.Aid)
+			return
+		}
+	}
+	return
+}
+
+// parseVLESS 解析vless链接: vless://uuid@host:port?params#remarks
+func parseVLESS(link string) (node *Node, err error) {
+	node, values, err := parseURLLink(link, ProtocolVLESS)
+	if err != nil {
+		return
+	}
+	node.UUID, node.Password = node.Password, ""
+	node.Cipher = values.Get("encryption")
+	if node.Cipher == "" {
+		node.Cipher = "none"
+	}
+	node.Security = values.Get("security")
+	if node.Security == "none" {
+		node.Security = ""
+	}
+	node.Flow = values.Get("flow")
+	return
+}
+
+// parseTrojan 解析trojan链接: trojan://password@host:port?params#remarks
+func parseTrojan(link string) (node *Node, err error) {
+	node, values, err := parseURLLink(link, ProtocolTrojan)
+	if err != nil {
+		return
+	}
+	node.Security = values.Get("security")
+	if node.Security == "" {
+		node.Security = "tls"
+	}
+	if node.SNI == "" {
+		node.SNI = values.Get("peer")
+	}
+	return
+}
+
+// parseURLLink 解析vless/trojan共用的URL格式链接
+func parseURLLink(link, protocol string) (node *Node, values url.Values, err error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return
+	}
+	if u.User == nil || u.User.Username() == "" {
+		err = fmt.Errorf("missing %s credential in %q", protocol, link)
+		return
+	}
+	values = u.Query()
+	node = &Node{
+		Protocol:      protocol,
+		Remarks:       u.Fragment,
+		Host:          u.Hostname(),
+		Password:      u.User.Username(),
+		Network:       values.Get("type"),
+		HostHeader:    values.Get("host"),
+		SNI:           values.Get("sni"),
+		AllowInsecure: isTrue(values.Get("allowInsecure")),
+	}
+	if node.Network == "" {
+		node.Network = "tcp"
+	}
+	node.Path = values.Get("path")
+	if node.Network == "grpc" {
+		node.Path = values.Get("serviceName")
+	}
+	node.Port, err = parsePort(u.Port())
+	return
+}
+
+// splitHostPort 切分主机地址和端口
+func splitHostPort(hostPort string) (host string, port int, err error) {
+	host, p, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return
+	}
+	port, err = parsePort(p)
+	return
+}
+
+// parsePort 解析端口号
+func parsePort(s string) (port int, err error) {
+	port, err = strconv.Atoi(s)
+	if err != nil || port <= 0 || port > 65535 {
+		err = fmt.Errorf("invalid port %q", s)
+	}
+	return
+}
+
+// cutFragment 切分并解码链接中的 #备注
+func cutFragment(s string) (rest, fragment string, err error) {
+	rest, fragment, _ = strings.Cut(s, "#")
+	fragment, err = url.PathUnescape(fragment)
+	return
+}
+
+// isTrue 判断链接参数是否为真值
+func isTrue(s string) bool {
+	return s == "1" || strings.EqualFold(s, "true")
+}
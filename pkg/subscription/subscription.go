@@ -0,0 +1,184 @@
+package subscription
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/huge-kumo/net-utils/pkg/openwrt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPClient 下载订阅使用的HTTP客户端
+var HTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// UserAgent 下载订阅时使用的User-Agent, 部分机场根据它返回不同格式
+var UserAgent = "net-utils/subscription"
+
+var ErrUnsupportedLink = errors.New("unsupported share link") // 不支持的分享链接协议
+
+// 分享链接协议
+const (
+	ProtocolSS     = "ss"
+	ProtocolSSR    = "ssr"
+	ProtocolVMess  = "vmess"
+	ProtocolVLESS  = "vless"
+	ProtocolTrojan = "trojan"
+)
+
+// Node 分享链接解析出的代理节点
+type Node struct {
+	Protocol string // 协议, 取值为 Protocol* 常量
+	Remarks  string // 节点备注名称
+	Group    string // 节点分组, 仅ssr链接携带
+	Host     string // 服务器地址
+	Port     int    // 服务器端口
+
+	Password string // ss/ssr/trojan密码
+	UUID     string // vmess/vless用户编号
+	Cipher   string // 加密方式, vmess为security, vless为encryption
+	AlterId  int    // vmess额外编号
+
+	SSRProtocol      string // ssr协议插件
+	SSRProtocolParam string // ssr协议插件参数
+	Obfs             string // 混淆方式, ssr的obfs或ss的obfs插件模式
+	ObfsParam        string // 混淆参数, ssr的obfsparam或ss的obfs-host
+	Plugin           string // ss插件名称
+	PluginOpts       string // ss插件参数
+
+	Network       string // 传输方式, 如tcp/ws/grpc/h2
+	Path          string // ws/h2路径或grpc服务名称
+	HostHeader    string // ws/h2伪装域名
+	Security      string // 传输层安全, 如tls/reality, 为空表示不加密
+	SNI           string // TLS服务器名称
+	Flow          string // vless流控
+	AllowInsecure bool   // 跳过TLS证书校验
+
+	Link string // 原始分享链接
+}
+
+// ProxyNodeInfo 转换为路由器节点信息, 节点编号由路由器分配因此为空
+func (n *Node) ProxyNodeInfo() *openwrt.ProxyNodeInfo {
+	return &openwrt.ProxyNodeInfo{
+		Name: n.Remarks,
+		Host: n.Host,
+		Port: strconv.Itoa(n.Port),
+	}
+}
+
+// Fetch 下载订阅内容
+func Fetch(ctx context.Context, url string) (body []byte, err error) {
+	// 构建请求
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("User-Agent", UserAgent)
+
+	// 服务请求
+	rsp, err := HTTPClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = rsp.Body.Close()
+	}()
+	if rsp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("fetch subscription status code %d", rsp.StatusCode)
+		return
+	}
+	return ioutil.ReadAll(rsp.Body)
+}
+
+// FetchNodes 下载并解析订阅中的所有节点
+func FetchNodes(ctx context.Context, url string) (nodes []*Node, err error) {
+	body, err := Fetch(ctx, url)
+	if err != nil {
+		return
+	}
+	return Decode(body)
+}
+
+// Decode 解析订阅内容, 兼容base64/base64url编码及明文链接列表; 无法解析的行会被跳过
+func Decode(body []byte) (nodes []*Node, err error) {
+	body = bytes.TrimSpace(body)
+	if !bytes.Contains(body, []byte("://")) {
+		if body, err = DecodeBase64(string(body)); err != nil {
+			err = fmt.Errorf("decode subscription: %w", err)
+			return
+		}
+	}
+
+	var lastErr error
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		node, parseErr := ParseLink(line)
+		if parseErr != nil {
+			lastErr = parseErr
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	if len(nodes) == 0 && lastErr != nil {
+		err = lastErr
+	}
+	return
+}
+
+// ParseLink 解析单个分享链接
+func ParseLink(link string) (node *Node, err error) {
+	link = strings.TrimSpace(link)
+	idx := strings.Index(link, "://")
+	if idx < 0 {
+		err = fmt.Errorf("%w: %q", ErrUnsupportedLink, link)
+		return
+	}
+	switch strings.ToLower(link[:idx]) {
+	case ProtocolSS:
+		node, err = parseSS(link)
+	case ProtocolSSR:
+		node, err = parseSSR(link)
+	case ProtocolVMess:
+		node, err = parseVMess(link)
+	case ProtocolVLESS:
+		node, err = parseVLESS(link)
+	case ProtocolTrojan:
+		node, err = parseTrojan(link)
+	default:
+		err = fmt.Errorf("%w: %s", ErrUnsupportedLink, link[:idx])
+	}
+	if err != nil {
+		return
+	}
+	node.Link = link
+	return
+}
+
+// DecodeBase64 宽松地解码base64/base64url, 兼容缺少填充及包含换行的内容
+func DecodeBase64(s string) (data []byte, err error) {
+	s = strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
@@ -0,0 +1,67 @@
+package subscription
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+var testLinks = []string{
+	"ssr://aGsuZXhhbXBsZS5jb206ODM4ODphdXRoX2FlczEyOF9tZDU6YWVzLTI1Ni1jZmI6dGxzMS4yX3RpY2tldF9hdXRoOmNFQnpjdy8_b2Jmc3BhcmFtPVpHOTNibXh2WVdRdWQybHVaRzkzYzNWd1pHRjBaUzVqYjIwJnByb3RvcGFyYW09TXpJNllXSmomcmVtYXJrcz04Si1IcmZDZmg3QWc2YWFaNXJpdklEQXgmZ3JvdXA9NXB5NjVaeTY",
+	"vmess://eyJ2IjogIjIiLCAicHMiOiAi5pel5pysIDAyIiwgImFkZCI6ICJqcC5leGFtcGxlLmNvbSIsICJwb3J0IjogNDQzLCAiaWQiOiAiYjgzMTM4MWQtNjMyNC00ZDUzLWFkNGYtOGNkYTQ4YjMwODExIiwgImFpZCI6ICIwIiwgInNjeSI6ICIiLCAibmV0IjogIndzIiwgInR5cGUiOiAibm9uZSIsICJob3N0IjogImNkbi5leGFtcGxlLmNvbSIsICJwYXRoIjogIi9yYXkiLCAidGxzIjogInRscyIsICJzbmkiOiAianAuZXhhbXBsZS5jb20ifQ==",
+	"ss://YWVzLTI1Ni1nY206c2VjcmV0QHNnLmV4YW1wbGUuY29tOjgzODg=#%E6%96%B0%E5%8A%A0%E5%9D%A1",
+	"ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpwdw@[2001:db8::1]:443/?plugin=obfs-local%3Bobfs%3Dhttp%3Bobfs-host%3Dexample.com#US%2001",
+	"vless://b831381d-6324-4d53-ad4f-8cda48b30811@us.example.com:443?encryption=none&security=reality&sni=www.apple.com&type=grpc&serviceName=gun&flow=xtls-rprx-vision#US%20VLESS",
+	"trojan://pa%23ss@tw.example.com:443?peer=tw.example.com&allowInsecure=1#TW",
+}
+
+func TestParseLink(t *testing.T) {
+	want := []Node{
+		{Protocol: ProtocolSSR, Remarks: "🇭🇰 香港 01", Group: "机场", Host: "hk.example.com", Port: 8388, Password: "p@ss", Cipher: "aes-256-cfb",
+			SSRProtocol: "auth_aes128_md5", SSRProtocolParam: "32:abc", Obfs: "tls1.2_ticket_auth", ObfsParam: "download.windowsupdate.com"},
+		{Protocol: ProtocolVMess, Remarks: "日本 02", Host: "jp.example.com", Port: 443, UUID: "b831381d-6324-4d53-ad4f-8cda48b30811", Cipher: "auto",
+			Network: "ws", Path: "/ray", HostHeader: "cdn.example.com", Security: "tls", SNI: "jp.example.com"},
+		{Protocol: ProtocolSS, Remarks: "新加坡", Host: "sg.example.com", Port: 8388, Password: "secret", Cipher: "aes-256-gcm"},
+		{Protocol: ProtocolSS, Remarks: "US 01", Host: "2001:db8::1", Port: 443, Password: "pw", Cipher: "chacha20-ietf-poly1305",
+			Plugin: "obfs-local", PluginOpts: "obfs=http;obfs-host=example.com", Obfs: "http", ObfsParam: "example.com"},
+		{Protocol: ProtocolVLESS, Remarks: "US VLESS", Host: "us.example.com", Port: 443, UUID: "b831381d-6324-4d53-ad4f-8cda48b30811", Cipher: "none",
+			Network: "grpc", Path: "gun", Security: "reality", SNI: "www.apple.com", Flow: "xtls-rprx-vision"},
+		{Protocol: ProtocolTrojan, Remarks: "TW", Host: "tw.example.com", Port: 443, Password: "pa#ss", Network: "tcp", Security: "tls", SNI: "tw.example.com", AllowInsecure: true},
+	}
+	for i, link := range testLinks {
+		node, err := ParseLink(link)
+		if err != nil {
+			t.Fatalf("parse %s: %v", link, err)
+		}
+		want[i].Link = link
+		if *node != want[i] {
+			t.Errorf("got %+v\nwant %+v", *node, want[i])
+		}
+	}
+
+	if _, err := ParseLink("http://example.com"); err == nil {
+		t.Error("expected unsupported link error")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	body := strings.Join(append(testLinks, "http://not-a-proxy"), "\n")
+	for name, encoded := range map[string]string{
+		"plain":     body,
+		"base64":    base64.StdEncoding.EncodeToString([]byte(body)),
+		"base64url": base64.RawURLEncoding.EncodeToString([]byte(body)),
+	} {
+		nodes, err := Decode([]byte(encoded))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(nodes) != len(testLinks) {
+			t.Errorf("%s: got %d nodes, want %d", name, len(nodes), len(testLinks))
+		}
+	}
+
+	pn := (&Node{Remarks: "香港 01", Host: "hk.example.com", Port: 8388}).ProxyNodeInfo()
+	if pn.Name != "香港 01" || pn.Host != "hk.example.com" || pn.Port != "8388" {
+		t.Errorf("got %+v", pn)
+	}
+}